@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/xiaoxuxiansheng/goredis/datastore"
 	"github.com/xiaoxuxiansheng/goredis/persist"
 )
 
@@ -20,6 +21,12 @@ type Config struct {
 	AppendFileName_         string `cfg:"appendfilename"`              // aof 文件名称
 	AppendFsync_            string `cfg:"appendfsync"`                 // aof 级别
 	AutoAofRewriteAfterCmd_ int    `cfg:"auto-aof-rewrite-after-cmds"` // 每执行多少次 aof 操作后，进行一次重写
+
+	SetMaxIntsetEntries_    int `cfg:"set-max-intset-entries"`    // set 采用 intset 编码的最大元素个数
+	HashMaxListpackEntries_ int `cfg:"hash-max-listpack-entries"` // hash 采用 listpack 编码的最大字段个数
+	HashMaxListpackValue_   int `cfg:"hash-max-listpack-value"`   // hash 采用 listpack 编码时字段与值的最大长度
+	ZSetMaxListpackEntries_ int `cfg:"zset-max-listpack-entries"` // zset 采用 listpack 编码的最大元素个数
+	ZSetMaxListpackValue_   int `cfg:"zset-max-listpack-value"`   // zset 采用 listpack 编码时 member 的最大长度
 }
 
 func (c *Config) Address() string {
@@ -42,6 +49,26 @@ func (c *Config) AutoAofRewriteAfterCmd() int {
 	return c.AutoAofRewriteAfterCmd_
 }
 
+func (c *Config) SetMaxIntsetEntries() int {
+	return c.SetMaxIntsetEntries_
+}
+
+func (c *Config) HashMaxListpackEntries() int {
+	return c.HashMaxListpackEntries_
+}
+
+func (c *Config) HashMaxListpackValue() int {
+	return c.HashMaxListpackValue_
+}
+
+func (c *Config) ZSetMaxListpackEntries() int {
+	return c.ZSetMaxListpackEntries_
+}
+
+func (c *Config) ZSetMaxListpackValue() int {
+	return c.ZSetMaxListpackValue_
+}
+
 var (
 	confOnce   sync.Once
 	globalConf *Config
@@ -51,6 +78,10 @@ func PersistThinker() persist.Thinker {
 	return SetUpConfig()
 }
 
+func DataStoreThinker() datastore.Thinker {
+	return SetUpConfig()
+}
+
 func SetUpConfig() *Config {
 	confOnce.Do(func() {
 		defer func() {
@@ -96,7 +127,8 @@ func setUpConfig(src io.Reader) *Config {
 		return nil
 	}
 
-	conf := &Config{}
+	// 配置文件中未声明的属性采用默认值
+	conf := defaultConf()
 	// 通过反射设置 conf 属性值
 	t := reflect.TypeOf(conf)
 	v := reflect.ValueOf(conf)
@@ -130,5 +162,11 @@ func defaultConf() *Config {
 		Bind:        "0.0.0.0",
 		Port:        6379,
 		AppendOnly_: false, // 默认不启用 aof
+		// 紧凑编码的阈值与 redis 默认值保持一致
+		SetMaxIntsetEntries_:    512,
+		HashMaxListpackEntries_: 128,
+		HashMaxListpackValue_:   64,
+		ZSetMaxListpackEntries_: 128,
+		ZSetMaxListpackValue_:   64,
 	}
 }
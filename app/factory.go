@@ -21,6 +21,7 @@ func init() {
 	// 配置加载 conf
 	_ = container.Provide(SetUpConfig)
 	_ = container.Provide(PersistThinker)
+	_ = container.Provide(DataStoreThinker)
 	// 日志打印 logger
 	_ = container.Provide(log.GetDefaultLogger)
 
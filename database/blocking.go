@@ -0,0 +1,155 @@
+package database
+
+import (
+	"time"
+
+	"github.com/xiaoxuxiansheng/goredis/handler"
+	"github.com/xiaoxuxiansheng/goredis/lib/pool"
+)
+
+// 阻塞指令的等待信息
+type blockInfo struct {
+	keys         [][]byte
+	timeout      time.Duration
+	timeoutReply handler.Reply
+	// 指令被唤醒后关闭，通知 watcher 退出
+	done chan struct{}
+}
+
+// 记录阻塞在各个 key 上的指令. 仅在 executor 的 goroutine 中访问，无需加锁
+type blockingKeys struct {
+	// 同一个 key 上的指令按照阻塞的先后顺序排列，先到先得
+	waiters map[string][]*Command
+	// 发生过写操作，可能已经就绪的 key
+	ready    []string
+	readySet map[string]struct{}
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		waiters:  make(map[string][]*Command),
+		readySet: make(map[string]struct{}),
+	}
+}
+
+func (b *blockingKeys) add(cmd *Command, keys [][]byte) {
+	for _, key := range keys {
+		waiters := b.waiters[string(key)]
+		// 同一条指令中重复的 key 只记录一次
+		if len(waiters) > 0 && waiters[len(waiters)-1] == cmd {
+			continue
+		}
+		b.waiters[string(key)] = append(waiters, cmd)
+	}
+}
+
+func (b *blockingKeys) remove(cmd *Command, keys [][]byte) {
+	for _, key := range keys {
+		waiters := b.waiters[string(key)]
+		for i, waiter := range waiters {
+			if waiter != cmd {
+				continue
+			}
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+
+		if len(waiters) == 0 {
+			delete(b.waiters, string(key))
+		} else {
+			b.waiters[string(key)] = waiters
+		}
+	}
+}
+
+// key 上存在阻塞的指令时，标记为就绪
+func (b *blockingKeys) signal(key string) {
+	if len(b.waiters[key]) == 0 {
+		return
+	}
+	if _, ok := b.readySet[key]; ok {
+		return
+	}
+	b.readySet[key] = struct{}{}
+	b.ready = append(b.ready, key)
+}
+
+func (b *blockingKeys) nextReady() (string, bool) {
+	if len(b.ready) == 0 {
+		return "", false
+	}
+	key := b.ready[0]
+	b.ready = b.ready[1:]
+	delete(b.readySet, key)
+	return key, true
+}
+
+// 指令进入阻塞状态，由 watcher 负责监听超时以及连接断开
+func (e *DBExecutor) block(cmd *Command) {
+	info := cmd.block
+	info.done = make(chan struct{})
+	e.blocking.add(cmd, info.keys)
+	pool.Submit(func() {
+		e.watch(cmd, info)
+	})
+}
+
+func (e *DBExecutor) watch(cmd *Command, info *blockInfo) {
+	var timeout <-chan time.Time
+	if info.timeout > 0 {
+		timer := time.NewTimer(info.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-info.done:
+		return
+	case <-timeout:
+	case <-cmd.ctx.Done():
+	}
+
+	// 交由 executor 处理，避免并发访问
+	select {
+	case e.unblockCh <- cmd:
+	case <-info.done:
+	case <-e.ctx.Done():
+	}
+}
+
+// 指令超时或者连接断开，结束等待
+func (e *DBExecutor) unblock(cmd *Command) {
+	// 指令已经被唤醒
+	if cmd.block == nil {
+		return
+	}
+
+	info := cmd.block
+	e.blocking.remove(cmd, info.keys)
+	cmd.block = nil
+	close(info.done)
+	cmd.receiver <- info.timeoutReply
+}
+
+// 依次处理就绪的 key，按照阻塞的先后顺序重新执行等待中的指令
+func (e *DBExecutor) serveBlocked() {
+	for key, ok := e.blocking.nextReady(); ok; key, ok = e.blocking.nextReady() {
+		waiters := append([]*Command{}, e.blocking.waiters[key]...)
+		for _, cmd := range waiters {
+			info := cmd.block
+			cmd.block = nil
+			cmd.rewritten, cmd.rewrites = false, nil
+
+			reply := e.exec(cmd)
+			if cmd.block != nil {
+				// 指令仍然不满足条件，继续等待. 后续的指令不一定同样阻塞，例如 stream 的不同消费组互不影响，因此继续检查
+				cmd.block = info
+				continue
+			}
+
+			e.blocking.remove(cmd, info.keys)
+			close(info.done)
+			cmd.receiver <- reply
+		}
+	}
+}
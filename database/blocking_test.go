@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// 仅实现 lpush 与 blpop 的 DataStore
+type fakeListStore struct {
+	DataStore
+	lists map[string][][]byte
+}
+
+func (f *fakeListStore) ExpirePreprocess(string) {}
+
+func (f *fakeListStore) LPush(cmd *Command) handler.Reply {
+	key := string(cmd.Args()[0])
+	for _, arg := range cmd.Args()[1:] {
+		f.lists[key] = append([][]byte{arg}, f.lists[key]...)
+	}
+	return handler.NewIntReply(int64(len(f.lists[key])))
+}
+
+func (f *fakeListStore) BLPop(cmd *Command) handler.Reply {
+	args := cmd.Args()
+	for _, key := range args[:len(args)-1] {
+		list := f.lists[string(key)]
+		if len(list) == 0 {
+			continue
+		}
+		f.lists[string(key)] = list[1:]
+		cmd.Rewrite([][]byte{[]byte(CmdTypeLPop), key})
+		return handler.NewMultiBulkReply([][]byte{key, list[0]})
+	}
+
+	timeout, _ := strconv.ParseFloat(string(args[len(args)-1]), 64)
+	cmd.Block(args[:len(args)-1], time.Duration(timeout*float64(time.Second)), handler.NewNillMultiBulkReply())
+	return nil
+}
+
+type fakePersister struct {
+	handler.Persister
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (f *fakePersister) PersistCmd(_ context.Context, cmd [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, string(cmd[0])+" "+string(cmd[1]))
+}
+
+func Test_blocking_executor(t *testing.T) {
+	persister := &fakePersister{}
+	trigger := NewDBTrigger(NewDBExecutor(&fakeListStore{lists: make(map[string][][]byte)}, persister))
+	defer trigger.Close()
+
+	do := func(ctx context.Context, args ...string) handler.Reply {
+		cmdLine := make([][]byte, 0, len(args))
+		for _, arg := range args {
+			cmdLine = append(cmdLine, []byte(arg))
+		}
+		return trigger.Do(ctx, cmdLine)
+	}
+	pair := func(key, value string) handler.Reply {
+		return handler.NewMultiBulkReply([][]byte{[]byte(key), []byte(value)})
+	}
+
+	t.Run("fifo", func(t *testing.T) {
+		replies := make([]chan handler.Reply, 3)
+		for i := range replies {
+			replies[i] = make(chan handler.Reply, 1)
+			go func(ch chan handler.Reply) {
+				ch <- do(context.Background(), "blpop", "x", "k", "0")
+			}(replies[i])
+			// 保证阻塞的先后顺序
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		assert.Equal(t, handler.NewIntReply(2), do(context.Background(), "lpush", "k", "a", "b"))
+		assert.Equal(t, pair("k", "b"), <-replies[0])
+		assert.Equal(t, pair("k", "a"), <-replies[1])
+
+		do(context.Background(), "lpush", "x", "c")
+		assert.Equal(t, pair("x", "c"), <-replies[2])
+		assert.Equal(t, []string{"lpush k", "lpop k", "lpop k", "lpush x", "lpop x"}, persister.cmds)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		begin := time.Now()
+		assert.Equal(t, handler.NewNillMultiBulkReply(), do(context.Background(), "blpop", "k", "0.05"))
+		assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		assert.Equal(t, handler.NewNillMultiBulkReply(), do(ctx, "blpop", "k", "0"))
+
+		// 已取消的指令不再消费数据
+		do(context.Background(), "lpush", "k", "a")
+		assert.Equal(t, pair("k", "a"), do(context.Background(), "blpop", "k", "0"))
+	})
+}
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// 指令属性标识
+type CmdFlag uint32
+
+const (
+	CmdFlagWrite    CmdFlag = 1 << iota // 写指令. 执行成功后需要进行持久化
+	CmdFlagReadOnly                     // 只读指令
+	CmdFlagFast                         // 时间复杂度为 O(1) 或 O(logN) 的指令
+)
+
+// 指令分类
+type CmdCategory string
+
+const (
+	CmdCategoryKeyspace    CmdCategory = "keyspace"
+	CmdCategoryString      CmdCategory = "string"
+	CmdCategoryBitmap      CmdCategory = "bitmap"
+	CmdCategoryHyperLogLog CmdCategory = "hyperloglog"
+	CmdCategoryList        CmdCategory = "list"
+	CmdCategorySet         CmdCategory = "set"
+	CmdCategoryHash        CmdCategory = "hash"
+	CmdCategorySortedSet   CmdCategory = "sortedset"
+	CmdCategoryStream      CmdCategory = "stream"
+	CmdCategoryGeo         CmdCategory = "geo"
+	CmdCategoryConnection  CmdCategory = "connection"
+	CmdCategoryServer      CmdCategory = "server"
+)
+
+type CmdHandler func(DataStore, *Command) handler.Reply
+
+// 指令描述. 声明指令的参数个数、读写属性、key 的位置以及所属分类
+type cmdSpec struct {
+	name    CmdType
+	handler CmdHandler
+	// 参数个数，包含指令名本身. 正数表示个数固定，负数表示个数不少于其绝对值
+	arity int
+	flags CmdFlag
+	// key 在指令行中的位置，指令名的下标为 0. lastKey 为负数时表示从尾部倒数，firstKey 为 0 表示不涉及 key
+	firstKey, lastKey, keyStep int
+	category                   CmdCategory
+}
+
+// 指令表
+var cmdTable = newCmdTable([]*cmdSpec{
+	// connection && server
+	{CmdTypePing, ping, -1, CmdFlagFast, 0, 0, 0, CmdCategoryConnection},
+	{CmdTypeDBSize, DataStore.DBSize, 1, CmdFlagReadOnly | CmdFlagFast, 0, 0, 0, CmdCategoryServer},
+	{CmdTypeFlushAll, DataStore.FlushAll, -1, CmdFlagWrite, 0, 0, 0, CmdCategoryServer},
+
+	// keyspace
+	{CmdTypeDel, DataStore.Del, -2, CmdFlagWrite, 1, -1, 1, CmdCategoryKeyspace},
+	{CmdTypeUnlink, DataStore.Del, -2, CmdFlagWrite | CmdFlagFast, 1, -1, 1, CmdCategoryKeyspace},
+	{CmdTypeExists, DataStore.Exists, -2, CmdFlagReadOnly | CmdFlagFast, 1, -1, 1, CmdCategoryKeyspace},
+	{CmdTypeType, DataStore.Type, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypeRename, DataStore.Rename, 3, CmdFlagWrite, 1, 2, 1, CmdCategoryKeyspace},
+	{CmdTypeRenameNX, DataStore.RenameNX, 3, CmdFlagWrite | CmdFlagFast, 1, 2, 1, CmdCategoryKeyspace},
+	{CmdTypeCopy, DataStore.Copy, -3, CmdFlagWrite, 1, 2, 1, CmdCategoryKeyspace},
+	{CmdTypeTouch, DataStore.Touch, -2, CmdFlagReadOnly | CmdFlagFast, 1, -1, 1, CmdCategoryKeyspace},
+	{CmdTypeExpire, DataStore.Expire, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypePExpire, DataStore.PExpire, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypeExpireAt, DataStore.ExpireAt, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypePExpireAt, DataStore.PExpireAt, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypeTTL, DataStore.TTL, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypePTTL, DataStore.PTTL, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypeExpireTime, DataStore.ExpireTime, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypePExpireTime, DataStore.PExpireTime, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypePersist, DataStore.Persist, 2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryKeyspace},
+	{CmdTypeObject, DataStore.Object, -2, CmdFlagReadOnly, 2, 2, 1, CmdCategoryKeyspace},
+
+	// string
+	{CmdTypeGet, DataStore.Get, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeSet, DataStore.Set, -3, CmdFlagWrite, 1, 1, 1, CmdCategoryString},
+	{CmdTypeMGet, DataStore.MGet, -2, CmdFlagReadOnly | CmdFlagFast, 1, -1, 1, CmdCategoryString},
+	{CmdTypeMSet, DataStore.MSet, -3, CmdFlagWrite, 1, -1, 2, CmdCategoryString},
+	{CmdTypeIncr, DataStore.Incr, 2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeDecr, DataStore.Decr, 2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeIncrBy, DataStore.IncrBy, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeDecrBy, DataStore.DecrBy, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeIncrByFloat, DataStore.IncrByFloat, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeAppend, DataStore.Append, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeStrLen, DataStore.StrLen, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeGetRange, DataStore.GetRange, 4, CmdFlagReadOnly, 1, 1, 1, CmdCategoryString},
+	{CmdTypeSetRange, DataStore.SetRange, 4, CmdFlagWrite, 1, 1, 1, CmdCategoryString},
+	{CmdTypeGetSet, DataStore.GetSet, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeGetDel, DataStore.GetDel, 2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeGetEx, DataStore.GetEx, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeSetNX, DataStore.SetNX, 3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryString},
+	{CmdTypeMSetNX, DataStore.MSetNX, -3, CmdFlagWrite, 1, -1, 2, CmdCategoryString},
+
+	// bitmap
+	{CmdTypeSetBit, DataStore.SetBit, 4, CmdFlagWrite, 1, 1, 1, CmdCategoryBitmap},
+	{CmdTypeGetBit, DataStore.GetBit, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryBitmap},
+	{CmdTypeBitCount, DataStore.BitCount, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryBitmap},
+	{CmdTypeBitPos, DataStore.BitPos, -3, CmdFlagReadOnly, 1, 1, 1, CmdCategoryBitmap},
+	{CmdTypeBitOp, DataStore.BitOp, -4, CmdFlagWrite, 2, -1, 1, CmdCategoryBitmap},
+	{CmdTypeBitField, DataStore.BitField, -2, CmdFlagWrite, 1, 1, 1, CmdCategoryBitmap},
+	{CmdTypeBitFieldRO, DataStore.BitFieldRO, -2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryBitmap},
+
+	// hyperloglog
+	{CmdTypePFAdd, DataStore.PFAdd, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHyperLogLog},
+	{CmdTypePFCount, DataStore.PFCount, -2, CmdFlagReadOnly, 1, -1, 1, CmdCategoryHyperLogLog},
+	{CmdTypePFMerge, DataStore.PFMerge, -2, CmdFlagWrite, 1, -1, 1, CmdCategoryHyperLogLog},
+
+	// list
+	{CmdTypeLPush, DataStore.LPush, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLPop, DataStore.LPop, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeRPush, DataStore.RPush, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeRPop, DataStore.RPop, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLRange, DataStore.LRange, 4, CmdFlagReadOnly, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLPushX, DataStore.LPushX, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeRPushX, DataStore.RPushX, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLLen, DataStore.LLen, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLIndex, DataStore.LIndex, 3, CmdFlagReadOnly, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLSet, DataStore.LSet, 4, CmdFlagWrite, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLInsert, DataStore.LInsert, 5, CmdFlagWrite, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLRem, DataStore.LRem, 4, CmdFlagWrite, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLTrim, DataStore.LTrim, 4, CmdFlagWrite, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLPos, DataStore.LPos, -3, CmdFlagReadOnly, 1, 1, 1, CmdCategoryList},
+	{CmdTypeLMove, DataStore.LMove, 5, CmdFlagWrite, 1, 2, 1, CmdCategoryList},
+	{CmdTypeBLPop, DataStore.BLPop, -3, CmdFlagWrite, 1, -2, 1, CmdCategoryList},
+	{CmdTypeBRPop, DataStore.BRPop, -3, CmdFlagWrite, 1, -2, 1, CmdCategoryList},
+	{CmdTypeBLMove, DataStore.BLMove, 6, CmdFlagWrite, 1, 2, 1, CmdCategoryList},
+	{CmdTypeBRPopLPush, DataStore.BRPopLPush, 4, CmdFlagWrite, 1, 2, 1, CmdCategoryList},
+
+	// set
+	{CmdTypeSAdd, DataStore.SAdd, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSIsMember, DataStore.SIsMember, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSRem, DataStore.SRem, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSMembers, DataStore.SMembers, 2, CmdFlagReadOnly, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSCard, DataStore.SCard, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSPop, DataStore.SPop, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSRandMember, DataStore.SRandMember, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSMove, DataStore.SMove, 4, CmdFlagWrite | CmdFlagFast, 1, 2, 1, CmdCategorySet},
+	{CmdTypeSMIsMember, DataStore.SMIsMember, -3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySet},
+	{CmdTypeSInter, DataStore.SInter, -2, CmdFlagReadOnly, 1, -1, 1, CmdCategorySet},
+	{CmdTypeSInterStore, DataStore.SInterStore, -3, CmdFlagWrite, 1, -1, 1, CmdCategorySet},
+	// key 的数量由 numkeys 决定，由 handler 自行解析
+	{CmdTypeSInterCard, DataStore.SInterCard, -3, CmdFlagReadOnly, 0, 0, 0, CmdCategorySet},
+	{CmdTypeSUnion, DataStore.SUnion, -2, CmdFlagReadOnly, 1, -1, 1, CmdCategorySet},
+	{CmdTypeSUnionStore, DataStore.SUnionStore, -3, CmdFlagWrite, 1, -1, 1, CmdCategorySet},
+	{CmdTypeSDiff, DataStore.SDiff, -2, CmdFlagReadOnly, 1, -1, 1, CmdCategorySet},
+	{CmdTypeSDiffStore, DataStore.SDiffStore, -3, CmdFlagWrite, 1, -1, 1, CmdCategorySet},
+
+	// hash
+	{CmdTypeHSet, DataStore.HSet, -4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHGet, DataStore.HGet, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHDel, DataStore.HDel, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHGetAll, DataStore.HGetAll, 2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHMGet, DataStore.HMGet, -3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHKeys, DataStore.HKeys, 2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHVals, DataStore.HVals, 2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHLen, DataStore.HLen, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHExists, DataStore.HExists, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHSetNX, DataStore.HSetNX, 4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHStrLen, DataStore.HStrLen, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHRandField, DataStore.HRandField, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHIncrBy, DataStore.HIncrBy, 4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHIncrByFloat, DataStore.HIncrByFloat, 4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHExpire, DataStore.HExpire, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHPExpire, DataStore.HPExpire, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHExpireAt, DataStore.HExpireAt, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHPExpireAt, DataStore.HPExpireAt, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHTTL, DataStore.HTTL, -5, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHPTTL, DataStore.HPTTL, -5, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHExpireTime, DataStore.HExpireTime, -5, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHPExpireTime, DataStore.HPExpireTime, -5, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+	{CmdTypeHPersist, DataStore.HPersist, -5, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryHash},
+
+	// sorted set
+	{CmdTypeZAdd, DataStore.ZAdd, -4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRangeByScore, DataStore.ZRangeByScore, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRem, DataStore.ZRem, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZCard, DataStore.ZCard, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRank, DataStore.ZRank, -3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRevRank, DataStore.ZRevRank, -3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRange, DataStore.ZRange, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRevRange, DataStore.ZRevRange, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRevRangeByScore, DataStore.ZRevRangeByScore, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRangeByLex, DataStore.ZRangeByLex, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRevRangeByLex, DataStore.ZRevRangeByLex, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZRangeStore, DataStore.ZRangeStore, -5, CmdFlagWrite, 1, 2, 1, CmdCategorySortedSet},
+	{CmdTypeZIncrBy, DataStore.ZIncrBy, 4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZScore, DataStore.ZScore, 3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZMScore, DataStore.ZMScore, -3, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZCount, DataStore.ZCount, 4, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZLexCount, DataStore.ZLexCount, 4, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZPopMin, DataStore.ZPopMin, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZPopMax, DataStore.ZPopMax, -2, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeBZPopMin, DataStore.BZPopMin, -3, CmdFlagWrite, 1, -2, 1, CmdCategorySortedSet},
+	{CmdTypeBZPopMax, DataStore.BZPopMax, -3, CmdFlagWrite, 1, -2, 1, CmdCategorySortedSet},
+	{CmdTypeZRandMember, DataStore.ZRandMember, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategorySortedSet},
+	// 源 key 的数量由 numkeys 决定，由 handler 自行解析. store 形式只声明目标 key
+	{CmdTypeZUnion, DataStore.ZUnion, -3, CmdFlagReadOnly, 0, 0, 0, CmdCategorySortedSet},
+	{CmdTypeZUnionStore, DataStore.ZUnionStore, -4, CmdFlagWrite, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZInter, DataStore.ZInter, -3, CmdFlagReadOnly, 0, 0, 0, CmdCategorySortedSet},
+	{CmdTypeZInterStore, DataStore.ZInterStore, -4, CmdFlagWrite, 1, 1, 1, CmdCategorySortedSet},
+	{CmdTypeZDiff, DataStore.ZDiff, -3, CmdFlagReadOnly, 0, 0, 0, CmdCategorySortedSet},
+	{CmdTypeZDiffStore, DataStore.ZDiffStore, -4, CmdFlagWrite, 1, 1, 1, CmdCategorySortedSet},
+
+	// stream. xread 与 xreadgroup 中 key 的位置不固定，由 handler 自行解析
+	{CmdTypeXAdd, DataStore.XAdd, -5, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXRange, DataStore.XRange, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXRevRange, DataStore.XRevRange, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXLen, DataStore.XLen, 2, CmdFlagReadOnly | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXDel, DataStore.XDel, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXTrim, DataStore.XTrim, -4, CmdFlagWrite, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXRead, DataStore.XRead, -4, CmdFlagReadOnly, 0, 0, 0, CmdCategoryStream},
+	{CmdTypeXSetID, DataStore.XSetID, -3, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXGroup, DataStore.XGroup, -2, CmdFlagWrite, 2, 2, 1, CmdCategoryStream},
+	{CmdTypeXReadGroup, DataStore.XReadGroup, -7, CmdFlagWrite, 0, 0, 0, CmdCategoryStream},
+	{CmdTypeXAck, DataStore.XAck, -4, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXPending, DataStore.XPending, -3, CmdFlagReadOnly, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXClaim, DataStore.XClaim, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+	{CmdTypeXAutoClaim, DataStore.XAutoClaim, -6, CmdFlagWrite | CmdFlagFast, 1, 1, 1, CmdCategoryStream},
+
+	// geo. 基于 sorted set 实现，score 为经纬度的 geohash
+	{CmdTypeGeoAdd, DataStore.GeoAdd, -5, CmdFlagWrite, 1, 1, 1, CmdCategoryGeo},
+	{CmdTypeGeoDist, DataStore.GeoDist, -4, CmdFlagReadOnly, 1, 1, 1, CmdCategoryGeo},
+	{CmdTypeGeoPos, DataStore.GeoPos, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryGeo},
+	{CmdTypeGeoHash, DataStore.GeoHash, -2, CmdFlagReadOnly, 1, 1, 1, CmdCategoryGeo},
+	{CmdTypeGeoSearch, DataStore.GeoSearch, -7, CmdFlagReadOnly, 1, 1, 1, CmdCategoryGeo},
+	{CmdTypeGeoSearchStore, DataStore.GeoSearchStore, -8, CmdFlagWrite, 1, 2, 1, CmdCategoryGeo},
+})
+
+func newCmdTable(specs []*cmdSpec) map[CmdType]*cmdSpec {
+	table := make(map[CmdType]*cmdSpec, len(specs))
+	for _, spec := range specs {
+		table[spec.name] = spec
+	}
+	return table
+}
+
+// 指令名大小写不敏感. 指令表只读，不考虑并发问题
+func lookupCmdSpec(name []byte) (*cmdSpec, bool) {
+	spec, ok := cmdTable[CmdType(strings.ToLower(string(name)))]
+	return spec, ok
+}
+
+func (c *cmdSpec) validArity(argc int) bool {
+	if c.arity >= 0 {
+		return argc == c.arity
+	}
+	return argc >= -c.arity
+}
+
+func (c *cmdSpec) isWrite() bool {
+	return c.flags&CmdFlagWrite > 0
+}
+
+// 根据 key 位置声明，从参数中提取出所有的 key. args 不包含指令名
+func (c *cmdSpec) keys(args [][]byte) [][]byte {
+	if c.firstKey <= 0 {
+		return nil
+	}
+
+	lastKey := c.lastKey
+	if lastKey < 0 {
+		lastKey = len(args) + 1 + lastKey
+	}
+
+	keys := make([][]byte, 0, 1)
+	for i := c.firstKey; i <= lastKey && i <= len(args); i += c.keyStep {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+func newArityErrReply(cmd CmdType) handler.Reply {
+	return handler.NewErrReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))
+}
+
+func ping(_ DataStore, cmd *Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 1 {
+		return newArityErrReply(cmd.cmd)
+	}
+	if len(args) == 1 {
+		return handler.NewBulkReply(args[0])
+	}
+	return handler.NewSimpleStringReply("PONG")
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cmd_spec_arity(t *testing.T) {
+	get, _ := lookupCmdSpec([]byte("GET"))
+	assert.True(t, get.validArity(2))
+	assert.False(t, get.validArity(1))
+	assert.False(t, get.validArity(3))
+
+	set, _ := lookupCmdSpec([]byte("set"))
+	assert.False(t, set.validArity(2))
+	assert.True(t, set.validArity(3))
+	assert.True(t, set.validArity(5))
+
+	ping, _ := lookupCmdSpec([]byte("Ping"))
+	assert.True(t, ping.validArity(1))
+
+	_, ok := lookupCmdSpec([]byte("unknown"))
+	assert.False(t, ok)
+}
+
+func Test_cmd_spec_keys(t *testing.T) {
+	args := func(strs ...string) [][]byte {
+		res := make([][]byte, 0, len(strs))
+		for _, str := range strs {
+			res = append(res, []byte(str))
+		}
+		return res
+	}
+
+	t.Run("single_key", func(t *testing.T) {
+		spec, _ := lookupCmdSpec([]byte("set"))
+		assert.Equal(t, args("k"), spec.keys(args("k", "v", "ex", "10")))
+	})
+
+	t.Run("all_keys", func(t *testing.T) {
+		spec, _ := lookupCmdSpec([]byte("mget"))
+		assert.Equal(t, args("k1", "k2", "k3"), spec.keys(args("k1", "k2", "k3")))
+	})
+
+	t.Run("key_step", func(t *testing.T) {
+		spec, _ := lookupCmdSpec([]byte("mset"))
+		assert.Equal(t, args("k1", "k2"), spec.keys(args("k1", "v1", "k2", "v2")))
+	})
+
+	t.Run("numkeys", func(t *testing.T) {
+		// numkeys 及其后的选项不能被视为 key
+		spec, _ := lookupCmdSpec([]byte("zunion"))
+		assert.Empty(t, spec.keys(args("2", "z1", "z2", "weights", "1", "2")))
+		spec, _ = lookupCmdSpec([]byte("zinterstore"))
+		assert.Equal(t, args("dst"), spec.keys(args("dst", "2", "z1", "z2", "aggregate", "max")))
+		spec, _ = lookupCmdSpec([]byte("sintercard"))
+		assert.Empty(t, spec.keys(args("2", "s1", "s2", "limit", "1")))
+	})
+
+	t.Run("no_key", func(t *testing.T) {
+		spec, _ := lookupCmdSpec([]byte("ping"))
+		assert.Empty(t, spec.keys(args("hello")))
+	})
+}
@@ -2,7 +2,6 @@ package database
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/xiaoxuxiansheng/goredis/handler"
@@ -14,53 +13,28 @@ type DBExecutor struct {
 	cancel context.CancelFunc
 	ch     chan *Command
 
-	cmdHandlers map[CmdType]CmdHandler
-	dataStore   DataStore
+	// 阻塞指令超时或者连接断开时，由 watcher 投递到该 chan
+	unblockCh chan *Command
+	blocking  *blockingKeys
+
+	dataStore DataStore
+	persister handler.Persister
 
 	gcTicker *time.Ticker
 }
 
-func NewDBExecutor(dataStore DataStore) Executor {
+func NewDBExecutor(dataStore DataStore, persister handler.Persister) Executor {
 	ctx, cancel := context.WithCancel(context.Background())
 	e := DBExecutor{
 		dataStore: dataStore,
+		persister: persister,
 		ch:        make(chan *Command),
+		unblockCh: make(chan *Command),
+		blocking:  newBlockingKeys(),
 		ctx:       ctx,
 		cancel:    cancel,
 		gcTicker:  time.NewTicker(time.Minute),
 	}
-	e.cmdHandlers = map[CmdType]CmdHandler{
-		CmdTypeExpire:   e.dataStore.Expire,
-		CmdTypeExpireAt: e.dataStore.ExpireAt,
-
-		// string
-		CmdTypeGet:  e.dataStore.Get,
-		CmdTypeSet:  e.dataStore.Set,
-		CmdTypeMGet: e.dataStore.MGet,
-		CmdTypeMSet: e.dataStore.MSet,
-
-		// list
-		CmdTypeLPush:  e.dataStore.LPush,
-		CmdTypeLPop:   e.dataStore.LPop,
-		CmdTypeRPush:  e.dataStore.RPush,
-		CmdTypeRPop:   e.dataStore.RPop,
-		CmdTypeLRange: e.dataStore.LRange,
-
-		// set
-		CmdTypeSAdd:      e.dataStore.SAdd,
-		CmdTypeSIsMember: e.dataStore.SIsMember,
-		CmdTypeSRem:      e.dataStore.SRem,
-
-		// hash
-		CmdTypeHSet: e.dataStore.HSet,
-		CmdTypeHGet: e.dataStore.HGet,
-		CmdTypeHDel: e.dataStore.HDel,
-
-		// sorted set
-		CmdTypeZAdd:          e.dataStore.ZAdd,
-		CmdTypeZRangeByScore: e.dataStore.ZRangeByScore,
-		CmdTypeZRem:          e.dataStore.ZRem,
-	}
 
 	pool.Submit(e.run)
 	return &e
@@ -70,11 +44,6 @@ func (e *DBExecutor) Entrance() chan<- *Command {
 	return e.ch
 }
 
-func (e *DBExecutor) ValidCommand(cmd CmdType) bool {
-	_, valid := e.cmdHandlers[cmd] // map 只读，不考虑并发问题
-	return valid
-}
-
 func (e *DBExecutor) Close() {
 	e.cancel()
 }
@@ -90,14 +59,44 @@ func (e *DBExecutor) run() {
 			e.dataStore.GC()
 
 		case cmd := <-e.ch:
-			cmdFunc, ok := e.cmdHandlers[cmd.cmd]
-			if !ok {
-				cmd.receiver <- handler.NewErrReply(fmt.Sprintf("unknown command '%s'", cmd.cmd))
-				continue
+			if reply := e.exec(cmd); cmd.block != nil {
+				e.block(cmd)
+			} else {
+				cmd.receiver <- reply
 			}
+			e.serveBlocked()
 
-			e.dataStore.ExpirePreprocess(string(cmd.args[0])) // 懒加载机制实现过期 key 删除
-			cmd.receiver <- cmdFunc(cmd)
+		case cmd := <-e.unblockCh:
+			e.unblock(cmd)
 		}
 	}
 }
+
+func (e *DBExecutor) exec(cmd *Command) handler.Reply {
+	spec := cmd.spec
+
+	// 懒加载机制实现过期 key 删除
+	keys := spec.keys(cmd.args)
+	for _, key := range keys {
+		e.dataStore.ExpirePreprocess(string(key))
+	}
+
+	reply := spec.handler(e.dataStore, cmd)
+	// 指令进入阻塞状态，待唤醒后再持久化
+	if cmd.block != nil {
+		return nil
+	}
+
+	// 写指令执行成功后进行持久化
+	if spec.isWrite() && !handler.IsErrReply(reply) {
+		for _, persistCmd := range cmd.PersistCmds() {
+			e.persister.PersistCmd(cmd.Ctx(), persistCmd)
+		}
+		// 写操作可能使得阻塞在 key 上的指令就绪
+		for _, key := range keys {
+			e.blocking.signal(string(key))
+		}
+	}
+
+	return reply
+}
@@ -10,7 +10,6 @@ import (
 
 type Executor interface {
 	Entrance() chan<- *Command
-	ValidCommand(cmd CmdType) bool
 	Close()
 }
 
@@ -21,56 +20,255 @@ func (c CmdType) String() string {
 }
 
 const (
-	CmdTypeExpire   CmdType = "expire"
-	CmdTypeExpireAt CmdType = "expireat"
+	CmdTypePing     CmdType = "ping"
+	CmdTypeDBSize   CmdType = "dbsize"
+	CmdTypeFlushAll CmdType = "flushall"
+
+	// keyspace
+	CmdTypeDel         CmdType = "del"
+	CmdTypeUnlink      CmdType = "unlink"
+	CmdTypeExists      CmdType = "exists"
+	CmdTypeType        CmdType = "type"
+	CmdTypeRename      CmdType = "rename"
+	CmdTypeRenameNX    CmdType = "renamenx"
+	CmdTypeCopy        CmdType = "copy"
+	CmdTypeTouch       CmdType = "touch"
+	CmdTypeExpire      CmdType = "expire"
+	CmdTypePExpire     CmdType = "pexpire"
+	CmdTypeExpireAt    CmdType = "expireat"
+	CmdTypePExpireAt   CmdType = "pexpireat"
+	CmdTypeTTL         CmdType = "ttl"
+	CmdTypePTTL        CmdType = "pttl"
+	CmdTypeExpireTime  CmdType = "expiretime"
+	CmdTypePExpireTime CmdType = "pexpiretime"
+	CmdTypePersist     CmdType = "persist"
+	CmdTypeObject      CmdType = "object"
 
 	// string
-	CmdTypeGet  CmdType = "get"
-	CmdTypeSet  CmdType = "set"
-	CmdTypeMGet CmdType = "mget"
-	CmdTypeMSet CmdType = "mset"
+	CmdTypeGet         CmdType = "get"
+	CmdTypeSet         CmdType = "set"
+	CmdTypeMGet        CmdType = "mget"
+	CmdTypeMSet        CmdType = "mset"
+	CmdTypeIncr        CmdType = "incr"
+	CmdTypeDecr        CmdType = "decr"
+	CmdTypeIncrBy      CmdType = "incrby"
+	CmdTypeDecrBy      CmdType = "decrby"
+	CmdTypeIncrByFloat CmdType = "incrbyfloat"
+	CmdTypeAppend      CmdType = "append"
+	CmdTypeStrLen      CmdType = "strlen"
+	CmdTypeGetRange    CmdType = "getrange"
+	CmdTypeSetRange    CmdType = "setrange"
+	CmdTypeGetSet      CmdType = "getset"
+	CmdTypeGetDel      CmdType = "getdel"
+	CmdTypeGetEx       CmdType = "getex"
+	CmdTypeSetNX       CmdType = "setnx"
+	CmdTypeMSetNX      CmdType = "msetnx"
+
+	// bitmap
+	CmdTypeSetBit     CmdType = "setbit"
+	CmdTypeGetBit     CmdType = "getbit"
+	CmdTypeBitCount   CmdType = "bitcount"
+	CmdTypeBitPos     CmdType = "bitpos"
+	CmdTypeBitOp      CmdType = "bitop"
+	CmdTypeBitField   CmdType = "bitfield"
+	CmdTypeBitFieldRO CmdType = "bitfield_ro"
+
+	// hyperloglog
+	CmdTypePFAdd   CmdType = "pfadd"
+	CmdTypePFCount CmdType = "pfcount"
+	CmdTypePFMerge CmdType = "pfmerge"
 
 	// list
-	CmdTypeLPush  CmdType = "lpush"
-	CmdTypeLPop   CmdType = "lpop"
-	CmdTypeRPush  CmdType = "rpush"
-	CmdTypeRPop   CmdType = "rpop"
-	CmdTypeLRange CmdType = "lrange"
+	CmdTypeLPush   CmdType = "lpush"
+	CmdTypeLPop    CmdType = "lpop"
+	CmdTypeRPush   CmdType = "rpush"
+	CmdTypeRPop    CmdType = "rpop"
+	CmdTypeLRange  CmdType = "lrange"
+	CmdTypeLPushX  CmdType = "lpushx"
+	CmdTypeRPushX  CmdType = "rpushx"
+	CmdTypeLLen    CmdType = "llen"
+	CmdTypeLIndex  CmdType = "lindex"
+	CmdTypeLSet    CmdType = "lset"
+	CmdTypeLInsert CmdType = "linsert"
+	CmdTypeLRem    CmdType = "lrem"
+	CmdTypeLTrim   CmdType = "ltrim"
+	CmdTypeLPos    CmdType = "lpos"
+	CmdTypeLMove   CmdType = "lmove"
+
+	CmdTypeBLPop      CmdType = "blpop"
+	CmdTypeBRPop      CmdType = "brpop"
+	CmdTypeBLMove     CmdType = "blmove"
+	CmdTypeBRPopLPush CmdType = "brpoplpush"
 
 	// hash
-	CmdTypeHSet CmdType = "hset"
-	CmdTypeHGet CmdType = "hget"
-	CmdTypeHDel CmdType = "hdel"
+	CmdTypeHSet       CmdType = "hset"
+	CmdTypeHGet       CmdType = "hget"
+	CmdTypeHDel       CmdType = "hdel"
+	CmdTypeHGetAll    CmdType = "hgetall"
+	CmdTypeHMGet      CmdType = "hmget"
+	CmdTypeHKeys      CmdType = "hkeys"
+	CmdTypeHVals      CmdType = "hvals"
+	CmdTypeHLen       CmdType = "hlen"
+	CmdTypeHExists    CmdType = "hexists"
+	CmdTypeHSetNX     CmdType = "hsetnx"
+	CmdTypeHStrLen    CmdType = "hstrlen"
+	CmdTypeHRandField CmdType = "hrandfield"
+
+	CmdTypeHIncrBy      CmdType = "hincrby"
+	CmdTypeHIncrByFloat CmdType = "hincrbyfloat"
+	CmdTypeHExpire      CmdType = "hexpire"
+	CmdTypeHPExpire     CmdType = "hpexpire"
+	CmdTypeHExpireAt    CmdType = "hexpireat"
+	CmdTypeHPExpireAt   CmdType = "hpexpireat"
+	CmdTypeHTTL         CmdType = "httl"
+	CmdTypeHPTTL        CmdType = "hpttl"
+	CmdTypeHExpireTime  CmdType = "hexpiretime"
+	CmdTypeHPExpireTime CmdType = "hpexpiretime"
+	CmdTypeHPersist     CmdType = "hpersist"
 
 	// set
-	CmdTypeSAdd      CmdType = "sadd"
-	CmdTypeSIsMember CmdType = "sismember"
-	CmdTypeSRem      CmdType = "srem"
+	CmdTypeSAdd        CmdType = "sadd"
+	CmdTypeSIsMember   CmdType = "sismember"
+	CmdTypeSRem        CmdType = "srem"
+	CmdTypeSMembers    CmdType = "smembers"
+	CmdTypeSCard       CmdType = "scard"
+	CmdTypeSPop        CmdType = "spop"
+	CmdTypeSRandMember CmdType = "srandmember"
+	CmdTypeSMove       CmdType = "smove"
+	CmdTypeSMIsMember  CmdType = "smismember"
+	CmdTypeSInter      CmdType = "sinter"
+	CmdTypeSInterStore CmdType = "sinterstore"
+	CmdTypeSInterCard  CmdType = "sintercard"
+	CmdTypeSUnion      CmdType = "sunion"
+	CmdTypeSUnionStore CmdType = "sunionstore"
+	CmdTypeSDiff       CmdType = "sdiff"
+	CmdTypeSDiffStore  CmdType = "sdiffstore"
 
 	// sorted set
-	CmdTypeZAdd          CmdType = "zadd"
-	CmdTypeZRangeByScore CmdType = "zrangebyscore"
-	CmdTypeZRem          CmdType = "zrem"
+	CmdTypeZAdd             CmdType = "zadd"
+	CmdTypeZRangeByScore    CmdType = "zrangebyscore"
+	CmdTypeZRem             CmdType = "zrem"
+	CmdTypeZCard            CmdType = "zcard"
+	CmdTypeZRank            CmdType = "zrank"
+	CmdTypeZRevRank         CmdType = "zrevrank"
+	CmdTypeZRange           CmdType = "zrange"
+	CmdTypeZRevRange        CmdType = "zrevrange"
+	CmdTypeZRevRangeByScore CmdType = "zrevrangebyscore"
+	CmdTypeZRangeByLex      CmdType = "zrangebylex"
+	CmdTypeZRevRangeByLex   CmdType = "zrevrangebylex"
+	CmdTypeZRangeStore      CmdType = "zrangestore"
+	CmdTypeZIncrBy          CmdType = "zincrby"
+	CmdTypeZScore           CmdType = "zscore"
+	CmdTypeZMScore          CmdType = "zmscore"
+	CmdTypeZCount           CmdType = "zcount"
+	CmdTypeZLexCount        CmdType = "zlexcount"
+	CmdTypeZPopMin          CmdType = "zpopmin"
+	CmdTypeZPopMax          CmdType = "zpopmax"
+	CmdTypeBZPopMin         CmdType = "bzpopmin"
+	CmdTypeBZPopMax         CmdType = "bzpopmax"
+	CmdTypeZRandMember      CmdType = "zrandmember"
+	CmdTypeZUnion           CmdType = "zunion"
+	CmdTypeZUnionStore      CmdType = "zunionstore"
+	CmdTypeZInter           CmdType = "zinter"
+	CmdTypeZInterStore      CmdType = "zinterstore"
+	CmdTypeZDiff            CmdType = "zdiff"
+	CmdTypeZDiffStore       CmdType = "zdiffstore"
+
+	// stream
+	CmdTypeXAdd       CmdType = "xadd"
+	CmdTypeXRange     CmdType = "xrange"
+	CmdTypeXRevRange  CmdType = "xrevrange"
+	CmdTypeXLen       CmdType = "xlen"
+	CmdTypeXDel       CmdType = "xdel"
+	CmdTypeXTrim      CmdType = "xtrim"
+	CmdTypeXRead      CmdType = "xread"
+	CmdTypeXSetID     CmdType = "xsetid"
+	CmdTypeXGroup     CmdType = "xgroup"
+	CmdTypeXReadGroup CmdType = "xreadgroup"
+	CmdTypeXAck       CmdType = "xack"
+	CmdTypeXPending   CmdType = "xpending"
+	CmdTypeXClaim     CmdType = "xclaim"
+	CmdTypeXAutoClaim CmdType = "xautoclaim"
+
+	// geo
+	CmdTypeGeoAdd         CmdType = "geoadd"
+	CmdTypeGeoDist        CmdType = "geodist"
+	CmdTypeGeoPos         CmdType = "geopos"
+	CmdTypeGeoHash        CmdType = "geohash"
+	CmdTypeGeoSearch      CmdType = "geosearch"
+	CmdTypeGeoSearchStore CmdType = "geosearchstore"
 )
 
 type CmdAdapter interface {
 	ToCmd() [][]byte
 }
 
+// 除 ToCmd 以外，还需要追加指令才能完整还原的数据. 例如 hash 字段的过期时间
+type ExtraCmdAdapter interface {
+	ExtraCmds() [][][]byte
+}
+
 type DataStore interface {
 	ForEach(task func(key string, adapter CmdAdapter, expireAt *time.Time))
 
 	ExpirePreprocess(key string)
 	GC()
 
+	DBSize(*Command) handler.Reply
+	FlushAll(*Command) handler.Reply
+
+	// keyspace
+	Del(*Command) handler.Reply
+	Exists(*Command) handler.Reply
+	Type(*Command) handler.Reply
+	Rename(*Command) handler.Reply
+	RenameNX(*Command) handler.Reply
+	Copy(*Command) handler.Reply
+	Touch(*Command) handler.Reply
 	Expire(*Command) handler.Reply
+	PExpire(*Command) handler.Reply
 	ExpireAt(*Command) handler.Reply
+	PExpireAt(*Command) handler.Reply
+	TTL(*Command) handler.Reply
+	PTTL(*Command) handler.Reply
+	ExpireTime(*Command) handler.Reply
+	PExpireTime(*Command) handler.Reply
+	Persist(*Command) handler.Reply
+	Object(*Command) handler.Reply
 
 	// string
 	Get(*Command) handler.Reply
 	MGet(*Command) handler.Reply
 	Set(*Command) handler.Reply
 	MSet(*Command) handler.Reply
+	Incr(*Command) handler.Reply
+	Decr(*Command) handler.Reply
+	IncrBy(*Command) handler.Reply
+	DecrBy(*Command) handler.Reply
+	IncrByFloat(*Command) handler.Reply
+	Append(*Command) handler.Reply
+	StrLen(*Command) handler.Reply
+	GetRange(*Command) handler.Reply
+	SetRange(*Command) handler.Reply
+	GetSet(*Command) handler.Reply
+	GetDel(*Command) handler.Reply
+	GetEx(*Command) handler.Reply
+	SetNX(*Command) handler.Reply
+	MSetNX(*Command) handler.Reply
+
+	// bitmap
+	SetBit(*Command) handler.Reply
+	GetBit(*Command) handler.Reply
+	BitCount(*Command) handler.Reply
+	BitPos(*Command) handler.Reply
+	BitOp(*Command) handler.Reply
+	BitField(*Command) handler.Reply
+	BitFieldRO(*Command) handler.Reply
+
+	// hyperloglog
+	PFAdd(*Command) handler.Reply
+	PFCount(*Command) handler.Reply
+	PFMerge(*Command) handler.Reply
 
 	// list
 	LPush(*Command) handler.Reply
@@ -78,36 +276,140 @@ type DataStore interface {
 	RPush(*Command) handler.Reply
 	RPop(*Command) handler.Reply
 	LRange(*Command) handler.Reply
+	LPushX(*Command) handler.Reply
+	RPushX(*Command) handler.Reply
+	LLen(*Command) handler.Reply
+	LIndex(*Command) handler.Reply
+	LSet(*Command) handler.Reply
+	LInsert(*Command) handler.Reply
+	LRem(*Command) handler.Reply
+	LTrim(*Command) handler.Reply
+	LPos(*Command) handler.Reply
+	LMove(*Command) handler.Reply
+	BLPop(*Command) handler.Reply
+	BRPop(*Command) handler.Reply
+	BLMove(*Command) handler.Reply
+	BRPopLPush(*Command) handler.Reply
 
 	// set
 	SAdd(*Command) handler.Reply
 	SIsMember(*Command) handler.Reply
 	SRem(*Command) handler.Reply
+	SMembers(*Command) handler.Reply
+	SCard(*Command) handler.Reply
+	SPop(*Command) handler.Reply
+	SRandMember(*Command) handler.Reply
+	SMove(*Command) handler.Reply
+	SMIsMember(*Command) handler.Reply
+	SInter(*Command) handler.Reply
+	SInterStore(*Command) handler.Reply
+	SInterCard(*Command) handler.Reply
+	SUnion(*Command) handler.Reply
+	SUnionStore(*Command) handler.Reply
+	SDiff(*Command) handler.Reply
+	SDiffStore(*Command) handler.Reply
 
 	// hash
 	HSet(*Command) handler.Reply
 	HGet(*Command) handler.Reply
 	HDel(*Command) handler.Reply
+	HGetAll(*Command) handler.Reply
+	HMGet(*Command) handler.Reply
+	HKeys(*Command) handler.Reply
+	HVals(*Command) handler.Reply
+	HLen(*Command) handler.Reply
+	HExists(*Command) handler.Reply
+	HSetNX(*Command) handler.Reply
+	HStrLen(*Command) handler.Reply
+	HRandField(*Command) handler.Reply
+	HIncrBy(*Command) handler.Reply
+	HIncrByFloat(*Command) handler.Reply
+	HExpire(*Command) handler.Reply
+	HPExpire(*Command) handler.Reply
+	HExpireAt(*Command) handler.Reply
+	HPExpireAt(*Command) handler.Reply
+	HTTL(*Command) handler.Reply
+	HPTTL(*Command) handler.Reply
+	HExpireTime(*Command) handler.Reply
+	HPExpireTime(*Command) handler.Reply
+	HPersist(*Command) handler.Reply
 
 	// sorted set
 	ZAdd(*Command) handler.Reply
 	ZRangeByScore(*Command) handler.Reply
 	ZRem(*Command) handler.Reply
-}
+	ZCard(*Command) handler.Reply
+	ZRank(*Command) handler.Reply
+	ZRevRank(*Command) handler.Reply
+	ZRange(*Command) handler.Reply
+	ZRevRange(*Command) handler.Reply
+	ZRevRangeByScore(*Command) handler.Reply
+	ZRangeByLex(*Command) handler.Reply
+	ZRevRangeByLex(*Command) handler.Reply
+	ZRangeStore(*Command) handler.Reply
+	ZIncrBy(*Command) handler.Reply
+	ZScore(*Command) handler.Reply
+	ZMScore(*Command) handler.Reply
+	ZCount(*Command) handler.Reply
+	ZLexCount(*Command) handler.Reply
+	ZPopMin(*Command) handler.Reply
+	ZPopMax(*Command) handler.Reply
+	BZPopMin(*Command) handler.Reply
+	BZPopMax(*Command) handler.Reply
+	ZRandMember(*Command) handler.Reply
+	ZUnion(*Command) handler.Reply
+	ZUnionStore(*Command) handler.Reply
+	ZInter(*Command) handler.Reply
+	ZInterStore(*Command) handler.Reply
+	ZDiff(*Command) handler.Reply
+	ZDiffStore(*Command) handler.Reply
+
+	// stream
+	XAdd(*Command) handler.Reply
+	XRange(*Command) handler.Reply
+	XRevRange(*Command) handler.Reply
+	XLen(*Command) handler.Reply
+	XDel(*Command) handler.Reply
+	XTrim(*Command) handler.Reply
+	XRead(*Command) handler.Reply
+	XSetID(*Command) handler.Reply
+	XGroup(*Command) handler.Reply
+	XReadGroup(*Command) handler.Reply
+	XAck(*Command) handler.Reply
+	XPending(*Command) handler.Reply
+	XClaim(*Command) handler.Reply
+	XAutoClaim(*Command) handler.Reply
 
-type CmdHandler func(*Command) handler.Reply
+	// geo
+	GeoAdd(*Command) handler.Reply
+	GeoDist(*Command) handler.Reply
+	GeoPos(*Command) handler.Reply
+	GeoHash(*Command) handler.Reply
+	GeoSearch(*Command) handler.Reply
+	GeoSearchStore(*Command) handler.Reply
+}
 
 type Command struct {
 	ctx      context.Context
 	cmd      CmdType
 	args     [][]byte
 	receiver CmdReceiver
+	spec     *cmdSpec
+
+	// 指令是否被重写. 被重写时持久化 rewrites 中的指令，否则持久化原指令
+	rewritten bool
+	rewrites  [][][]byte
+
+	// 阻塞指令的等待信息，为 nil 表示指令已执行完成
+	block *blockInfo
 }
 
 func NewCommand(cmd CmdType, args [][]byte) *Command {
 	return &Command{
+		ctx:  context.Background(),
 		cmd:  cmd,
 		args: args,
+		spec: cmdTable[cmd],
 	}
 }
 
@@ -115,6 +417,10 @@ func (c *Command) Ctx() context.Context {
 	return c.ctx
 }
 
+func (c *Command) Name() CmdType {
+	return c.cmd
+}
+
 func (c *Command) Receiver() CmdReceiver {
 	return c.receiver
 }
@@ -127,4 +433,33 @@ func (c *Command) Cmd() [][]byte {
 	return append([][]byte{[]byte(c.cmd.String())}, c.args...)
 }
 
+// 使用 cmds 替代原指令进行持久化，用于将非确定性的指令改写为确定性的指令
+func (c *Command) Rewrite(cmds ...[][]byte) {
+	c.rewritten = true
+	c.rewrites = cmds
+}
+
+// 本次执行没有修改数据，无需持久化
+func (c *Command) Unchanged() {
+	c.Rewrite()
+}
+
+// 指令需要阻塞等待，直到 keys 中的任意一个就绪后重新执行. timeout 为 0 表示永不超时，
+// 超时后以 timeoutReply 作为回复. 调用后 handler 的返回值会被忽略
+func (c *Command) Block(keys [][]byte, timeout time.Duration, timeoutReply handler.Reply) {
+	c.block = &blockInfo{
+		keys:         keys,
+		timeout:      timeout,
+		timeoutReply: timeoutReply,
+	}
+}
+
+// 指令执行后需要持久化的内容
+func (c *Command) PersistCmds() [][][]byte {
+	if c.rewritten {
+		return c.rewrites
+	}
+	return [][][]byte{c.Cmd()}
+}
+
 type CmdReceiver chan handler.Reply
@@ -18,20 +18,26 @@ func NewDBTrigger(executor Executor) handler.DB {
 }
 
 func (d *DBTrigger) Do(ctx context.Context, cmdLine [][]byte) handler.Reply {
-	if len(cmdLine) < 2 {
+	if len(cmdLine) == 0 {
 		return handler.NewErrReply(fmt.Sprintf("invalid cmd line: %v", cmdLine))
 	}
 
-	cmdType := CmdType(cmdLine[0])
-	if !d.executor.ValidCommand(cmdType) {
-		return handler.NewErrReply(fmt.Sprintf("unknown cmd '%s'", cmdLine[0]))
+	spec, ok := lookupCmdSpec(cmdLine[0])
+	if !ok {
+		return handler.NewErrReply(fmt.Sprintf("ERR unknown command '%s'", cmdLine[0]))
+	}
+
+	// 依据指令表完成参数个数校验
+	if !spec.validArity(len(cmdLine)) {
+		return newArityErrReply(spec.name)
 	}
 
 	cmd := Command{
 		ctx:      ctx,
-		cmd:      cmdType,
+		cmd:      spec.name,
 		args:     cmdLine[1:],
 		receiver: make(CmdReceiver),
+		spec:     spec,
 	}
 
 	// 投递给到 executor
@@ -0,0 +1,627 @@
+package datastore
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// bitmap 基于 string 实现，与 redis 一致，字节内按照高位在前的顺序编址
+
+// 最大的 bit 偏移量，受字符串最大长度限制
+const maxBitOffset = maxStringLen*8 - 1
+
+func (k *KVStore) SetBit(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1], false, 0)
+	if errReply != nil {
+		return errReply
+	}
+
+	value := string(args[2])
+	if value != "0" && value != "1" {
+		return handler.NewErrReply("ERR bit is not an integer or out of range")
+	}
+
+	str, errReply := k.getAsBitmap(key, offset+1)
+	if errReply != nil {
+		return errReply
+	}
+
+	return handler.NewIntReply(int64(str.setBit(offset, value == "1")))
+}
+
+func (k *KVStore) GetBit(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	offset, errReply := parseBitOffset(args[1], false, 0)
+	if errReply != nil {
+		return errReply
+	}
+
+	str, err := k.getAsString(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if str == nil {
+		return handler.NewIntReply(0)
+	}
+
+	return handler.NewIntReply(int64(getBit(str.Bytes(), offset)))
+}
+
+func (k *KVStore) BitCount(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	rng, errReply := parseBitRange(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	str, err := k.getAsString(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if str == nil {
+		return handler.NewIntReply(0)
+	}
+
+	data := str.Bytes()
+	start, end, ok := rng.bitBounds(int64(len(data)))
+	if !ok {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(countBits(data, start, end))
+}
+
+func (k *KVStore) BitPos(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	bit := string(args[1])
+	if bit != "0" && bit != "1" {
+		return handler.NewErrReply("ERR The bit argument must be 1 or 0.")
+	}
+
+	rng, errReply := parseBitRange(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	str, err := k.getAsString(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// key 不存在时，视为全 0 的字符串
+	if str == nil {
+		if bit == "1" {
+			return handler.NewIntReply(-1)
+		}
+		return handler.NewIntReply(0)
+	}
+
+	data := str.Bytes()
+	start, end, ok := rng.bitBounds(int64(len(data)))
+	if !ok {
+		return handler.NewIntReply(-1)
+	}
+
+	pos := findBit(data, bit == "1", start, end)
+	// 查找 0 且未指定 end 时，认为字符串右侧以 0 补齐
+	if pos == -1 && bit == "0" && !rng.endGiven {
+		return handler.NewIntReply(end + 1)
+	}
+	return handler.NewIntReply(pos)
+}
+
+func (k *KVStore) BitOp(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	op := strings.ToLower(string(args[0]))
+	dest := string(args[1])
+	srcKeys := args[2:]
+
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(srcKeys) != 1 {
+			return handler.NewErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return handler.NewSyntaxErrReply()
+	}
+
+	srcs := make([][]byte, 0, len(srcKeys))
+	var maxLen int
+	for _, srcKey := range srcKeys {
+		str, err := k.getAsString(string(srcKey))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		var data []byte
+		if str != nil {
+			data = str.Bytes()
+		}
+		srcs = append(srcs, data)
+		if len(data) > maxLen {
+			maxLen = len(data)
+		}
+	}
+
+	res := bitOp(op, srcs, maxLen)
+	// 结果为空串时，删除目标 key
+	if len(res) == 0 {
+		k.del(dest)
+		return handler.NewIntReply(0)
+	}
+
+	k.put(dest, res, false)
+	return handler.NewIntReply(int64(len(res)))
+}
+
+func (k *KVStore) BitField(cmd *database.Command) handler.Reply {
+	return k.bitField(cmd, false)
+}
+
+func (k *KVStore) BitFieldRO(cmd *database.Command) handler.Reply {
+	return k.bitField(cmd, true)
+}
+
+func (k *KVStore) bitField(cmd *database.Command, readOnly bool) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	// 统计写操作涉及的最大偏移量
+	var (
+		writes    bool
+		maxOffset int64 = -1
+	)
+	for _, op := range ops {
+		if op.kind == bitFieldGet {
+			continue
+		}
+		if readOnly {
+			return handler.NewErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		writes = true
+		if end := op.offset + int64(op.typ.bits) - 1; end > maxOffset {
+			maxOffset = end
+		}
+	}
+
+	var data []byte
+	if writes {
+		str, errReply := k.getAsBitmap(key, maxOffset+1)
+		if errReply != nil {
+			return errReply
+		}
+		// 原地修改. 读取字符串的回复均通过 copyBytes 拷贝，不会受到影响
+		data = str.data
+	} else {
+		cmd.Unchanged()
+		str, err := k.getAsString(key)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if str != nil {
+			data = str.Bytes()
+		}
+	}
+
+	// 溢出策略为 FAIL 时，发生溢出的操作不生效，返回 nil
+	res := make([]handler.Reply, 0, len(ops))
+	for _, op := range ops {
+		old := op.typ.toInt(getBits(data, op.offset, op.typ.bits))
+		switch op.kind {
+		case bitFieldGet:
+			res = append(res, handler.NewIntReply(old))
+		case bitFieldSet:
+			val, overflowed := op.typ.add(op.value, 0, op.overflow)
+			if overflowed && op.overflow == bitFieldOverflowFail {
+				res = append(res, handler.NewNillReply())
+				continue
+			}
+			setBits(data, op.offset, op.typ.bits, val)
+			res = append(res, handler.NewIntReply(old))
+		case bitFieldIncrBy:
+			val, overflowed := op.typ.add(old, op.value, op.overflow)
+			if overflowed && op.overflow == bitFieldOverflowFail {
+				res = append(res, handler.NewNillReply())
+				continue
+			}
+			setBits(data, op.offset, op.typ.bits, val)
+			res = append(res, handler.NewIntReply(op.typ.toInt(val)))
+		}
+	}
+
+	return handler.NewArrayReply(res)
+}
+
+// 获取用于位操作的字符串，并保证其长度至少能容纳 bitSize 个 bit. key 不存在时会创建
+func (k *KVStore) getAsBitmap(key string, bitSize int64) (*stringEntity, handler.Reply) {
+	str, err := k.getAsRawString(key)
+	if err != nil {
+		return nil, handler.NewErrReply(err.Error())
+	}
+
+	if str == nil {
+		str = newStringEntity(key, nil)
+		k.data[key] = str
+	}
+
+	str.grow((bitSize + 7) >> 3)
+	return str, nil
+}
+
+// 原地修改 offset 处的 bit，返回原值
+func (s *stringEntity) setBit(offset int64, on bool) byte {
+	old := getBit(s.data, offset)
+	mask := byte(1) << (7 - uint(offset&7))
+	if on {
+		s.data[offset>>3] |= mask
+	} else {
+		s.data[offset>>3] &^= mask
+	}
+	return old
+}
+
+func getBit(data []byte, offset int64) byte {
+	if offset>>3 >= int64(len(data)) {
+		return 0
+	}
+	return (data[offset>>3] >> (7 - uint(offset&7))) & 1
+}
+
+// 读取 [offset,offset+n) 范围内的 bit，作为无符号整数返回
+func getBits(data []byte, offset int64, n uint) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(n); i++ {
+		v = v<<1 | uint64(getBit(data, offset+i))
+	}
+	return v
+}
+
+// 将 v 的低 n 位写入 [offset,offset+n) 范围内. 调用方保证 data 长度足够
+func setBits(data []byte, offset int64, n uint, v uint64) {
+	for i := int64(0); i < int64(n); i++ {
+		bit := (v >> (uint(int64(n) - 1 - i))) & 1
+		mask := byte(1) << (7 - uint((offset+i)&7))
+		if bit == 1 {
+			data[(offset+i)>>3] |= mask
+		} else {
+			data[(offset+i)>>3] &^= mask
+		}
+	}
+}
+
+// 统计 [start,end] 范围内 bit 为 1 的个数，入参为 bit 下标
+func countBits(data []byte, start, end int64) int64 {
+	var cnt int64
+	for start <= end && start&7 != 0 {
+		cnt += int64(getBit(data, start))
+		start++
+	}
+	for end >= start && (end+1)&7 != 0 {
+		cnt += int64(getBit(data, end))
+		end--
+	}
+	for i := start >> 3; i < (end+1)>>3; i++ {
+		cnt += int64(bits.OnesCount8(data[i]))
+	}
+	return cnt
+}
+
+// 在 [start,end] 范围内查找首个值为 bit 的位置，入参为 bit 下标
+func findBit(data []byte, bit bool, start, end int64) int64 {
+	var skip byte
+	if !bit {
+		skip = 0xff
+	}
+
+	for i := start; i <= end; {
+		// 整字节跳过
+		if i&7 == 0 && i+7 <= end && data[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if (getBit(data, i) == 1) == bit {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+func bitOp(op string, srcs [][]byte, size int) []byte {
+	res := make([]byte, size)
+	if op == "not" {
+		for i := range res {
+			res[i] = ^srcs[0][i]
+		}
+		return res
+	}
+
+	// 长度不足的字符串以 0 补齐
+	byteAt := func(src []byte, i int) byte {
+		if i < len(src) {
+			return src[i]
+		}
+		return 0
+	}
+
+	for i := range res {
+		b := byteAt(srcs[0], i)
+		for _, src := range srcs[1:] {
+			switch op {
+			case "and":
+				b &= byteAt(src, i)
+			case "or":
+				b |= byteAt(src, i)
+			case "xor":
+				b ^= byteAt(src, i)
+			}
+		}
+		res[i] = b
+	}
+	return res
+}
+
+// bitcount、bitpos 的范围参数
+type bitRange struct {
+	start, end int64
+	startGiven bool
+	endGiven   bool
+	// 范围的单位是否为 bit，默认为 byte
+	bitUnit bool
+}
+
+// bitpos 允许只指定 start，bitcount 要求 start 与 end 成对出现
+func parseBitRange(args [][]byte, allowStartOnly bool) (*bitRange, handler.Reply) {
+	rng := bitRange{end: -1}
+	if len(args) == 0 {
+		return &rng, nil
+	}
+	if len(args) > 3 || (len(args) == 1 && !allowStartOnly) {
+		return nil, handler.NewSyntaxErrReply()
+	}
+
+	var err error
+	if rng.start, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+		return nil, handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	rng.startGiven = true
+
+	if len(args) >= 2 {
+		if rng.end, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return nil, handler.NewErrReply("ERR value is not an integer or out of range")
+		}
+		rng.endGiven = true
+	}
+
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "byte":
+		case "bit":
+			rng.bitUnit = true
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+	return &rng, nil
+}
+
+// 将范围转换为 bit 下标的闭区间. size 为字符串的字节数
+func (b *bitRange) bitBounds(size int64) (int64, int64, bool) {
+	total := size
+	if b.bitUnit {
+		total = size * 8
+	}
+
+	start, end, ok := normalizeRange(b.start, b.end, total)
+	if !ok {
+		return 0, 0, false
+	}
+	if b.bitUnit {
+		return start, end, true
+	}
+	return start * 8, end*8 + 7, true
+}
+
+type bitFieldOpKind int
+
+const (
+	bitFieldGet bitFieldOpKind = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+type bitFieldOverflow int
+
+const (
+	bitFieldOverflowWrap bitFieldOverflow = iota
+	bitFieldOverflowSat
+	bitFieldOverflowFail
+)
+
+type bitFieldOp struct {
+	kind     bitFieldOpKind
+	typ      bitFieldType
+	offset   int64
+	value    int64
+	overflow bitFieldOverflow
+}
+
+// 位域类型，如 i8、u16. 有符号类型最多 64 位，无符号类型最多 63 位
+type bitFieldType struct {
+	signed bool
+	bits   uint
+}
+
+func parseBitFieldType(arg []byte) (bitFieldType, handler.Reply) {
+	errReply := handler.NewErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	str := strings.ToLower(string(arg))
+	if len(str) < 2 || (str[0] != 'i' && str[0] != 'u') {
+		return bitFieldType{}, errReply
+	}
+
+	n, err := strconv.ParseUint(str[1:], 10, 8)
+	if err != nil || n < 1 || (str[0] == 'i' && n > 64) || (str[0] == 'u' && n > 63) {
+		return bitFieldType{}, errReply
+	}
+	return bitFieldType{signed: str[0] == 'i', bits: uint(n)}, nil
+}
+
+func (b bitFieldType) signExtend(v uint64) int64 {
+	if b.bits < 64 && v&(1<<(b.bits-1)) != 0 {
+		v |= math.MaxUint64 << b.bits
+	}
+	return int64(v)
+}
+
+// 位域的最大值与最小值
+func (b bitFieldType) limits() (int64, int64) {
+	if !b.signed {
+		return 1<<b.bits - 1, 0
+	}
+	if b.bits == 64 {
+		return math.MaxInt64, math.MinInt64
+	}
+	max := int64(1)<<(b.bits-1) - 1
+	return max, -max - 1
+}
+
+func (b bitFieldType) mask() uint64 {
+	if b.bits == 64 {
+		return math.MaxUint64
+	}
+	return 1<<b.bits - 1
+}
+
+// 计算 value + incr 的结果，返回按照溢出策略处理后的位域原始 bit，以及是否发生了溢出.
+// 无符号类型的 value 以 uint64 的形式参与比较，因此 set 负数时会被视为上溢
+func (b bitFieldType) add(value, incr int64, policy bitFieldOverflow) (uint64, bool) {
+	max, min := b.limits()
+
+	var high, low bool
+	if b.signed {
+		high = value > max || (incr > 0 && (value >= 0 || b.bits < 64) && incr > max-value)
+		low = !high && (value < min || (incr < 0 && (value < 0 || b.bits < 64) && incr < min-value))
+	} else {
+		uvalue := uint64(value)
+		high = uvalue > uint64(max) || (incr > 0 && uint64(incr) > uint64(max)-uvalue)
+		low = !high && incr < 0 && uint64(-incr) > uvalue
+	}
+
+	if policy == bitFieldOverflowSat {
+		switch {
+		case high:
+			return uint64(max) & b.mask(), true
+		case low:
+			return uint64(min) & b.mask(), true
+		}
+	}
+
+	// 默认以回绕的方式处理溢出
+	return uint64(value+incr) & b.mask(), high || low
+}
+
+// 将位域的原始 bit 转为参与运算的整数
+func (b bitFieldType) toInt(v uint64) int64 {
+	if !b.signed {
+		return int64(v)
+	}
+	return b.signExtend(v)
+}
+
+func parseBitFieldOps(args [][]byte) ([]*bitFieldOp, handler.Reply) {
+	ops := make([]*bitFieldOp, 0, len(args)/3)
+	overflow := bitFieldOverflowWrap
+	for i := 0; i < len(args); i++ {
+		sub := strings.ToLower(string(args[i]))
+		if sub == "overflow" {
+			if i+1 >= len(args) {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = bitFieldOverflowWrap
+			case "sat":
+				overflow = bitFieldOverflowSat
+			case "fail":
+				overflow = bitFieldOverflowFail
+			default:
+				return nil, handler.NewErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		}
+
+		var kind bitFieldOpKind
+		argc := 2
+		switch sub {
+		case "get":
+			kind = bitFieldGet
+		case "set":
+			kind, argc = bitFieldSet, 3
+		case "incrby":
+			kind, argc = bitFieldIncrBy, 3
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+		if i+argc >= len(args) {
+			return nil, handler.NewSyntaxErrReply()
+		}
+
+		typ, errReply := parseBitFieldType(args[i+1])
+		if errReply != nil {
+			return nil, errReply
+		}
+
+		offset, errReply := parseBitOffset(args[i+2], true, typ.bits)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if offset+int64(typ.bits)-1 > maxBitOffset {
+			return nil, handler.NewErrReply("ERR bit offset is not an integer or out of range")
+		}
+
+		op := bitFieldOp{kind: kind, typ: typ, offset: offset, overflow: overflow}
+		if argc == 3 {
+			value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, handler.NewErrReply("ERR value is not an integer or out of range")
+			}
+			op.value = value
+		}
+
+		ops = append(ops, &op)
+		i += argc
+	}
+	return ops, nil
+}
+
+// 解析 bit 偏移量. bitfield 中支持 #N 的形式，表示第 N 个位域
+func parseBitOffset(arg []byte, hashAllowed bool, fieldBits uint) (int64, handler.Reply) {
+	errReply := handler.NewErrReply("ERR bit offset is not an integer or out of range")
+	str := string(arg)
+	var multiplier int64 = 1
+	if hashAllowed && len(str) > 0 && str[0] == '#' {
+		multiplier = int64(fieldBits)
+		str = str[1:]
+	}
+
+	offset, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset/multiplier {
+		return 0, errReply
+	}
+	offset *= multiplier
+	if offset > maxBitOffset {
+		return 0, errReply
+	}
+	return offset, nil
+}
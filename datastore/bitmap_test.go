@@ -0,0 +1,143 @@
+package datastore
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func Test_bitmap_pos(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "a", "\xff\xf0\x00"))
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "b", "\x00\xff\xf0"))
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "c", "\xff\xff\xff"))
+
+	for _, c := range []struct {
+		args   []string
+		expect int64
+	}{
+		{[]string{"a", "0"}, 12},
+		{[]string{"b", "1", "0"}, 8},
+		{[]string{"b", "1", "2", "-1", "byte"}, 16},
+		{[]string{"b", "1", "7", "15", "bit"}, 8},
+		{[]string{"c", "0"}, 24},
+		{[]string{"c", "0", "0", "-1"}, -1},
+		{[]string{"missing", "0"}, 0},
+		{[]string{"missing", "1"}, -1},
+	} {
+		assert.Equal(t, handler.NewIntReply(c.expect), kvStore.BitPos(newTestCmd(database.CmdTypeBitPos, c.args...)), c.args)
+	}
+}
+
+func Test_bitmap_count(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "foobar"))
+
+	assert.Equal(t, handler.NewIntReply(26), kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k")))
+	assert.Equal(t, handler.NewIntReply(4), kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k", "0", "0")))
+	assert.Equal(t, handler.NewIntReply(6), kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k", "1", "1")))
+	assert.Equal(t, handler.NewIntReply(17), kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k", "5", "30", "bit")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k", "-1", "-2")))
+	assert.True(t, handler.IsErrReply(kvStore.BitCount(newTestCmd(database.CmdTypeBitCount, "k", "0"))))
+}
+
+func Test_bitmap_setbit(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	assert.Equal(t, handler.NewIntReply(0), kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", "9", "1")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", "9", "0")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.GetBit(newTestCmd(database.CmdTypeGetBit, "k", "9")))
+	assert.Equal(t, handler.NewBulkReply([]byte{0, 0}), kvStore.Get(newTestCmd(database.CmdTypeGet, "k")))
+
+	// 整数编码的字符串
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "n", "1"))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "n", "6", "1")))
+	assert.Equal(t, handler.NewBulkReply([]byte("3")), kvStore.Get(newTestCmd(database.CmdTypeGet, "n")))
+
+	assert.True(t, handler.IsErrReply(kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", "-1", "1"))))
+	assert.True(t, handler.IsErrReply(kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", "0", "2"))))
+	assert.True(t, handler.IsErrReply(kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", strconv.Itoa(maxBitOffset+1), "1"))))
+
+	// 原地修改不能影响到尚未写出的回复
+	get := kvStore.Get(newTestCmd(database.CmdTypeGet, "k"))
+	getRange := kvStore.GetRange(newTestCmd(database.CmdTypeGetRange, "k", "0", "0"))
+	kvStore.SetBit(newTestCmd(database.CmdTypeSetBit, "k", "0", "1"))
+	kvStore.BitField(newTestCmd(database.CmdTypeBitField, "k", "set", "u8", "8", "255"))
+	assert.Equal(t, handler.NewBulkReply([]byte{0, 0}), get)
+	assert.Equal(t, handler.NewBulkReply([]byte{0}), getRange)
+	assert.Equal(t, handler.NewBulkReply([]byte{0x80, 0xff}), kvStore.Get(newTestCmd(database.CmdTypeGet, "k")))
+}
+
+func Test_bitmap_op(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "a", "foobar"))
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "b", "abc"))
+
+	assert.Equal(t, handler.NewIntReply(6), kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "and", "dest", "a", "b")))
+	assert.Equal(t, handler.NewBulkReply([]byte("`bc\x00\x00\x00")), kvStore.Get(newTestCmd(database.CmdTypeGet, "dest")))
+	assert.Equal(t, handler.NewIntReply(6), kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "or", "dest", "a", "b")))
+	assert.Equal(t, handler.NewBulkReply([]byte("goobar")), kvStore.Get(newTestCmd(database.CmdTypeGet, "dest")))
+	assert.Equal(t, handler.NewIntReply(3), kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "not", "dest", "b")))
+	assert.Equal(t, handler.NewBulkReply([]byte{^byte('a'), ^byte('b'), ^byte('c')}), kvStore.Get(newTestCmd(database.CmdTypeGet, "dest")))
+
+	// 源 key 均不存在时，删除目标 key
+	assert.Equal(t, handler.NewIntReply(0), kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "xor", "dest", "x", "y")))
+	assert.Equal(t, handler.NewNillReply(), kvStore.Get(newTestCmd(database.CmdTypeGet, "dest")))
+
+	assert.True(t, handler.IsErrReply(kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "not", "dest", "a", "b"))))
+	assert.True(t, handler.IsErrReply(kvStore.BitOp(newTestCmd(database.CmdTypeBitOp, "nand", "dest", "a"))))
+}
+
+func Test_bitmap_field(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	bitField := func(args ...string) handler.Reply {
+		return kvStore.BitField(newTestCmd(database.CmdTypeBitField, args...))
+	}
+	ints := func(vals ...int64) handler.Reply {
+		replies := make([]handler.Reply, 0, len(vals))
+		for _, val := range vals {
+			replies = append(replies, handler.NewIntReply(val))
+		}
+		return handler.NewArrayReply(replies)
+	}
+
+	t.Run("wrap_and_sat", func(t *testing.T) {
+		for _, expect := range [][]int64{{1, 1}, {2, 2}, {3, 3}, {0, 3}} {
+			assert.Equal(t, ints(expect...), bitField("k", "incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"))
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{handler.NewNillReply()}),
+			bitField("k", "overflow", "fail", "incrby", "u2", "102", "1"))
+		assert.Equal(t, ints(3), bitField("k", "get", "u2", "102"))
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		assert.Equal(t, ints(0, 6, 0), bitField("s", "set", "i8", "#0", "100", "get", "u4", "0", "set", "i8", "#1", "-129"))
+		assert.Equal(t, ints(100, 127), bitField("s", "get", "i8", "0", "get", "i8", "8"))
+		assert.Equal(t, ints(100, -128), bitField("s", "overflow", "sat", "set", "i8", "0", "-200", "get", "i8", "0"))
+		assert.Equal(t, ints(-128), bitField("s", "overflow", "sat", "incrby", "i8", "0", "-1"))
+		assert.Equal(t, ints(127), bitField("s", "incrby", "i8", "0", "-1"))
+	})
+
+	t.Run("64bits", func(t *testing.T) {
+		assert.Equal(t, ints(0, math.MinInt64, math.MaxInt64-4),
+			bitField("l", "set", "i64", "0", "-1", "incrby", "i64", "0", strconv.FormatInt(-math.MaxInt64, 10), "incrby", "i64", "0", "-5"))
+		assert.Equal(t, ints(math.MaxInt64),
+			bitField("l", "overflow", "sat", "incrby", "i64", "0", "10"))
+		assert.Equal(t, ints(0, math.MaxInt64),
+			bitField("u", "set", "u63", "0", "-1", "get", "u63", "0"))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.True(t, handler.IsErrReply(bitField("k", "get", "u64", "0")))
+		assert.True(t, handler.IsErrReply(bitField("k", "get", "i65", "0")))
+		assert.True(t, handler.IsErrReply(bitField("k", "set", "i8", "0")))
+		assert.True(t, handler.IsErrReply(bitField("k", "overflow", "none", "get", "i8", "0")))
+		assert.True(t, handler.IsErrReply(kvStore.BitFieldRO(newTestCmd(database.CmdTypeBitFieldRO, "k", "set", "i8", "0", "1"))))
+	})
+}
@@ -1,42 +1,226 @@
 package datastore
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
 func (k *KVStore) GC() {
 	// 找出当前所有已过期的 key，批量回收
-	nowUnix := lib.TimeNow().Unix()
-	for _, expiredKey := range k.expireTimeWheel.Range(0, nowUnix) {
-		k.expireProcess(expiredKey)
+	nowMilli := float64(lib.TimeNow().UnixMilli())
+	for _, expired := range k.expireTimeWheel.Range(scoreRange(0, nowMilli)) {
+		k.expireProcess(expired.member)
+	}
+
+	// 回收 hash 中已过期的字段
+	for _, expired := range k.fieldExpireTimeWheel.Range(scoreRange(0, nowMilli)) {
+		k.expireFields(expired.member)
 	}
 }
 
 func (k *KVStore) ExpirePreprocess(key string) {
 	expiredAt, ok := k.expiredAt[key]
 	if !ok {
+		k.fieldExpirePreprocess(key)
 		return
 	}
 
 	if expiredAt.After(lib.TimeNow()) {
+		k.fieldExpirePreprocess(key)
 		return
 	}
 
 	k.expireProcess(key)
 }
 
+func (k *KVStore) fieldExpirePreprocess(key string) {
+	expiredAt, ok := k.fieldExpiredAt[key]
+	if !ok || expiredAt.After(lib.TimeNow()) {
+		return
+	}
+	k.expireFields(key)
+}
+
 func (k *KVStore) expireProcess(key string) {
 	delete(k.expiredAt, key)
 	delete(k.data, key)
 	k.expireTimeWheel.Rem(key)
+	k.cancelFieldExpire(key)
 }
 
+// 移除 key 的过期时间
+func (k *KVStore) cancelExpire(key string) {
+	if _, ok := k.expiredAt[key]; !ok {
+		return
+	}
+	delete(k.expiredAt, key)
+	k.expireTimeWheel.Rem(key)
+}
+
+// 时间轮以毫秒级的 unix 时间戳作为 score，在 float64 的精度范围内可以精确表示
 func (k *KVStore) expire(key string, expiredAt time.Time) {
 	if _, ok := k.data[key]; !ok {
 		return
 	}
 	k.expiredAt[key] = expiredAt
-	k.expireTimeWheel.Add(expiredAt.Unix(), key)
+	k.expireTimeWheel.Add(float64(expiredAt.UnixMilli()), key)
+}
+
+// 删除 hash 中已过期的字段，字段被全部删除后删除 key
+func (k *KVStore) expireFields(key string) {
+	hmap, ok := k.data[key].(HashMap)
+	if !ok {
+		k.cancelFieldExpire(key)
+		return
+	}
+
+	hmap.ExpireFields(lib.TimeNow())
+	if hmap.Len() == 0 {
+		k.del(key)
+		return
+	}
+	k.scheduleFieldExpire(key, hmap)
+}
+
+// hash 字段的过期时间变更后，以最早的字段过期时间作为 key 在字段时间轮中的 score
+func (k *KVStore) scheduleFieldExpire(key string, hmap HashMap) {
+	expiredAt, ok := hmap.NextExpiredAt()
+	if !ok {
+		k.cancelFieldExpire(key)
+		return
+	}
+	k.fieldExpiredAt[key] = expiredAt
+	k.fieldExpireTimeWheel.Add(float64(expiredAt.UnixMilli()), key)
+}
+
+func (k *KVStore) cancelFieldExpire(key string) {
+	if _, ok := k.fieldExpiredAt[key]; !ok {
+		return
+	}
+	delete(k.fieldExpiredAt, key)
+	k.fieldExpireTimeWheel.Rem(key)
+}
+
+func pexpireAtCmd(key string, expiredAt time.Time) [][]byte {
+	return [][]byte{[]byte(database.CmdTypePExpireAt), []byte(key), []byte(strconv.FormatInt(expiredAt.UnixMilli(), 10))}
+}
+
+func hpexpireAtCmd(key string, milli int64, fields [][]byte) [][]byte {
+	cmd := [][]byte{[]byte(database.CmdTypeHPExpireAt), []byte(key), []byte(strconv.FormatInt(milli, 10)),
+		[]byte("FIELDS"), []byte(strconv.Itoa(len(fields)))}
+	return append(cmd, fields...)
+}
+
+// 解析 FIELDS numfields field [field ...] 形式的参数
+func parseFields(args [][]byte) ([][]byte, handler.Reply) {
+	if len(args) < 2 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, handler.NewErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+
+	numFields, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numFields <= 0 {
+		return nil, handler.NewErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != int64(len(args)-2) {
+		return nil, handler.NewErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// 剩余时间四舍五入到 unit，absolute 为 true 时返回 unix 时间戳
+func ttlOf(expiredAt time.Time, unit time.Duration, absolute bool) int64 {
+	if absolute {
+		return expiredAt.UnixMilli() / int64(unit/time.Millisecond)
+	}
+
+	remain := expiredAt.Sub(lib.TimeNow())
+	if remain < 0 {
+		remain = 0
+	}
+	return int64((remain + unit/2) / unit)
+}
+
+// 将 expire 类指令的时间参数解析为绝对时间. unit 为时间单位，absolute 标识参数是否为 unix 时间戳
+func parseExpireTime(cmd *database.Command, arg []byte, unit time.Duration, absolute bool) (time.Time, handler.Reply) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		// 兼容旧版本 aof 文件中 expireat 指令使用的时间格式
+		if absolute && unit == time.Second && handler.IsLoadingPattern(cmd.Ctx()) {
+			if expiredAt, err := lib.ParseTimeSecondFormat(string(arg)); err == nil {
+				return expiredAt, nil
+			}
+		}
+		return time.Time{}, handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+
+	invalidErr := handler.NewErrReply(fmt.Sprintf("ERR invalid expire time in '%s' command", cmd.Name()))
+	factor := int64(unit / time.Millisecond)
+	if v > math.MaxInt64/factor || v < math.MinInt64/factor {
+		return time.Time{}, invalidErr
+	}
+
+	milli := v * factor
+	if !absolute {
+		now := lib.TimeNow().UnixMilli()
+		if milli > math.MaxInt64-now {
+			return time.Time{}, invalidErr
+		}
+		milli += now
+	}
+	return time.UnixMilli(milli), nil
+}
+
+// expire 类指令的 NX XX GT LT 选项
+type expireCondition struct {
+	nx, xx, gt, lt bool
+}
+
+func parseExpireCondition(args [][]byte) (expireCondition, handler.Reply) {
+	var cond expireCondition
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			cond.nx = true
+		case "xx":
+			cond.xx = true
+		case "gt":
+			cond.gt = true
+		case "lt":
+			cond.lt = true
+		default:
+			return cond, handler.NewErrReply(fmt.Sprintf("ERR Unsupported option %s", arg))
+		}
+	}
+
+	if cond.nx && (cond.xx || cond.gt || cond.lt) {
+		return cond, handler.NewErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if cond.gt && cond.lt {
+		return cond, handler.NewErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return cond, nil
+}
+
+// 没有过期时间的 key，视为 ttl 无穷大
+func (e expireCondition) satisfied(current time.Time, volatile bool, expiredAt time.Time) bool {
+	if e.nx && volatile {
+		return false
+	}
+	if e.xx && !volatile {
+		return false
+	}
+	if e.gt && (!volatile || !expiredAt.After(current)) {
+		return false
+	}
+	if e.lt && volatile && !expiredAt.Before(current) {
+		return false
+	}
+	return true
 }
@@ -0,0 +1,722 @@
+package datastore
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// geo 基于 sorted set 实现：经纬度编码为 52 bit 的 geohash 并作为 score 存储，
+// 因此可以与 zrange/zrem 等指令混用. 编码方式、距离计算与 redis 保持一致
+
+const (
+	// 经度与纬度各占 26 bit
+	geoStepMax = 26
+	geoLongMin = -180
+	geoLongMax = 180
+	// 墨卡托投影下的纬度范围
+	geoLatMin = -85.05112878
+	geoLatMax = 85.05112878
+	// 地球半径，单位为米
+	geoEarthRadius = 6372797.560856
+	// 墨卡托投影下赤道长度的一半，用于估算搜索使用的精度
+	geoMercatorMax = 20037726.37
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLongRange = geoRange{min: geoLongMin, max: geoLongMax}
+	geoLatRange  = geoRange{min: geoLatMin, max: geoLatMax}
+	// geohash 指令输出的字符串使用标准的纬度范围
+	geoStdLatRange = geoRange{min: -90, max: 90}
+)
+
+func (k *KVStore) GeoAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	zaddArgs := [][]byte{args[0]}
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx", "xx", "ch":
+			zaddArgs = append(zaddArgs, args[i])
+		default:
+			break flags
+		}
+	}
+
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	// 转为 zadd 形式的参数，score 为经纬度的 geohash
+	for i := 0; i < len(triples); i += 3 {
+		long, lat, errReply := parseLongLat(triples[i], triples[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		hash := geoEncode(geoLongRange, geoLatRange, long, lat, geoStepMax)
+		zaddArgs = append(zaddArgs, []byte(formatScore(float64(hash.bits))), triples[i+2])
+	}
+	return k.zadd(cmd, zaddArgs)
+}
+
+func (k *KVStore) GeoDist(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 4 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	unit := float64(1)
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = parseGeoUnit(args[3]); !ok {
+			return newGeoUnitErrReply()
+		}
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if zset == nil {
+		return handler.NewNillReply()
+	}
+
+	score1, ok1 := zset.Score(string(args[1]))
+	score2, ok2 := zset.Score(string(args[2]))
+	if !ok1 || !ok2 {
+		return handler.NewNillReply()
+	}
+
+	long1, lat1 := geoDecodeScore(score1)
+	long2, lat2 := geoDecodeScore(score2)
+	return handler.NewBulkReply([]byte(formatGeoDist(geoDistance(long1, lat1, long2, lat2) / unit)))
+}
+
+// 不存在的 member 对应的位置为 nil
+func (k *KVStore) GeoPos(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		var (
+			score float64
+			ok    bool
+		)
+		if zset != nil {
+			score, ok = zset.Score(string(member))
+		}
+		if !ok {
+			res = append(res, handler.NewNillMultiBulkReply())
+			continue
+		}
+		long, lat := geoDecodeScore(score)
+		res = append(res, handler.NewMultiBulkReply([][]byte{[]byte(formatGeoCoord(long)), []byte(formatGeoCoord(lat))}))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// 返回 11 位的 geohash 字符串. 标准 geohash 的纬度范围为 [-90,90]，因此需要重新编码
+func (k *KVStore) GeoHash(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([][]byte, 0, len(args)-1)
+	for _, member := range args[1:] {
+		var (
+			score float64
+			ok    bool
+		)
+		if zset != nil {
+			score, ok = zset.Score(string(member))
+		}
+		if !ok {
+			res = append(res, nil)
+			continue
+		}
+
+		long, lat := geoDecodeScore(score)
+		hash := geoEncode(geoLongRange, geoStdLatRange, long, lat, geoStepMax)
+		buf := make([]byte, 11)
+		for i := range buf {
+			// 52 bit 只能表示 10 个字符，最后一个字符固定为 0
+			var idx uint64
+			if i < 10 {
+				idx = (hash.bits >> uint(52-(i+1)*5)) & 0x1f
+			}
+			buf[i] = geoAlphabet[idx]
+		}
+		res = append(res, buf)
+	}
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) GeoSearch(cmd *database.Command) handler.Reply {
+	return k.geoSearchGeneric(cmd, false)
+}
+
+// 将搜索结果写入 dst，覆盖 dst 原有的数据以及过期时间. STOREDIST 选项以距离作为 score
+func (k *KVStore) GeoSearchStore(cmd *database.Command) handler.Reply {
+	return k.geoSearchGeneric(cmd, true)
+}
+
+func (k *KVStore) geoSearchGeneric(cmd *database.Command, store bool) handler.Reply {
+	args := cmd.Args()
+	var dest string
+	if store {
+		dest, args = string(args[0]), args[1:]
+	}
+
+	req, errReply := parseGeoSearchRequest(args, cmd.Name(), store)
+	if errReply != nil {
+		return errReply
+	}
+
+	zset, err := k.getAsSortedSet(req.key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if zset == nil {
+		if store {
+			return handler.NewIntReply(k.storeZSet(dest, nil))
+		}
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	if req.fromMember != nil {
+		score, ok := zset.Score(string(req.fromMember))
+		if !ok {
+			return handler.NewErrReply("ERR could not decode requested zset member")
+		}
+		req.shape.long, req.shape.lat = geoDecodeScore(score)
+	}
+
+	var limit int64
+	if req.any {
+		limit = req.count
+	}
+	points := req.shape.search(zset, limit)
+
+	if req.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if req.sort > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if req.count > 0 && int64(len(points)) > req.count {
+		points = points[:req.count]
+	}
+
+	if store {
+		items := make([]zsetItem, 0, len(points))
+		for _, point := range points {
+			score := point.score
+			if req.storeDist {
+				score = point.dist / req.shape.unit
+			}
+			items = append(items, zsetItem{member: point.member, score: score})
+		}
+		return handler.NewIntReply(k.storeZSet(dest, items))
+	}
+	return newGeoPointsReply(points, req)
+}
+
+func newGeoPointsReply(points []geoPoint, req *geoSearchRequest) handler.Reply {
+	if !req.withDist && !req.withHash && !req.withCoord {
+		members := make([][]byte, 0, len(points))
+		for _, point := range points {
+			members = append(members, []byte(point.member))
+		}
+		return handler.NewMultiBulkReply(members)
+	}
+
+	// 依次为 member、距离、geohash 以及经纬度
+	res := make([]handler.Reply, 0, len(points))
+	for _, point := range points {
+		item := []handler.Reply{handler.NewBulkReply([]byte(point.member))}
+		if req.withDist {
+			item = append(item, handler.NewBulkReply([]byte(formatGeoDist(point.dist/req.shape.unit))))
+		}
+		if req.withHash {
+			item = append(item, handler.NewIntReply(int64(point.score)))
+		}
+		if req.withCoord {
+			item = append(item, handler.NewMultiBulkReply([][]byte{[]byte(formatGeoCoord(point.long)), []byte(formatGeoCoord(point.lat))}))
+		}
+		res = append(res, handler.NewArrayReply(item))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// sort 为 1 时按照距离升序排列，为 -1 时降序排列，为 0 时不排序. any 为 true 时找到 count 个元素后立即返回
+type geoSearchRequest struct {
+	key        string
+	fromMember []byte
+	shape      geoShape
+	sort       int
+	count      int64
+	any        bool
+	withDist   bool
+	withHash   bool
+	withCoord  bool
+	storeDist  bool
+}
+
+// 解析 key FROMMEMBER member | FROMLONLAT longitude latitude BYRADIUS radius unit | BYBOX width height unit
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH] [STOREDIST] 形式的参数
+func parseGeoSearchRequest(args [][]byte, name database.CmdType, store bool) (*geoSearchRequest, handler.Reply) {
+	req := geoSearchRequest{key: string(args[0])}
+
+	var from, by bool
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "frommember" && i+1 < len(args):
+			if from {
+				return nil, newGeoFromErrReply(name)
+			}
+			req.fromMember, from = args[i+1], true
+			i++
+		case opt == "fromlonlat" && i+2 < len(args):
+			if from {
+				return nil, newGeoFromErrReply(name)
+			}
+			long, lat, errReply := parseLongLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			req.shape.long, req.shape.lat, from = long, lat, true
+			i += 2
+		case opt == "byradius" && i+2 < len(args):
+			if by {
+				return nil, newGeoByErrReply(name)
+			}
+			radius, ok := parseScore(args[i+1])
+			if !ok {
+				return nil, handler.NewErrReply("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, handler.NewErrReply("ERR radius cannot be negative")
+			}
+			unit, ok := parseGeoUnit(args[i+2])
+			if !ok {
+				return nil, newGeoUnitErrReply()
+			}
+			req.shape.radius, req.shape.unit, by = radius*unit, unit, true
+			i += 2
+		case opt == "bybox" && i+3 < len(args):
+			if by {
+				return nil, newGeoByErrReply(name)
+			}
+			width, ok1 := parseScore(args[i+1])
+			height, ok2 := parseScore(args[i+2])
+			if !ok1 || !ok2 {
+				return nil, handler.NewErrReply("ERR need numeric width and height")
+			}
+			if width < 0 || height < 0 {
+				return nil, handler.NewErrReply("ERR height or width cannot be negative")
+			}
+			unit, ok := parseGeoUnit(args[i+3])
+			if !ok {
+				return nil, newGeoUnitErrReply()
+			}
+			req.shape.box, req.shape.width, req.shape.height, req.shape.unit, by = true, width*unit, height*unit, unit, true
+			i += 3
+		case opt == "asc":
+			req.sort = 1
+		case opt == "desc":
+			req.sort = -1
+		case opt == "count" && i+1 < len(args):
+			count, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			if count <= 0 {
+				return nil, handler.NewErrReply("ERR COUNT must be > 0")
+			}
+			req.count = count
+			i++
+		case opt == "any":
+			req.any = true
+		case opt == "withdist":
+			req.withDist = true
+		case opt == "withhash":
+			req.withHash = true
+		case opt == "withcoord":
+			req.withCoord = true
+		case opt == "storedist" && store:
+			req.storeDist = true
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	if !from {
+		return nil, newGeoFromErrReply(name)
+	}
+	if !by {
+		return nil, newGeoByErrReply(name)
+	}
+	if req.any && req.count == 0 {
+		return nil, handler.NewErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	if store && (req.withDist || req.withHash || req.withCoord) {
+		return nil, handler.NewErrReply(fmt.Sprintf("ERR STORE option in %s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", name))
+	}
+
+	// 指定 COUNT 但没有指定 ANY 时，返回距离最近的 count 个元素
+	if req.count > 0 && !req.any && req.sort == 0 {
+		req.sort = 1
+	}
+	return &req, nil
+}
+
+func newGeoFromErrReply(name database.CmdType) handler.Reply {
+	return handler.NewErrReply(fmt.Sprintf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name))
+}
+
+func newGeoByErrReply(name database.CmdType) handler.Reply {
+	return handler.NewErrReply(fmt.Sprintf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", name))
+}
+
+func newGeoUnitErrReply() handler.Reply {
+	return handler.NewErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func parseLongLat(rawLong, rawLat []byte) (float64, float64, handler.Reply) {
+	long, ok1 := parseScore(rawLong)
+	lat, ok2 := parseScore(rawLat)
+	if !ok1 || !ok2 {
+		return 0, 0, handler.NewErrReply("ERR value is not a valid float")
+	}
+	if long < geoLongMin || long > geoLongMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, handler.NewErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", long, lat))
+	}
+	return long, lat, nil
+}
+
+// 距离单位换算为米的倍数
+func parseGeoUnit(arg []byte) (float64, bool) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	default:
+		return 0, false
+	}
+}
+
+func formatGeoDist(dist float64) string {
+	return strconv.FormatFloat(dist, 'f', 4, 64)
+}
+
+func formatGeoCoord(coord float64) string {
+	return strconv.FormatFloat(coord, 'g', 17, 64)
+}
+
+// 指定精度的 geohash. 纬度占据偶数位，经度占据奇数位
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+func geoEncode(longRange, latRange geoRange, long, lat float64, step uint) geoHash {
+	scale := float64(uint64(1) << step)
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min) * scale
+	longOffset := (long - longRange.min) / (longRange.max - longRange.min) * scale
+	// 取值为范围上限时，归入最后一个区间
+	latOffset, longOffset = math.Min(latOffset, scale-1), math.Min(longOffset, scale-1)
+	return geoHash{bits: interleave(uint32(latOffset), uint32(longOffset)), step: step}
+}
+
+// geohash 对应的经度与纬度区间
+func (h geoHash) area(longRange, latRange geoRange) (geoRange, geoRange) {
+	latBits, longBits := deinterleave(h.bits)
+	scale := float64(uint64(1) << h.step)
+	latScale, longScale := latRange.max-latRange.min, longRange.max-longRange.min
+	return geoRange{
+		min: longRange.min + float64(longBits)/scale*longScale,
+		max: longRange.min + (float64(longBits)+1)/scale*longScale,
+	}, geoRange{
+		min: latRange.min + float64(latBits)/scale*latScale,
+		max: latRange.min + (float64(latBits)+1)/scale*latScale,
+	}
+}
+
+// 向东(dLong > 0)西(dLong < 0)、北(dLat > 0)南(dLat < 0)方向移动一格，越过边界时回绕
+func (h geoHash) move(dLong, dLat int) geoHash {
+	shift := 64 - 2*h.step
+	long, lat := h.bits&0xaaaaaaaaaaaaaaaa, h.bits&0x5555555555555555
+	if dLong != 0 {
+		zz := uint64(0x5555555555555555) >> shift
+		if dLong > 0 {
+			long += zz + 1
+		} else {
+			long = (long | zz) - (zz + 1)
+		}
+		long &= uint64(0xaaaaaaaaaaaaaaaa) >> shift
+	}
+	if dLat != 0 {
+		zz := uint64(0xaaaaaaaaaaaaaaaa) >> shift
+		if dLat > 0 {
+			lat += zz + 1
+		} else {
+			lat = (lat | zz) - (zz + 1)
+		}
+		lat &= uint64(0x5555555555555555) >> shift
+	}
+	return geoHash{bits: long | lat, step: h.step}
+}
+
+// geohash 覆盖的 score 区间，左闭右开
+func (h geoHash) scoreRange() zrangeSpec {
+	shift := 2 * (geoStepMax - h.step)
+	return zrangeSpec{
+		min:   zsetBound{score: float64(h.bits << shift)},
+		max:   zsetBound{score: float64((h.bits + 1) << shift), exclusive: true},
+		count: -1,
+	}
+}
+
+// 将 52 bit 的 score 解码为所在区间的中心点
+func geoDecodeScore(score float64) (float64, float64) {
+	longRange, latRange := geoHash{bits: uint64(score), step: geoStepMax}.area(geoLongRange, geoLatRange)
+	long := math.Max(geoLongMin, math.Min(geoLongMax, (longRange.min+longRange.max)/2))
+	lat := math.Max(geoLatMin, math.Min(geoLatMax, (latRange.min+latRange.max)/2))
+	return long, lat
+}
+
+// x 占据偶数位，y 占据奇数位
+func interleave(x, y uint32) uint64 {
+	var res uint64
+	for i := uint(0); i < 32; i++ {
+		res |= uint64(x>>i&1)<<(2*i) | uint64(y>>i&1)<<(2*i+1)
+	}
+	return res
+}
+
+func deinterleave(bits uint64) (uint32, uint32) {
+	var x, y uint32
+	for i := uint(0); i < 32; i++ {
+		x |= uint32(bits>>(2*i)&1) << i
+		y |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// 球面上两点之间的距离，单位为米
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	v := math.Sin((degRad(long2) - degRad(long1)) / 2)
+	// 经度相同时只需计算纬度方向的距离
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	u := math.Sin((degRad(lat2) - degRad(lat1)) / 2)
+	a := u*u + math.Cos(degRad(lat1))*math.Cos(degRad(lat2))*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return geoEarthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+type geoPoint struct {
+	member    string
+	score     float64
+	long, lat float64
+	// 与搜索中心的距离，单位为米
+	dist float64
+}
+
+// geosearch 的搜索范围. box 为 true 时为矩形，否则为圆形，长度单位均为米，unit 为输出时使用的单位
+type geoShape struct {
+	long, lat     float64
+	radius        float64
+	box           bool
+	width, height float64
+	unit          float64
+}
+
+// 北、南、东、西、东北、西北、东南、西南方向，分别为经度与纬度的移动量
+var geoNeighborDirections = [8][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+
+// 在 zset 中查找范围内的元素. limit 大于 0 时找到 limit 个元素后立即返回
+func (s *geoShape) search(zset SortedSet, limit int64) []geoPoint {
+	var points []geoPoint
+	for _, hash := range s.areas() {
+		for _, item := range zset.Range(hash.scoreRange()) {
+			long, lat := geoDecodeScore(item.score)
+			dist, ok := s.contains(long, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{member: item.member, score: item.score, long: long, lat: lat, dist: dist})
+			if limit > 0 && int64(len(points)) >= limit {
+				return points
+			}
+		}
+	}
+	return points
+}
+
+// 覆盖搜索范围的 geohash：中心点所在的区间以及相邻的 8 个区间，区间的大小根据搜索范围估算
+func (s *geoShape) areas() []geoHash {
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+	radius := s.radius
+	if s.box {
+		radius = math.Sqrt(s.width*s.width/4 + s.height*s.height/4)
+	}
+
+	step := geoEstimateStep(radius, s.lat)
+	hashes := s.neighbors(step)
+
+	// 相邻区间不足以覆盖搜索范围时，降低精度
+	_, north := hashes[1].area(geoLongRange, geoLatRange)
+	_, south := hashes[2].area(geoLongRange, geoLatRange)
+	east, _ := hashes[3].area(geoLongRange, geoLatRange)
+	west, _ := hashes[4].area(geoLongRange, geoLatRange)
+	if step > 1 && (north.max < maxLat || south.min > minLat || east.max < maxLong || west.min > minLong) {
+		step--
+		hashes = s.neighbors(step)
+	}
+
+	// 排除与搜索范围没有交集的相邻区间
+	skipped := make([]bool, len(hashes))
+	if step >= 2 {
+		longRange, latRange := hashes[0].area(geoLongRange, geoLatRange)
+		if latRange.min < minLat {
+			skipped[2], skipped[7], skipped[8] = true, true, true
+		}
+		if latRange.max > maxLat {
+			skipped[1], skipped[5], skipped[6] = true, true, true
+		}
+		if longRange.min < minLong {
+			skipped[4], skipped[6], skipped[8] = true, true, true
+		}
+		if longRange.max > maxLong {
+			skipped[3], skipped[5], skipped[7] = true, true, true
+		}
+	}
+
+	// 精度较低时相邻区间可能重复
+	areas := make([]geoHash, 0, len(hashes))
+	visited := make(map[uint64]struct{}, len(hashes))
+	for i, hash := range hashes {
+		if skipped[i] {
+			continue
+		}
+		if _, ok := visited[hash.bits]; ok {
+			continue
+		}
+		visited[hash.bits] = struct{}{}
+		areas = append(areas, hash)
+	}
+	return areas
+}
+
+// 中心点所在的 geohash 以及北、南、东、西、东北、西北、东南、西南方向的相邻 geohash
+func (s *geoShape) neighbors(step uint) []geoHash {
+	hash := geoEncode(geoLongRange, geoLatRange, s.long, s.lat, step)
+	hashes := []geoHash{hash}
+	for _, direction := range geoNeighborDirections {
+		hashes = append(hashes, hash.move(direction[0], direction[1]))
+	}
+	return hashes
+}
+
+// 搜索范围的外接矩形
+func (s *geoShape) boundingBox() (minLong, minLat, maxLong, maxLat float64) {
+	width, height := s.radius, s.radius
+	if s.box {
+		width, height = s.width/2, s.height/2
+	}
+
+	latDelta := radDeg(height / geoEarthRadius)
+	longDeltaTop := radDeg(width / geoEarthRadius / math.Cos(degRad(s.lat+latDelta)))
+	longDeltaBottom := radDeg(width / geoEarthRadius / math.Cos(degRad(s.lat-latDelta)))
+	// 取靠近极点一侧的经度跨度，该侧更宽
+	longDelta := longDeltaTop
+	if s.lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.long - longDelta, s.lat - latDelta, s.long + longDelta, s.lat + latDelta
+}
+
+// 坐标是否位于搜索范围内，同时返回与中心点的距离
+func (s *geoShape) contains(long, lat float64) (float64, bool) {
+	if !s.box {
+		dist := geoDistance(s.long, s.lat, long, lat)
+		return dist, dist <= s.radius
+	}
+
+	// 纬度方向的距离计算开销更小，优先检查
+	if geoLatDistance(lat, s.lat) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(long, lat, s.long, lat) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.long, s.lat, long, lat), true
+}
+
+// 根据搜索半径估算 geohash 的精度，使得中心点及其相邻的区间能够覆盖搜索范围
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for ; radius < geoMercatorMax; step++ {
+		radius *= 2
+	}
+	step -= 2
+
+	// 高纬度地区经度方向的区间更窄
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
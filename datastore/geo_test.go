@@ -0,0 +1,124 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func Test_geohash(t *testing.T) {
+	hash := geoEncode(geoLongRange, geoLatRange, 13.361389, 38.115556, geoStepMax)
+	assert.Equal(t, uint64(3479099956230698), hash.bits)
+
+	// 解码结果为区间的中心点，与原始坐标的误差很小
+	long, lat := geoDecodeScore(float64(hash.bits))
+	assert.InDelta(t, 13.361389, long, 1e-5)
+	assert.InDelta(t, 38.115556, lat, 1e-5)
+
+	// 相邻区间回绕
+	hash = geoHash{bits: 0, step: 2}
+	assert.Equal(t, hash, hash.move(1, 1).move(-1, -1))
+	assert.Equal(t, geoHash{bits: 0b1010, step: 2}, hash.move(-1, 0))
+
+	assert.Equal(t, uint(geoStepMax), geoEstimateStep(0, 0))
+	assert.Equal(t, uint(1), geoEstimateStep(1e8, 0))
+	assert.Less(t, geoEstimateStep(1000, 70), geoEstimateStep(1000, 0))
+}
+
+func Test_geo_cmd(t *testing.T) {
+	for _, thinker := range []Thinker{testThinker{}, smallThinker{}} {
+		kvStore := NewKVStore(thinker).(*KVStore)
+		members := func(members ...string) handler.Reply {
+			args := make([][]byte, 0, len(members))
+			for _, member := range members {
+				args = append(args, []byte(member))
+			}
+			return handler.NewMultiBulkReply(args)
+		}
+
+		t.Run("geoadd", func(t *testing.T) {
+			assert.Equal(t, handler.NewIntReply(2), kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd,
+				"sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "nx", "0", "0", "Palermo")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "xx", "0", "0", "nope")))
+
+			assert.True(t, handler.IsErrReply(kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "200", "0", "x"))))
+			assert.True(t, handler.IsErrReply(kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "0", "86", "x"))))
+			assert.True(t, handler.IsErrReply(kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "0", "0"))))
+			assert.True(t, handler.IsErrReply(kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "nx", "xx", "0", "0", "x"))))
+
+			// score 为 52 bit 的 geohash，可以与 zset 指令混用
+			assert.Equal(t, handler.NewBulkReply([]byte("3479099956230698")), kvStore.ZScore(newTestCmd(database.CmdTypeZScore, "sicily", "Palermo")))
+			assert.Equal(t, members("Palermo", "Catania"), kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "sicily", "0", "-1")))
+		})
+
+		t.Run("geodist geopos geohash", func(t *testing.T) {
+			assert.Equal(t, handler.NewBulkReply([]byte("166274.1516")), kvStore.GeoDist(newTestCmd(database.CmdTypeGeoDist, "sicily", "Palermo", "Catania")))
+			assert.Equal(t, handler.NewBulkReply([]byte("166.2742")), kvStore.GeoDist(newTestCmd(database.CmdTypeGeoDist, "sicily", "Palermo", "Catania", "km")))
+			assert.Equal(t, handler.NewBulkReply([]byte("103.3182")), kvStore.GeoDist(newTestCmd(database.CmdTypeGeoDist, "sicily", "Palermo", "Catania", "MI")))
+			assert.Equal(t, handler.NewNillReply(), kvStore.GeoDist(newTestCmd(database.CmdTypeGeoDist, "sicily", "Palermo", "nope")))
+			assert.True(t, handler.IsErrReply(kvStore.GeoDist(newTestCmd(database.CmdTypeGeoDist, "sicily", "Palermo", "Catania", "yd"))))
+
+			assert.Equal(t, handler.NewArrayReply([]handler.Reply{
+				members("13.361389338970184", "38.115556395496299"), handler.NewNillMultiBulkReply(),
+			}), kvStore.GeoPos(newTestCmd(database.CmdTypeGeoPos, "sicily", "Palermo", "nope")))
+
+			assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("sqc8b49rny0"), []byte("sqdtr74hyu0"), nil}),
+				kvStore.GeoHash(newTestCmd(database.CmdTypeGeoHash, "sicily", "Palermo", "Catania", "nope")))
+		})
+
+		t.Run("geosearch", func(t *testing.T) {
+			kvStore.GeoAdd(newTestCmd(database.CmdTypeGeoAdd, "sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"))
+			search := func(args ...string) handler.Reply {
+				return kvStore.GeoSearch(newTestCmd(database.CmdTypeGeoSearch, append([]string{"sicily"}, args...)...))
+			}
+
+			assert.Equal(t, members("Catania", "Palermo"), search("fromlonlat", "15", "37", "byradius", "200", "km", "asc"))
+			assert.Equal(t, members("edge1", "edge2", "Palermo", "Catania"), search("fromlonlat", "15", "37", "bybox", "400", "400", "km", "desc"))
+			assert.Equal(t, members("Catania", "Palermo"), search("fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "2"))
+			assert.Equal(t, members("Palermo"), search("frommember", "Palermo", "byradius", "0", "m"))
+			assert.Equal(t, handler.NewArrayReply([]handler.Reply{
+				handler.NewArrayReply([]handler.Reply{
+					handler.NewBulkReply([]byte("Catania")), handler.NewBulkReply([]byte("56.4413")), handler.NewIntReply(3479447370796909),
+					members("15.087267458438873", "37.50266842333162"),
+				}),
+			}), search("fromlonlat", "15", "37", "byradius", "100", "km", "withcoord", "withdist", "withhash"))
+
+			// any 找到 count 个元素后立即返回，不保证是最近的元素
+			reply := search("fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "3", "any").(*handler.MultiBulkReply)
+			assert.Equal(t, 3, len(reply.Args()))
+
+			assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.GeoSearch(newTestCmd(database.CmdTypeGeoSearch, "nope", "fromlonlat", "0", "0", "byradius", "1", "m")))
+			assert.True(t, handler.IsErrReply(search("frommember", "nope", "byradius", "1", "m")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "frommember", "Palermo", "byradius", "1", "m")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "count", "1")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "byradius", "-1", "m")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "byradius", "1", "m", "any")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "byradius", "1", "m", "count", "0")))
+			assert.True(t, handler.IsErrReply(search("fromlonlat", "15", "37", "byradius", "1", "m", "storedist")))
+		})
+
+		t.Run("geosearchstore", func(t *testing.T) {
+			store := func(args ...string) handler.Reply {
+				return kvStore.GeoSearchStore(newTestCmd(database.CmdTypeGeoSearchStore, append([]string{"dst", "sicily"}, args...)...))
+			}
+
+			assert.Equal(t, handler.NewIntReply(2), store("fromlonlat", "15", "37", "byradius", "200", "km"))
+			assert.Equal(t, kvStore.ZScore(newTestCmd(database.CmdTypeZScore, "sicily", "Catania")),
+				kvStore.ZScore(newTestCmd(database.CmdTypeZScore, "dst", "Catania")))
+
+			assert.Equal(t, handler.NewIntReply(1), store("fromlonlat", "15", "37", "byradius", "200", "km", "count", "1", "storedist"))
+			score := kvStore.ZScore(newTestCmd(database.CmdTypeZScore, "dst", "Catania")).(*handler.BulkReply)
+			dist, _ := parseScore(score.Arg)
+			assert.InDelta(t, 56.4413, dist, 1e-4)
+
+			assert.True(t, handler.IsErrReply(store("fromlonlat", "15", "37", "byradius", "200", "km", "withdist")))
+
+			// 没有匹配的元素时删除 dst
+			assert.Equal(t, handler.NewIntReply(0), store("fromlonlat", "0", "0", "byradius", "1", "m"))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "dst")))
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package datastore
 
 import (
+	"math"
+	"strconv"
+	"time"
+
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/handler"
 )
@@ -23,34 +27,142 @@ func (k *KVStore) putAsHashMap(key string, hmap HashMap) {
 	k.data[key] = hmap
 }
 
+func (k *KVStore) delIfEmptyHashMap(key string, hmap HashMap) {
+	if hmap.Len() == 0 {
+		k.del(key)
+	}
+}
+
 type HashMap interface {
-	Put(key string, value []byte)
+	// 写入字段，字段为新建时返回 1. 覆盖写会清除字段原有的过期时间
+	Put(key string, value []byte) int64
 	Get(key string) []byte
 	Del(key string) int64
-	database.CmdAdapter
+	Len() int64
+	// 遍历全部字段，f 返回 false 时终止遍历
+	ForEach(f func(field string, value []byte) bool)
+	// 随机返回 count 个字段. distinct 为 true 时字段互不重复，至多返回全部字段
+	RandFields(count int64, distinct bool) []string
+	// 字段值自增，字段不存在时视为 0. 返回自增后的值. 自增不会影响字段原有的过期时间
+	IncrBy(field string, delta int64) (int64, error)
+	IncrByFloat(field string, delta float64) ([]byte, error)
+	// 设置字段的过期时间，字段不存在时不做处理
+	Expire(field string, expiredAt time.Time)
+	// 移除字段的过期时间，移除成功时返回 1
+	Persist(field string) int64
+	ExpiredAt(field string) (time.Time, bool)
+	// 所有字段中最早的过期时间
+	NextExpiredAt() (time.Time, bool)
+	// 删除截至 now 已过期的字段，返回删除的字段数量
+	ExpireFields(now time.Time) int64
+	Entity
 }
 
+// 字段数量不超过 hash-max-listpack-entries 且字段与值的长度均不超过 hash-max-listpack-value 时采用 listpack 编码，
+// 字段与值依次成对存放. 否则转为 hashtable 编码. expiredAt 记录设置了过期时间的字段，与编码无关
 type hashMapEntity struct {
-	key  string
-	data map[string][]byte
+	key        string
+	listpack   *listpack
+	data       map[string][]byte
+	expiredAt  map[string]time.Time
+	thresholds *encodingThresholds
 }
 
-func newHashMapEntity(key string) HashMap {
+func newHashMapEntity(key string, thresholds *encodingThresholds) HashMap {
 	return &hashMapEntity{
-		key:  key,
-		data: make(map[string][]byte),
+		key:        key,
+		listpack:   newListpack(),
+		thresholds: thresholds,
 	}
 }
 
-func (h *hashMapEntity) Put(key string, value []byte) {
+func (h *hashMapEntity) Put(key string, value []byte) int64 {
+	delete(h.expiredAt, key)
+	return h.set(key, value)
+}
+
+// 写入字段，保留字段原有的过期时间
+func (h *hashMapEntity) set(key string, value []byte) int64 {
+	if h.listpack != nil {
+		if len(key) <= h.thresholds.hashMaxListpackValue && len(value) <= h.thresholds.hashMaxListpackValue {
+			return h.putListpack(key, value)
+		}
+		h.convert()
+	}
+
+	_, ok := h.data[key]
 	h.data[key] = value
+	if ok {
+		return 0
+	}
+	return 1
+}
+
+func (h *hashMapEntity) putListpack(key string, value []byte) int64 {
+	if offset, ok := h.find(key); ok {
+		// 跳过字段，替换值
+		_, valueOffset := h.listpack.entry(offset)
+		h.listpack.Replace(valueOffset, value)
+		return 0
+	}
+
+	h.listpack.Insert(-1, []byte(key), value)
+	if h.listpack.Len()/2 > h.thresholds.hashMaxListpackEntries {
+		h.convert()
+	}
+	return 1
+}
+
+// 查找字段在 listpack 中的偏移量
+func (h *hashMapEntity) find(key string) (int, bool) {
+	target := -1
+	h.listpack.ForEachPair(func(offset int, field, _ []byte) bool {
+		if string(field) == key {
+			target = offset
+			return false
+		}
+		return true
+	})
+	return target, target >= 0
+}
+
+// listpack 转为 hashtable 编码
+func (h *hashMapEntity) convert() {
+	h.data = make(map[string][]byte, h.listpack.Len()/2)
+	h.listpack.ForEachPair(func(_ int, field, value []byte) bool {
+		h.data[string(field)] = append([]byte{}, value...)
+		return true
+	})
+	h.listpack = nil
 }
 
 func (h *hashMapEntity) Get(key string) []byte {
-	return h.data[key]
+	if h.listpack == nil {
+		return h.data[key]
+	}
+
+	var res []byte
+	h.listpack.ForEachPair(func(_ int, field, value []byte) bool {
+		if string(field) == key {
+			res = append([]byte{}, value...)
+			return false
+		}
+		return true
+	})
+	return res
 }
 
 func (h *hashMapEntity) Del(key string) int64 {
+	delete(h.expiredAt, key)
+	if h.listpack != nil {
+		offset, ok := h.find(key)
+		if !ok {
+			return 0
+		}
+		h.listpack.Delete(offset, 2)
+		return 1
+	}
+
 	if _, ok := h.data[key]; !ok {
 		return 0
 	}
@@ -58,11 +170,190 @@ func (h *hashMapEntity) Del(key string) int64 {
 	return 1
 }
 
+func (h *hashMapEntity) Len() int64 {
+	if h.listpack != nil {
+		return int64(h.listpack.Len() / 2)
+	}
+	return int64(len(h.data))
+}
+
+func (h *hashMapEntity) ForEach(f func(field string, value []byte) bool) {
+	if h.listpack != nil {
+		// 值会被回复等外部逻辑持有，不能引用 listpack 的底层内存
+		h.listpack.ForEachPair(func(_ int, field, value []byte) bool {
+			return f(string(field), append([]byte{}, value...))
+		})
+		return
+	}
+
+	for field, value := range h.data {
+		if !f(field, value) {
+			return
+		}
+	}
+}
+
+// 需要先收集全部字段，耗时为 O(n)
+func (h *hashMapEntity) RandFields(count int64, distinct bool) []string {
+	all := make([]string, 0, h.Len())
+	h.ForEach(func(field string, _ []byte) bool {
+		all = append(all, field)
+		return true
+	})
+
+	indexes := randIndexes(int64(len(all)), count, distinct)
+	fields := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		fields = append(fields, all[index])
+	}
+	return fields
+}
+
+func (h *hashMapEntity) IncrBy(field string, delta int64) (int64, error) {
+	var cur int64
+	if value := h.Get(field); value != nil {
+		var err error
+		if cur, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, handler.NewErrReply("ERR hash value is not an integer")
+		}
+	}
+
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return 0, handler.NewErrReply("ERR increment or decrement would overflow")
+	}
+
+	h.set(field, []byte(strconv.FormatInt(cur+delta, 10)))
+	return cur + delta, nil
+}
+
+func (h *hashMapEntity) IncrByFloat(field string, delta float64) ([]byte, error) {
+	var cur float64
+	if value := h.Get(field); value != nil {
+		var err error
+		if cur, err = strconv.ParseFloat(string(value), 64); err != nil || math.IsNaN(cur) || math.IsInf(cur, 0) {
+			return nil, handler.NewErrReply("ERR hash value is not a float")
+		}
+	}
+
+	res := cur + delta
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return nil, handler.NewErrReply("ERR increment would produce NaN or Infinity")
+	}
+
+	value := []byte(strconv.FormatFloat(res, 'f', -1, 64))
+	h.set(field, value)
+	return value, nil
+}
+
+func (h *hashMapEntity) exist(field string) bool {
+	if h.listpack != nil {
+		_, ok := h.find(field)
+		return ok
+	}
+	_, ok := h.data[field]
+	return ok
+}
+
+func (h *hashMapEntity) Expire(field string, expiredAt time.Time) {
+	if !h.exist(field) {
+		return
+	}
+	if h.expiredAt == nil {
+		h.expiredAt = make(map[string]time.Time)
+	}
+	h.expiredAt[field] = expiredAt
+}
+
+func (h *hashMapEntity) Persist(field string) int64 {
+	if _, ok := h.expiredAt[field]; !ok {
+		return 0
+	}
+	delete(h.expiredAt, field)
+	return 1
+}
+
+func (h *hashMapEntity) ExpiredAt(field string) (time.Time, bool) {
+	expiredAt, ok := h.expiredAt[field]
+	return expiredAt, ok
+}
+
+// 需要遍历全部带有过期时间的字段
+func (h *hashMapEntity) NextExpiredAt() (time.Time, bool) {
+	var (
+		next time.Time
+		ok   bool
+	)
+	for _, expiredAt := range h.expiredAt {
+		if !ok || expiredAt.Before(next) {
+			next, ok = expiredAt, true
+		}
+	}
+	return next, ok
+}
+
+func (h *hashMapEntity) ExpireFields(now time.Time) int64 {
+	var expired int64
+	for field, expiredAt := range h.expiredAt {
+		if expiredAt.After(now) {
+			continue
+		}
+		expired += h.Del(field)
+	}
+	return expired
+}
+
+func (h *hashMapEntity) Rename(key string) {
+	h.key = key
+}
+
+func (h *hashMapEntity) Clone(key string) Entity {
+	cloned := hashMapEntity{key: key, thresholds: h.thresholds}
+	if len(h.expiredAt) > 0 {
+		cloned.expiredAt = make(map[string]time.Time, len(h.expiredAt))
+		for field, expiredAt := range h.expiredAt {
+			cloned.expiredAt[field] = expiredAt
+		}
+	}
+
+	if h.listpack != nil {
+		cloned.listpack = h.listpack.Clone()
+		return &cloned
+	}
+
+	cloned.data = make(map[string][]byte, len(h.data))
+	for field, value := range h.data {
+		cloned.data[field] = append([]byte{}, value...)
+	}
+	return &cloned
+}
+
+func (h *hashMapEntity) Encoding() string {
+	if h.listpack != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
 func (h *hashMapEntity) ToCmd() [][]byte {
-	args := make([][]byte, 0, 2+2*len(h.data))
+	args := make([][]byte, 0, 2)
 	args = append(args, []byte(database.CmdTypeHSet), []byte(h.key))
-	for k, v := range h.data {
-		args = append(args, []byte(k), v)
-	}
+	h.ForEach(func(field string, value []byte) bool {
+		args = append(args, []byte(field), value)
+		return true
+	})
 	return args
 }
+
+// 字段的过期时间以 hpexpireat 指令还原，过期时间相同的字段合并为一条指令
+func (h *hashMapEntity) ExtraCmds() [][][]byte {
+	fields := make(map[int64][][]byte, len(h.expiredAt))
+	for field, expiredAt := range h.expiredAt {
+		fields[expiredAt.UnixMilli()] = append(fields[expiredAt.UnixMilli()], []byte(field))
+	}
+
+	cmds := make([][][]byte, 0, len(fields))
+	for milli, _fields := range fields {
+		cmds = append(cmds, hpexpireAtCmd(h.key, milli, _fields))
+	}
+	return cmds
+}
@@ -1,18 +1,23 @@
 package datastore
 
 import (
+	"bytes"
+	"math"
 	"math/rand"
 	"sort"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
 	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
 func Test_hashmap_crud(t *testing.T) {
-	hashmap := newHashMapEntity("")
+	hashmap := newHashMapEntity("", testThresholds)
 	mp := make(map[int]int, 1000)
 
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
@@ -47,7 +52,7 @@ func Test_hashmap_crud(t *testing.T) {
 }
 
 func Test_hashmap_to_cmd(t *testing.T) {
-	hashmap := newHashMapEntity("")
+	hashmap := newHashMapEntity("", testThresholds)
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
 	mp := make(map[int]int, 1000)
 	// 插入1000条数据
@@ -105,3 +110,192 @@ func Test_hashmap_to_cmd(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
 }
+
+func Test_hashmap_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	bulks := func(strs ...string) handler.Reply {
+		res := make([][]byte, 0, len(strs))
+		for _, str := range strs {
+			res = append(res, []byte(str))
+		}
+		return handler.NewMultiBulkReply(res)
+	}
+
+	assert.Equal(t, handler.NewIntReply(2), kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", "1", "b", "2")))
+	// 仅统计新建的字段
+	assert.Equal(t, handler.NewIntReply(1), kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", "10", "c", "")))
+
+	assert.Equal(t, bulks("a", "10", "b", "2", "c", ""), kvStore.HGetAll(newTestCmd(database.CmdTypeHGetAll, "h")))
+	assert.Equal(t, bulks("a", "b", "c"), kvStore.HKeys(newTestCmd(database.CmdTypeHKeys, "h")))
+	assert.Equal(t, bulks("10", "2", ""), kvStore.HVals(newTestCmd(database.CmdTypeHVals, "h")))
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("2"), nil, []byte("")}), kvStore.HMGet(newTestCmd(database.CmdTypeHMGet, "h", "b", "x", "c")))
+	assert.Equal(t, handler.NewIntReply(3), kvStore.HLen(newTestCmd(database.CmdTypeHLen, "h")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.HExists(newTestCmd(database.CmdTypeHExists, "h", "c")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.HExists(newTestCmd(database.CmdTypeHExists, "h", "x")))
+	assert.Equal(t, handler.NewIntReply(2), kvStore.HStrLen(newTestCmd(database.CmdTypeHStrLen, "h", "a")))
+
+	assert.Equal(t, handler.NewIntReply(0), kvStore.HSetNX(newTestCmd(database.CmdTypeHSetNX, "h", "a", "x")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.HSetNX(newTestCmd(database.CmdTypeHSetNX, "n", "a", "x")))
+	assert.Equal(t, handler.NewBulkReply([]byte("x")), kvStore.HGet(newTestCmd(database.CmdTypeHGet, "n", "a")))
+
+	t.Run("rand", func(t *testing.T) {
+		reply := kvStore.HRandField(newTestCmd(database.CmdTypeHRandField, "h", "5", "withvalues")).(*handler.MultiBulkReply)
+		assert.Len(t, reply.Args(), 6)
+		for i := 0; i < len(reply.Args()); i += 2 {
+			assert.Equal(t, handler.NewBulkReply(reply.Args()[i+1]), kvStore.HGet(newTestCmd(database.CmdTypeHGet, "h", string(reply.Args()[i]))))
+		}
+		reply = kvStore.HRandField(newTestCmd(database.CmdTypeHRandField, "h", "-5")).(*handler.MultiBulkReply)
+		assert.Len(t, reply.Args(), 5)
+		assert.Equal(t, handler.NewNillReply(), kvStore.HRandField(newTestCmd(database.CmdTypeHRandField, "missing")))
+		assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.HRandField(newTestCmd(database.CmdTypeHRandField, "missing", "1")))
+		assert.True(t, handler.IsErrReply(kvStore.HRandField(newTestCmd(database.CmdTypeHRandField, "h", "1", "values"))))
+	})
+
+	// 删除全部字段后删除 key
+	assert.Equal(t, handler.NewIntReply(1), kvStore.HDel(newTestCmd(database.CmdTypeHDel, "n", "a")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "n")))
+}
+
+func Test_hashmap_incr(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	assert.Equal(t, handler.NewIntReply(5), kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "n", "5")))
+	assert.Equal(t, handler.NewIntReply(-2), kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "n", "-7")))
+	assert.Equal(t, handler.NewBulkReply([]byte("-1.5")), kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "h", "n", "0.5")))
+	assert.Equal(t, handler.NewBulkReply([]byte("3000")), kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "h", "f", "3.0e3")))
+
+	kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "max", strconv.FormatInt(math.MaxInt64, 10), "s", "foo"))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "max", "1"))))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "n", "1.5"))))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "s", "1"))))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "h", "s", "1"))))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "h", "f", "inf"))))
+	kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "big", "1.7e308"))
+	assert.True(t, handler.IsErrReply(kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "h", "big", "1.7e308"))))
+
+	// 自增失败时不会遗留空的 hash
+	assert.True(t, handler.IsErrReply(kvStore.HIncrByFloat(newTestCmd(database.CmdTypeHIncrByFloat, "e", "f", "nan"))))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "e")))
+
+}
+
+func Test_hashmap_field_expire(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", "1", "b", "2", "c", "3"))
+	codes := func(codes ...int64) handler.Reply {
+		res := make([]handler.Reply, 0, len(codes))
+		for _, code := range codes {
+			res = append(res, handler.NewIntReply(code))
+		}
+		return handler.NewArrayReply(res)
+	}
+
+	t.Run("condition", func(t *testing.T) {
+		assert.Equal(t, codes(1, -2), kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "100", "FIELDS", "2", "a", "nope")))
+		assert.Equal(t, codes(0, 1), kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "200", "NX", "FIELDS", "2", "a", "b")))
+		assert.Equal(t, codes(0, 0), kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "50", "GT", "FIELDS", "2", "a", "c")))
+		assert.Equal(t, codes(1, 1), kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "50", "LT", "FIELDS", "2", "a", "c")))
+		assert.Equal(t, codes(50, 200, -2), kvStore.HTTL(newTestCmd(database.CmdTypeHTTL, "h", "FIELDS", "3", "a", "b", "nope")))
+		assert.Equal(t, codes(-2), kvStore.HTTL(newTestCmd(database.CmdTypeHTTL, "nope", "FIELDS", "1", "a")))
+
+		assert.Equal(t, codes(1, -2), kvStore.HPersist(newTestCmd(database.CmdTypeHPersist, "h", "FIELDS", "2", "c", "nope")))
+		assert.Equal(t, codes(-1), kvStore.HPersist(newTestCmd(database.CmdTypeHPersist, "h", "FIELDS", "1", "c")))
+
+		assert.True(t, handler.IsErrReply(kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "100", "FIELDS", "2", "a"))))
+		assert.True(t, handler.IsErrReply(kvStore.HExpire(newTestCmd(database.CmdTypeHExpire, "h", "100", "XX", "GT", "FIELDS", "1", "a"))))
+		assert.True(t, handler.IsErrReply(kvStore.HTTL(newTestCmd(database.CmdTypeHTTL, "h", "FIELDS", "0"))))
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		// 覆盖写清除字段的过期时间，自增则保留
+		kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", "10"))
+		kvStore.HIncrBy(newTestCmd(database.CmdTypeHIncrBy, "h", "b", "1"))
+		assert.Equal(t, codes(-1, 200), kvStore.HTTL(newTestCmd(database.CmdTypeHTTL, "h", "FIELDS", "2", "a", "b")))
+	})
+
+	t.Run("to cmd", func(t *testing.T) {
+		kvStore.HPExpireAt(newTestCmd(database.CmdTypeHPExpireAt, "h", "9999999999999", "FIELDS", "2", "a", "b"))
+		cmds := kvStore.data["h"].(database.ExtraCmdAdapter).ExtraCmds()
+		assert.Equal(t, 1, len(cmds))
+		assert.Equal(t, "hpexpireat h 9999999999999 FIELDS 2", string(bytes.Join(cmds[0][:5], []byte(" "))))
+		assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("b")}, cmds[0][5:])
+
+		// 字段的过期时间随 key 迁移
+		kvStore.Copy(newTestCmd(database.CmdTypeCopy, "h", "h2"))
+		kvStore.Rename(newTestCmd(database.CmdTypeRename, "h2", "h3"))
+		assert.Equal(t, codes(9999999999999), kvStore.HPExpireTime(newTestCmd(database.CmdTypeHPExpireTime, "h3", "FIELDS", "1", "a")))
+		_, ok := kvStore.fieldExpiredAt["h2"]
+		assert.False(t, ok)
+		_, ok = kvStore.fieldExpiredAt["h3"]
+		assert.True(t, ok)
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		// 过期时间已到，直接删除字段
+		assert.Equal(t, codes(2), kvStore.HPExpireAt(newTestCmd(database.CmdTypeHPExpireAt, "h", "1", "FIELDS", "1", "c")))
+		assert.Equal(t, handler.NewIntReply(2), kvStore.HLen(newTestCmd(database.CmdTypeHLen, "h")))
+
+		// 访问时惰性删除
+		kvStore.HPExpire(newTestCmd(database.CmdTypeHPExpire, "h", "1", "FIELDS", "1", "a"))
+		time.Sleep(5 * time.Millisecond)
+		kvStore.ExpirePreprocess("h")
+		assert.Equal(t, handler.NewIntReply(1), kvStore.HLen(newTestCmd(database.CmdTypeHLen, "h")))
+
+		// 定期回收，字段被全部删除后删除 key
+		kvStore.HPExpire(newTestCmd(database.CmdTypeHPExpire, "h", "1", "FIELDS", "1", "b"))
+		time.Sleep(5 * time.Millisecond)
+		kvStore.GC()
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "h")))
+		_, ok := kvStore.fieldExpiredAt["h"]
+		assert.False(t, ok)
+	})
+}
+
+func Test_hashmap_field_expire_replay(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "n", "1", "f", "1.5", "keep", "x"))
+	kvStore.HPExpire(newTestCmd(database.CmdTypeHPExpire, "h", "20", "FIELDS", "2", "n", "f"))
+
+	// 自增改写为 hset + hpexpireat，保留字段的截止时间
+	incrBy := newTestCmd(database.CmdTypeHIncrBy, "h", "n", "2")
+	assert.Equal(t, handler.NewIntReply(3), kvStore.HIncrBy(incrBy))
+	incrByFloat := newTestCmd(database.CmdTypeHIncrByFloat, "h", "f", "1")
+	assert.Equal(t, handler.NewBulkReply([]byte("2.5")), kvStore.HIncrByFloat(incrByFloat))
+
+	var cmds [][][]byte
+	for _, cmd := range []*database.Command{incrBy, incrByFloat} {
+		persisted := cmd.PersistCmds()
+		assert.Equal(t, 2, len(persisted))
+		assert.Equal(t, database.CmdTypeHSet, database.CmdType(persisted[0][0]))
+		assert.Equal(t, database.CmdTypeHPExpireAt, database.CmdType(persisted[1][0]))
+		cmds = append(cmds, persisted...)
+	}
+	expiredAt := kvStore.HPExpireTime(newTestCmd(database.CmdTypeHPExpireTime, "h", "FIELDS", "2", "n", "f"))
+
+	// 截止时间之前回放，过期时间一致
+	replay := func() *KVStore {
+		replayed := NewKVStore(testThinker{}).(*KVStore)
+		replayed.HSet(newTestCmd(database.CmdTypeHSet, "h", "keep", "x"))
+		for _, cmd := range cmds {
+			args := make([]string, 0, len(cmd)-1)
+			for _, arg := range cmd[1:] {
+				args = append(args, string(arg))
+			}
+			if database.CmdType(cmd[0]) == database.CmdTypeHSet {
+				replayed.HSet(newTestCmd(database.CmdTypeHSet, args...))
+			} else {
+				replayed.HPExpireAt(newTestCmd(database.CmdTypeHPExpireAt, args...))
+			}
+		}
+		return replayed
+	}
+	replayed := replay()
+	assert.Equal(t, expiredAt, replayed.HPExpireTime(newTestCmd(database.CmdTypeHPExpireTime, "h", "FIELDS", "2", "n", "f")))
+	assert.Equal(t, handler.NewBulkReply([]byte("3")), replayed.HGet(newTestCmd(database.CmdTypeHGet, "h", "n")))
+
+	// 截止时间之后回放，字段被删除
+	time.Sleep(30 * time.Millisecond)
+	replayed = replay()
+	assert.Equal(t, handler.NewIntReply(1), replayed.HLen(newTestCmd(database.CmdTypeHLen, "h")))
+	assert.Equal(t, handler.NewIntReply(0), replayed.HExists(newTestCmd(database.CmdTypeHExists, "h", "n")))
+	assert.Equal(t, handler.NewIntReply(0), replayed.HExists(newTestCmd(database.CmdTypeHExists, "h", "f")))
+}
@@ -0,0 +1,492 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// hyperloglog 的实现与 redis 保持一致：使用 2^14 个 6 bit 的寄存器，哈希函数为 MurmurHash64A，
+// 序列化后的字节格式与 redis 相同，因此对外表现为 string 类型，可以通过 get/set 读写
+
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllPMask     = hllRegisters - 1
+	hllBits      = 6
+	hllRegMax    = 1<<hllBits - 1
+	hllAlphaInf  = 0.721347520444481703680
+
+	hllHeaderSize = 16
+	hllDenseSize  = (hllRegisters*hllBits + 7) / 8
+
+	hllEncodingDense  = 0
+	hllEncodingSparse = 1
+
+	// 稀疏编码中 VAL 指令能够表示的最大值
+	hllSparseValMax = 32
+	// 稀疏编码下非零寄存器个数的上限. 每个非零寄存器最多占用 3 个字节，
+	// 对应 redis 中 hll-sparse-max-bytes 的默认值 3000
+	hllSparseMaxRegs = 1000
+)
+
+var hllMagic = []byte("HYLL")
+
+func (k *KVStore) getAsHyperLogLog(key string) (*hyperLogLogEntity, error) {
+	str, err := k.getAsString(key)
+	if err != nil || str == nil {
+		return nil, err
+	}
+
+	if hll, ok := str.(*hyperLogLogEntity); ok {
+		return hll, nil
+	}
+
+	// 通过 set 指令写入的字符串，如 aof 加载时，需要还原为 hyperloglog
+	hll, err := parseHyperLogLog(key, str.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	k.data[key] = hll
+	return hll, nil
+}
+
+func (k *KVStore) PFAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	hll, err := k.getAsHyperLogLog(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var updated bool
+	if hll == nil {
+		hll = newHyperLogLogEntity(key)
+		k.data[key] = hll
+		updated = true
+	}
+
+	for _, arg := range args[1:] {
+		if hll.Add(arg) {
+			updated = true
+		}
+	}
+
+	if !updated {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(1)
+}
+
+func (k *KVStore) PFCount(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) == 1 {
+		hll, err := k.getAsHyperLogLog(string(args[0]))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if hll == nil {
+			return handler.NewIntReply(0)
+		}
+		return handler.NewIntReply(hll.Count())
+	}
+
+	// 多个 key 时，返回并集的基数
+	union := newHyperLogLogEntity("")
+	for _, arg := range args {
+		hll, err := k.getAsHyperLogLog(string(arg))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if hll != nil {
+			union.Merge(hll)
+		}
+	}
+	return handler.NewIntReply(union.Count())
+}
+
+func (k *KVStore) PFMerge(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	dest := string(args[0])
+
+	// 目标 key 已存在时，也作为合并的来源之一
+	srcs := make([]*hyperLogLogEntity, 0, len(args))
+	for _, arg := range args {
+		hll, err := k.getAsHyperLogLog(string(arg))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if hll != nil {
+			srcs = append(srcs, hll)
+		}
+	}
+
+	merged := newHyperLogLogEntity(dest)
+	for _, src := range srcs {
+		merged.Merge(src)
+	}
+
+	// 保留目标 key 原有的过期时间
+	k.data[dest] = merged
+	return handler.NewOKReply()
+}
+
+type hyperLogLogEntity struct {
+	key string
+	// 稀疏编码，仅记录非零的寄存器. 为 nil 时表示采用稠密编码
+	sparse map[uint16]uint8
+	// 稠密编码，与 redis 一致，每个寄存器占用 6 bit. 末尾额外多出 1 个字节，便于跨字节读写
+	dense []byte
+	// 缓存的基数，为 -1 时表示失效
+	card int64
+}
+
+func newHyperLogLogEntity(key string) *hyperLogLogEntity {
+	return &hyperLogLogEntity{
+		key:    key,
+		sparse: make(map[uint16]uint8),
+		card:   -1,
+	}
+}
+
+// 添加元素，返回是否有寄存器发生了变化
+func (h *hyperLogLogEntity) Add(element []byte) bool {
+	index, count := hllPatLen(element)
+	return h.set(index, count)
+}
+
+// 与 other 取各个寄存器的最大值
+func (h *hyperLogLogEntity) Merge(other *hyperLogLogEntity) {
+	if other.sparse != nil {
+		for index, count := range other.sparse {
+			h.set(index, count)
+		}
+		return
+	}
+
+	for i := 0; i < hllRegisters; i++ {
+		if count := denseRegister(other.dense, i); count > 0 {
+			h.set(uint16(i), count)
+		}
+	}
+}
+
+func (h *hyperLogLogEntity) Count() int64 {
+	if h.card >= 0 {
+		return h.card
+	}
+
+	// 寄存器的值可能来自于损坏的数据，因此按照 6 bit 的最大值分配
+	var histogram [hllRegMax + 1]int
+	if h.sparse != nil {
+		histogram[0] = hllRegisters - len(h.sparse)
+		for _, count := range h.sparse {
+			histogram[count]++
+		}
+	} else {
+		for i := 0; i < hllRegisters; i++ {
+			histogram[denseRegister(h.dense, i)]++
+		}
+	}
+
+	h.card = hllEstimate(histogram)
+	return h.card
+}
+
+func (h *hyperLogLogEntity) get(index uint16) uint8 {
+	if h.sparse != nil {
+		return h.sparse[index]
+	}
+	return denseRegister(h.dense, int(index))
+}
+
+// 寄存器只增不减，返回寄存器是否被更新
+func (h *hyperLogLogEntity) set(index uint16, count uint8) bool {
+	if count <= h.get(index) {
+		return false
+	}
+
+	h.card = -1
+	if h.sparse != nil {
+		if count <= hllSparseValMax && (len(h.sparse) < hllSparseMaxRegs || h.sparse[index] > 0) {
+			h.sparse[index] = count
+			return true
+		}
+		h.toDense()
+	}
+
+	setDenseRegister(h.dense, int(index), count)
+	return true
+}
+
+func (h *hyperLogLogEntity) toDense() {
+	h.dense = make([]byte, hllDenseSize+1)
+	for index, count := range h.sparse {
+		setDenseRegister(h.dense, int(index), count)
+	}
+	h.sparse = nil
+}
+
+// 序列化为 redis 格式的字节数组
+func (h *hyperLogLogEntity) Bytes() []byte {
+	header := make([]byte, hllHeaderSize)
+	copy(header, hllMagic)
+	if h.card >= 0 {
+		binary.LittleEndian.PutUint64(header[8:], uint64(h.card))
+	} else {
+		// 最高位为 1 表示缓存失效
+		header[15] = 1 << 7
+	}
+
+	if h.sparse == nil {
+		header[4] = hllEncodingDense
+		return append(header, h.dense[:hllDenseSize]...)
+	}
+
+	header[4] = hllEncodingSparse
+	return append(header, h.sparseBytes()...)
+}
+
+// 稀疏编码由三种指令组成:
+// ZERO: 00xxxxxx，表示连续 xxxxxx+1 个寄存器为 0
+// XZERO: 01xxxxxx yyyyyyyy，表示连续 xxxxxxyyyyyyyy+1 个寄存器为 0
+// VAL: 1vvvvvxx，表示连续 xx+1 个寄存器的值为 vvvvv+1
+func (h *hyperLogLogEntity) sparseBytes() []byte {
+	indexes := make([]int, 0, len(h.sparse))
+	for index := range h.sparse {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+
+	buf := make([]byte, 0, 3*len(indexes)+2)
+	appendZeros := func(n int) {
+		for n > 0 {
+			if n <= 64 {
+				buf = append(buf, byte(n-1))
+				return
+			}
+			run := n
+			if run > hllRegisters {
+				run = hllRegisters
+			}
+			buf = append(buf, 0x40|byte((run-1)>>8), byte(run-1))
+			n -= run
+		}
+	}
+
+	next := 0
+	for i := 0; i < len(indexes); {
+		appendZeros(indexes[i] - next)
+		value := h.sparse[uint16(indexes[i])]
+		run := 1
+		for i+run < len(indexes) && run < 4 &&
+			indexes[i+run] == indexes[i]+run && h.sparse[uint16(indexes[i+run])] == value {
+			run++
+		}
+		buf = append(buf, 0x80|byte(value-1)<<2|byte(run-1))
+		next = indexes[i] + run
+		i += run
+	}
+	appendZeros(hllRegisters - next)
+	return buf
+}
+
+func (h *hyperLogLogEntity) Len() int64 {
+	return int64(len(h.Bytes()))
+}
+
+func (h *hyperLogLogEntity) GetRange(start, end int64) []byte {
+	return getRange(h.Bytes(), start, end)
+}
+
+func (h *hyperLogLogEntity) Rename(key string) {
+	h.key = key
+}
+
+func (h *hyperLogLogEntity) Clone(key string) Entity {
+	hll := hyperLogLogEntity{key: key, card: h.card}
+	if h.sparse == nil {
+		hll.dense = append([]byte{}, h.dense...)
+		return &hll
+	}
+
+	hll.sparse = make(map[uint16]uint8, len(h.sparse))
+	for index, count := range h.sparse {
+		hll.sparse[index] = count
+	}
+	return &hll
+}
+
+// hyperloglog 以字符串的形式对外呈现
+func (h *hyperLogLogEntity) Encoding() string {
+	return "raw"
+}
+
+// 以 set 指令持久化 redis 格式的字节数组，能够精确还原各个寄存器
+func (h *hyperLogLogEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(database.CmdTypeSet), []byte(h.key), h.Bytes()}
+}
+
+// 解析 redis 格式的字节数组
+func parseHyperLogLog(key string, data []byte) (*hyperLogLogEntity, error) {
+	if len(data) < hllHeaderSize || string(data[:4]) != string(hllMagic) || data[4] > hllEncodingSparse {
+		return nil, handler.NewErrReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+
+	corrupted := handler.NewErrReply("INVALIDOBJ Corrupted HLL object detected")
+	hll := newHyperLogLogEntity(key)
+	if data[15]&(1<<7) == 0 {
+		hll.card = int64(binary.LittleEndian.Uint64(data[8:16]))
+	}
+
+	body := data[hllHeaderSize:]
+	if data[4] == hllEncodingDense {
+		if len(body) != hllDenseSize {
+			return nil, corrupted
+		}
+		hll.sparse = nil
+		hll.dense = make([]byte, hllDenseSize+1)
+		copy(hll.dense, body)
+		return hll, nil
+	}
+
+	var index int
+	for i := 0; i < len(body); i++ {
+		op := body[i]
+		switch {
+		case op&0xc0 == 0:
+			index += int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if i+1 >= len(body) {
+				return nil, corrupted
+			}
+			index += int(op&0x3f)<<8 | int(body[i+1]) + 1
+			i++
+		default:
+			value, run := (op>>2)&0x1f+1, int(op&0x03)+1
+			if index+run > hllRegisters {
+				return nil, corrupted
+			}
+			for j := 0; j < run; j++ {
+				hll.sparse[uint16(index+j)] = value
+			}
+			index += run
+		}
+		if index > hllRegisters {
+			return nil, corrupted
+		}
+	}
+	if index != hllRegisters {
+		return nil, corrupted
+	}
+
+	if len(hll.sparse) > hllSparseMaxRegs {
+		hll.toDense()
+	}
+	return hll, nil
+}
+
+func denseRegister(dense []byte, index int) uint8 {
+	pos := index * hllBits
+	b, fb := pos>>3, uint(pos&7)
+	return uint8((uint16(dense[b])>>fb | uint16(dense[b+1])<<(8-fb)) & hllRegMax)
+}
+
+func setDenseRegister(dense []byte, index int, count uint8) {
+	pos := index * hllBits
+	b, fb := pos>>3, uint(pos&7)
+	v := uint16(count) << fb
+	mask := uint16(hllRegMax) << fb
+	dense[b] = dense[b]&^byte(mask) | byte(v)
+	dense[b+1] = dense[b+1]&^byte(mask>>8) | byte(v>>8)
+}
+
+// 返回元素对应的寄存器下标，以及哈希值中除去下标部分后，从低位开始首个 1 出现的位置
+func hllPatLen(element []byte) (uint16, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := uint16(hash & hllPMask)
+	hash >>= hllP
+	// 保证循环能够终止，count 最大为 hllQ+1
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// 基于寄存器值的分布估算基数，采用 Otmar Ertl 提出的改进算法，与 redis 一致
+func hllEstimate(histogram [hllRegMax + 1]int) int64 {
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func Test_hyperloglog_count(t *testing.T) {
+	hll := newHyperLogLogEntity("")
+	for i := 0; i < 100000; i++ {
+		hll.Add([]byte(strconv.Itoa(i)))
+		if i == 100 {
+			assert.NotNil(t, hll.sparse)
+		}
+	}
+	assert.Nil(t, hll.sparse)
+
+	// 标准误差约为 0.81%
+	assert.InDelta(t, 100000, hll.Count(), 100000*0.03)
+}
+
+func Test_hyperloglog_encoding(t *testing.T) {
+	for _, n := range []int{0, 5, 500, 50000} {
+		hll := newHyperLogLogEntity("")
+		for i := 0; i < n; i++ {
+			hll.Add([]byte("elem" + strconv.Itoa(i)))
+		}
+
+		data := hll.Bytes()
+		parsed, err := parseHyperLogLog("", data)
+		assert.NoError(t, err)
+		for i := 0; i < hllRegisters; i++ {
+			assert.Equal(t, hll.get(uint16(i)), parsed.get(uint16(i)))
+		}
+		assert.Equal(t, data, parsed.Bytes())
+		assert.Equal(t, hll.Count(), parsed.Count())
+	}
+
+	_, err := parseHyperLogLog("", []byte("foo"))
+	assert.Error(t, err)
+	_, err = parseHyperLogLog("", []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f"))
+	assert.Error(t, err)
+}
+
+func Test_hyperloglog_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+
+	assert.Equal(t, handler.NewIntReply(1), kvStore.PFAdd(newTestCmd(database.CmdTypePFAdd, "a", "1", "2", "3")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.PFAdd(newTestCmd(database.CmdTypePFAdd, "a", "1")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.PFAdd(newTestCmd(database.CmdTypePFAdd, "b", "3", "4")))
+	assert.Equal(t, handler.NewIntReply(3), kvStore.PFCount(newTestCmd(database.CmdTypePFCount, "a")))
+	assert.Equal(t, handler.NewIntReply(4), kvStore.PFCount(newTestCmd(database.CmdTypePFCount, "a", "b", "c")))
+
+	assert.Equal(t, handler.NewOKReply(), kvStore.PFMerge(newTestCmd(database.CmdTypePFMerge, "c", "a", "b")))
+	assert.Equal(t, handler.NewIntReply(4), kvStore.PFCount(newTestCmd(database.CmdTypePFCount, "c")))
+
+	// 通过 set 写入的 hyperloglog 能够被还原
+	hll, _ := kvStore.getAsHyperLogLog("c")
+	cmdLine := hll.ToCmd()
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "d", string(cmdLine[2])))
+	assert.Equal(t, handler.NewIntReply(4), kvStore.PFCount(newTestCmd(database.CmdTypePFCount, "d")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.PFAdd(newTestCmd(database.CmdTypePFAdd, "d", "4")))
+
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "s", "foo"))
+	assert.True(t, handler.IsErrReply(kvStore.PFAdd(newTestCmd(database.CmdTypePFAdd, "s", "1"))))
+	assert.True(t, handler.IsErrReply(kvStore.PFCount(newTestCmd(database.CmdTypePFCount, "a", "s"))))
+}
+
+func Test_hyperloglog_estimate(t *testing.T) {
+	var histogram [hllRegMax + 1]int
+	histogram[0] = hllRegisters
+	assert.Equal(t, int64(0), hllEstimate(histogram))
+
+	histogram[0], histogram[1] = hllRegisters-1, 1
+	assert.Equal(t, int64(1), hllEstimate(histogram))
+}
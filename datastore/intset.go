@@ -0,0 +1,120 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// 整数集合. 元素有序存放在连续的内存中，按照元素的取值范围统一采用 2/4/8 字节的定长编码，
+// 写入超出当前编码范围的元素时整体升级编码
+type intset struct {
+	width    int
+	contents []byte
+}
+
+func newIntset() *intset {
+	return &intset{width: 2}
+}
+
+// 解析可以存入 intset 的整数. 要求字符串与整数的十进制表示完全一致，保证还原后的元素不变
+func parseIntsetValue(value string) (int64, bool) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != value {
+		return 0, false
+	}
+	return v, true
+}
+
+func intsetWidth(v int64) int {
+	if v >= math.MinInt16 && v <= math.MaxInt16 {
+		return 2
+	}
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		return 4
+	}
+	return 8
+}
+
+func (s *intset) Len() int {
+	return len(s.contents) / s.width
+}
+
+func (s *intset) Get(i int) int64 {
+	return s.get(s.contents, s.width, i)
+}
+
+func (s *intset) get(contents []byte, width, i int) int64 {
+	switch width {
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(contents[i*2:])))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(contents[i*4:])))
+	default:
+		return int64(binary.LittleEndian.Uint64(contents[i*8:]))
+	}
+}
+
+func (s *intset) set(i int, v int64) {
+	switch s.width {
+	case 2:
+		binary.LittleEndian.PutUint16(s.contents[i*2:], uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(s.contents[i*4:], uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(s.contents[i*8:], uint64(v))
+	}
+}
+
+// 二分查找，返回元素的位置或者应当插入的位置
+func (s *intset) search(v int64) (int, bool) {
+	i := sort.Search(s.Len(), func(i int) bool {
+		return s.Get(i) >= v
+	})
+	return i, i < s.Len() && s.Get(i) == v
+}
+
+func (s *intset) Contains(v int64) bool {
+	_, ok := s.search(v)
+	return ok
+}
+
+func (s *intset) Add(v int64) bool {
+	if width := intsetWidth(v); width > s.width {
+		s.upgrade(width)
+	}
+
+	i, ok := s.search(v)
+	if ok {
+		return false
+	}
+
+	s.contents = append(s.contents, make([]byte, s.width)...)
+	copy(s.contents[(i+1)*s.width:], s.contents[i*s.width:])
+	s.set(i, v)
+	return true
+}
+
+func (s *intset) Remove(v int64) bool {
+	i, ok := s.search(v)
+	if !ok {
+		return false
+	}
+
+	s.contents = append(s.contents[:i*s.width], s.contents[(i+1)*s.width:]...)
+	return true
+}
+
+func (s *intset) upgrade(width int) {
+	contents, oldWidth := s.contents, s.width
+	s.width = width
+	s.contents = make([]byte, len(contents)/oldWidth*width)
+	for i := 0; i < len(contents)/oldWidth; i++ {
+		s.set(i, s.get(contents, oldWidth, i))
+	}
+}
+
+func (s *intset) Clone() *intset {
+	return &intset{width: s.width, contents: append([]byte{}, s.contents...)}
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/lib"
+)
+
+func Test_intset_crud(t *testing.T) {
+	set := newIntset()
+	s := make(map[int64]struct{})
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	randValue := func() int64 {
+		// 覆盖各个编码宽度
+		switch rander.Intn(3) {
+		case 0:
+			return rander.Int63n(200) - 100
+		case 1:
+			return rander.Int63n(math.MaxInt32)
+		default:
+			return rander.Int63() - math.MaxInt64/2
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		v := randValue()
+		_, ok := s[v]
+		assert.Equal(t, !ok, set.Add(v))
+		s[v] = struct{}{}
+
+		v = randValue()
+		_, ok = s[v]
+		assert.Equal(t, ok, set.Remove(v))
+		delete(s, v)
+	}
+
+	expect := make([]int64, 0, len(s))
+	for v := range s {
+		expect = append(expect, v)
+	}
+	sort.Slice(expect, func(i, j int) bool {
+		return expect[i] < expect[j]
+	})
+
+	actual := make([]int64, 0, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		actual = append(actual, set.Get(i))
+		assert.True(t, set.Contains(set.Get(i)))
+	}
+	assert.Equal(t, expect, actual)
+}
+
+func Test_intset_upgrade(t *testing.T) {
+	set := newIntset()
+	set.Add(1)
+	set.Add(-1)
+	assert.Equal(t, 2, set.width)
+
+	set.Add(math.MaxInt16 + 1)
+	assert.Equal(t, 4, set.width)
+	set.Add(math.MinInt64)
+	assert.Equal(t, 8, set.width)
+	assert.Equal(t, []int64{math.MinInt64, -1, 1, math.MaxInt16 + 1},
+		[]int64{set.Get(0), set.Get(1), set.Get(2), set.Get(3)})
+
+	for _, value := range []string{"01", "+1", "-0", "1.0", " 1", "9223372036854775808"} {
+		_, ok := parseIntsetValue(value)
+		assert.False(t, ok, value)
+	}
+}
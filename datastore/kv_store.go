@@ -1,7 +1,8 @@
 package datastore
 
 import (
-	"context"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -17,516 +18,2561 @@ type KVStore struct {
 
 	expireTimeWheel SortedSet
 
-	persister handler.Persister
+	// 带有字段过期时间的 hash，记录其中最早的字段过期时间
+	fieldExpiredAt       map[string]time.Time
+	fieldExpireTimeWheel SortedSet
+
+	thresholds *encodingThresholds
+}
+
+// 紧凑编码的转换阈值
+type Thinker interface {
+	SetMaxIntsetEntries() int
+	HashMaxListpackEntries() int
+	HashMaxListpackValue() int
+	ZSetMaxListpackEntries() int
+	ZSetMaxListpackValue() int
+}
+
+// 元素数量或者长度超过阈值时，紧凑编码的实体转为常规编码
+type encodingThresholds struct {
+	setMaxIntsetEntries    int
+	hashMaxListpackEntries int
+	hashMaxListpackValue   int
+	zsetMaxListpackEntries int
+	zsetMaxListpackValue   int
+}
+
+// 各类数据实体的通用能力
+type Entity interface {
+	// 变更实体所属的 key
+	Rename(key string)
+	// 以 key 为新的归属，深拷贝出一份实体
+	Clone(key string) Entity
+	// 实体的底层编码
+	Encoding() string
+	database.CmdAdapter
 }
 
-func NewKVStore(persister handler.Persister) database.DataStore {
+func NewKVStore(thinker Thinker) database.DataStore {
 	return &KVStore{
 		data:            make(map[string]interface{}),
 		expiredAt:       make(map[string]time.Time),
 		expireTimeWheel: newSkiplist("expireTimeWheel"),
-		persister:       persister,
+
+		fieldExpiredAt:       make(map[string]time.Time),
+		fieldExpireTimeWheel: newSkiplist("fieldExpireTimeWheel"),
+		thresholds: &encodingThresholds{
+			setMaxIntsetEntries:    thinker.SetMaxIntsetEntries(),
+			hashMaxListpackEntries: thinker.HashMaxListpackEntries(),
+			hashMaxListpackValue:   thinker.HashMaxListpackValue(),
+			zsetMaxListpackEntries: thinker.ZSetMaxListpackEntries(),
+			zsetMaxListpackValue:   thinker.ZSetMaxListpackValue(),
+		},
 	}
 }
 
-// expire
-func (k *KVStore) Expire(cmd *database.Command) handler.Reply {
+// server
+func (k *KVStore) DBSize(cmd *database.Command) handler.Reply {
+	return handler.NewIntReply(int64(len(k.data)))
+}
+
+func (k *KVStore) FlushAll(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	key := string(args[0])
-	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
+	if len(args) > 1 {
 		return handler.NewSyntaxErrReply()
 	}
-	if ttl <= 0 {
-		return handler.NewErrReply("ERR invalid expire time")
+	if len(args) == 1 {
+		if mode := strings.ToLower(string(args[0])); mode != "sync" && mode != "async" {
+			return handler.NewSyntaxErrReply()
+		}
 	}
 
-	expireAt := lib.TimeNow().Add(time.Duration(ttl) * time.Second)
-	_cmd := [][]byte{[]byte(database.CmdTypeExpireAt), []byte(key), []byte(lib.TimeSecondFormat(expireAt))}
-	return k.expireAt(cmd.Ctx(), _cmd, key, expireAt)
+	k.data = make(map[string]interface{})
+	k.expiredAt = make(map[string]time.Time)
+	k.expireTimeWheel = newSkiplist("expireTimeWheel")
+	k.fieldExpiredAt = make(map[string]time.Time)
+	k.fieldExpireTimeWheel = newSkiplist("fieldExpireTimeWheel")
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeFlushAll)})
+	return handler.NewOKReply()
 }
 
-func (k *KVStore) ExpireAt(cmd *database.Command) handler.Reply {
-	args := cmd.Args()
-	key := string(args[0])
-	expiredAt, err := lib.ParseTimeSecondFormat(string((args[1])))
-	if err != nil {
-		return handler.NewSyntaxErrReply()
+// keyspace
+func (k *KVStore) Del(cmd *database.Command) handler.Reply {
+	var deleted int64
+	for _, arg := range cmd.Args() {
+		deleted += k.del(string(arg))
 	}
-	if expiredAt.Before(lib.TimeNow()) {
-		return handler.NewErrReply("ERR invalid expire time")
+
+	if deleted == 0 {
+		cmd.Unchanged()
 	}
+	return handler.NewIntReply(deleted)
+}
 
-	return k.expireAt(cmd.Ctx(), cmd.Cmd(), key, expiredAt)
+func (k *KVStore) Exists(cmd *database.Command) handler.Reply {
+	var existed int64
+	for _, arg := range cmd.Args() {
+		if _, ok := k.data[string(arg)]; ok {
+			existed++
+		}
+	}
+	return handler.NewIntReply(existed)
 }
 
-func (k *KVStore) expireAt(ctx context.Context, cmd [][]byte, key string, expireAt time.Time) handler.Reply {
-	k.expire(key, expireAt)
-	k.persister.PersistCmd(ctx, cmd) // 持久化
-	return handler.NewOKReply()
+func (k *KVStore) Touch(cmd *database.Command) handler.Reply {
+	// 不维护 key 的访问时间，与 exists 的区别在于重复的 key 只计数一次
+	touched := make(map[string]struct{}, len(cmd.Args()))
+	for _, arg := range cmd.Args() {
+		if _, ok := k.data[string(arg)]; ok {
+			touched[string(arg)] = struct{}{}
+		}
+	}
+	return handler.NewIntReply(int64(len(touched)))
 }
 
-// string
-func (k *KVStore) Get(cmd *database.Command) handler.Reply {
+func (k *KVStore) Type(cmd *database.Command) handler.Reply {
+	v, ok := k.data[string(cmd.Args()[0])]
+	if !ok {
+		return handler.NewSimpleStringReply("none")
+	}
+	return handler.NewSimpleStringReply(typeOf(v))
+}
+
+// 仅支持 object encoding 子指令
+func (k *KVStore) Object(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	key := string(args[0])
-	v, err := k.getAsString(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
+	if sub := strings.ToLower(string(args[0])); sub != "encoding" {
+		return handler.NewErrReply(fmt.Sprintf("ERR unknown subcommand '%s'", args[0]))
 	}
-	if v == nil {
+	if len(args) != 2 {
+		return handler.NewErrReply("ERR wrong number of arguments for 'object|encoding' command")
+	}
+
+	v, ok := k.data[string(args[1])]
+	if !ok {
 		return handler.NewNillReply()
 	}
-	return handler.NewBulkReply(v.Bytes())
+	return handler.NewBulkReply([]byte(v.(Entity).Encoding()))
 }
 
-func (k *KVStore) MGet(cmd *database.Command) handler.Reply {
+func (k *KVStore) Rename(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	res := make([][]byte, 0, len(args))
-	for _, arg := range args {
-		v, err := k.getAsString(string(arg))
-		if err != nil {
-			return handler.NewErrReply(err.Error())
-		}
-		if v == nil {
-			res = append(res, []byte("(nil)"))
-			continue
-		}
-		res = append(res, v.Bytes())
+	src, dst := string(args[0]), string(args[1])
+	if _, ok := k.data[src]; !ok {
+		return handler.NewErrReply("ERR no such key")
 	}
 
-	return handler.NewMultiBulkReply(res)
+	k.rename(src, dst)
+	return handler.NewOKReply()
 }
 
-func (k *KVStore) Set(cmd *database.Command) handler.Reply {
+func (k *KVStore) RenameNX(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	key := string(args[0])
-	value := string(args[1])
+	src, dst := string(args[0]), string(args[1])
+	if _, ok := k.data[src]; !ok {
+		return handler.NewErrReply("ERR no such key")
+	}
 
-	// 支持 NX EX
-	var (
-		insertStrategy bool
-		ttlStrategy    bool
-		ttlSeconds     int64
-		ttlIndex       = -1
-	)
+	if _, ok := k.data[dst]; ok {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	k.rename(src, dst)
+	return handler.NewIntReply(1)
+}
+
+func (k *KVStore) Copy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	src, dst := string(args[0]), string(args[1])
 
+	// 支持 DB REPLACE. 只有 0 号 db
+	var replace bool
 	for i := 2; i < len(args); i++ {
-		flag := strings.ToLower(string(args[i]))
-		switch flag {
-		case "nx":
-			insertStrategy = true
-		case "ex":
-			// 重复的 ex 指令
-			if ttlStrategy {
-				return handler.NewSyntaxErrReply()
-			}
+		switch strings.ToLower(string(args[i])) {
+		case "replace":
+			replace = true
+		case "db":
 			if i == len(args)-1 {
 				return handler.NewSyntaxErrReply()
 			}
-			ttl, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			db, err := strconv.ParseInt(string(args[i+1]), 10, 64)
 			if err != nil {
-				return handler.NewSyntaxErrReply()
+				return handler.NewErrReply("ERR value is not an integer or out of range")
 			}
-			if ttl <= 0 {
-				return handler.NewErrReply("ERR invalid expire time")
+			if db != 0 {
+				return handler.NewErrReply("ERR DB index is out of range")
 			}
-
-			ttlStrategy = true
-			ttlSeconds = ttl
-			ttlIndex = i
 			i++
 		default:
 			return handler.NewSyntaxErrReply()
 		}
 	}
 
-	// 将 args 剔除 ex 部分，进行持久化
-	if ttlIndex != -1 {
-		args = append(args[:ttlIndex], args[ttlIndex+2:]...)
+	if src == dst {
+		return handler.NewErrReply("ERR source and destination objects are the same")
 	}
 
-	// 设置
-	affected := k.put(key, value, insertStrategy)
-	if affected > 0 && ttlStrategy {
-		expireAt := lib.TimeNow().Add(time.Duration(ttlSeconds) * time.Second)
-		_cmd := [][]byte{[]byte(database.CmdTypeExpireAt), []byte(key), []byte(lib.TimeSecondFormat(expireAt))}
-		_ = k.expireAt(cmd.Ctx(), _cmd, key, expireAt) // 其中会完成 ex 信息的持久化
+	v, ok := k.data[src]
+	if !ok {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	// 过期时间处理
-	if affected > 0 {
-		k.persister.PersistCmd(cmd.Ctx(), append([][]byte{[]byte(database.CmdTypeSet)}, args...))
-		return handler.NewIntReply(affected)
+	if _, ok := k.data[dst]; ok && !replace {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	return handler.NewNillReply()
+	k.del(dst)
+	k.data[dst] = v.(Entity).Clone(dst)
+	if expiredAt, ok := k.expiredAt[src]; ok {
+		k.expire(dst, expiredAt)
+	}
+	if hmap, ok := k.data[dst].(HashMap); ok {
+		k.scheduleFieldExpire(dst, hmap)
+	}
+	return handler.NewIntReply(1)
 }
 
-func (k *KVStore) MSet(cmd *database.Command) handler.Reply {
-	args := cmd.Args()
-	if len(args)&1 == 1 {
-		return handler.NewSyntaxErrReply()
-	}
+// expire
+func (k *KVStore) Expire(cmd *database.Command) handler.Reply {
+	return k.expireGeneric(cmd, time.Second, false)
+}
 
-	for i := 0; i < len(args); i += 2 {
-		_ = k.put(string(args[i]), string(args[i+1]), false)
+func (k *KVStore) PExpire(cmd *database.Command) handler.Reply {
+	return k.expireGeneric(cmd, time.Millisecond, false)
+}
+
+func (k *KVStore) ExpireAt(cmd *database.Command) handler.Reply {
+	return k.expireGeneric(cmd, time.Second, true)
+}
+
+func (k *KVStore) PExpireAt(cmd *database.Command) handler.Reply {
+	return k.expireGeneric(cmd, time.Millisecond, true)
+}
+
+func (k *KVStore) TTL(cmd *database.Command) handler.Reply {
+	return k.ttl(cmd, time.Second, false)
+}
+
+func (k *KVStore) PTTL(cmd *database.Command) handler.Reply {
+	return k.ttl(cmd, time.Millisecond, false)
+}
+
+func (k *KVStore) ExpireTime(cmd *database.Command) handler.Reply {
+	return k.ttl(cmd, time.Second, true)
+}
+
+func (k *KVStore) PExpireTime(cmd *database.Command) handler.Reply {
+	return k.ttl(cmd, time.Millisecond, true)
+}
+
+func (k *KVStore) Persist(cmd *database.Command) handler.Reply {
+	key := string(cmd.Args()[0])
+	if _, ok := k.expiredAt[key]; !ok {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd())
-	return handler.NewIntReply(int64(len(args) >> 1))
+	k.cancelExpire(key)
+	return handler.NewIntReply(1)
 }
 
-// list
-func (k *KVStore) LPush(cmd *database.Command) handler.Reply {
+// unit 为时间单位，absolute 标识参数是否为 unix 时间戳
+func (k *KVStore) expireGeneric(cmd *database.Command, unit time.Duration, absolute bool) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	list, err := k.getAsList(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
+	expiredAt, errReply := parseExpireTime(cmd, args[1], unit, absolute)
+	if errReply != nil {
+		return errReply
 	}
 
-	if list == nil {
-		list = newListEntity(key)
-		k.putAsList(key, list)
+	cond, errReply := parseExpireCondition(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	if _, ok := k.data[key]; !ok {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	for i := 1; i < len(args); i++ {
-		list.LPush(args[i])
+	current, volatile := k.expiredAt[key]
+	if !cond.satisfied(current, volatile, expiredAt) {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd())
-	return handler.NewIntReply(list.Len())
+	// 过期时间已到，直接删除
+	if !expiredAt.After(lib.TimeNow()) {
+		k.del(key)
+		cmd.Rewrite([][]byte{[]byte(database.CmdTypeDel), []byte(key)})
+		return handler.NewIntReply(1)
+	}
+
+	// 统一以毫秒级的绝对时间进行持久化
+	k.expire(key, expiredAt)
+	cmd.Rewrite(pexpireAtCmd(key, expiredAt))
+	return handler.NewIntReply(1)
 }
 
-func (k *KVStore) LPop(cmd *database.Command) handler.Reply {
-	args := cmd.Args()
-	key := string(args[0])
-	var cnt int64
-	if len(args) > 1 {
-		rawCnt, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil {
-			return handler.NewSyntaxErrReply()
-		}
-		if rawCnt < 1 {
-			return handler.NewSyntaxErrReply()
-		}
-		cnt = rawCnt
+func (k *KVStore) ttl(cmd *database.Command, unit time.Duration, absolute bool) handler.Reply {
+	key := string(cmd.Args()[0])
+	if _, ok := k.data[key]; !ok {
+		return handler.NewIntReply(-2)
 	}
 
-	list, err := k.getAsList(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
+	expiredAt, ok := k.expiredAt[key]
+	if !ok {
+		return handler.NewIntReply(-1)
 	}
+	return handler.NewIntReply(ttlOf(expiredAt, unit, absolute))
+}
 
-	if list == nil {
-		return handler.NewNillReply()
+func (k *KVStore) del(key string) int64 {
+	if _, ok := k.data[key]; !ok {
+		return 0
 	}
+	k.expireProcess(key)
+	return 1
+}
 
-	if cnt == 0 {
-		cnt = 1
+// 将 src 的数据连同过期时间一并迁移到 dst 下，dst 原有的数据会被覆盖
+func (k *KVStore) rename(src, dst string) {
+	if src == dst {
+		return
 	}
 
-	poped := list.LPop(cnt)
-	if poped == nil {
-		return handler.NewNillReply()
+	entity := k.data[src].(Entity)
+	expiredAt, ok := k.expiredAt[src]
+	k.del(src)
+	k.del(dst)
+
+	entity.Rename(dst)
+	k.data[dst] = entity
+	if ok {
+		k.expire(dst, expiredAt)
 	}
+	if hmap, ok := entity.(HashMap); ok {
+		k.scheduleFieldExpire(dst, hmap)
+	}
+}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+// 元素被全部移除后，删除 list
+func (k *KVStore) delIfEmptyList(key string, list List) {
+	if list.Len() == 0 {
+		k.del(key)
+	}
+}
 
-	if len(poped) == 1 {
-		return handler.NewBulkReply(poped[0])
+func parseInt(arg []byte) (int64, handler.Reply) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, handler.NewErrReply("ERR value is not an integer or out of range")
 	}
+	return v, nil
+}
 
-	return handler.NewMultiBulkReply(poped)
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case String:
+		return "string"
+	case List:
+		return "list"
+	case Set:
+		return "set"
+	case HashMap:
+		return "hash"
+	case SortedSet:
+		return "zset"
+	case Stream:
+		return "stream"
+	default:
+		return "none"
+	}
 }
 
-func (k *KVStore) RPush(cmd *database.Command) handler.Reply {
+// string
+func (k *KVStore) Get(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	list, err := k.getAsList(key)
+	v, err := k.getAsString(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
-
-	if list == nil {
-		list = newListEntity(key, args[1:]...)
-		k.putAsList(key, list)
-		return handler.NewIntReply(list.Len())
+	if v == nil {
+		return handler.NewNillReply()
 	}
+	return handler.NewBulkReply(copyBytes(v.Bytes()))
+}
 
-	for i := 1; i < len(args); i++ {
-		list.RPush(args[i])
+func (k *KVStore) MGet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	res := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		// 不存在或者类型不为 string 的 key，均返回 nil
+		v, err := k.getAsString(string(arg))
+		if err != nil || v == nil {
+			res = append(res, nil)
+			continue
+		}
+		res = append(res, copyBytes(v.Bytes()))
 	}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	return handler.NewIntReply(list.Len())
+	return handler.NewMultiBulkReply(res)
 }
 
-func (k *KVStore) RPop(cmd *database.Command) handler.Reply {
+func (k *KVStore) Set(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	var cnt int64
-	if len(args) > 1 {
-		rawCnt, err := strconv.ParseInt(string(args[1]), 10, 64)
+	value := args[1]
+
+	// 支持 EX PX EXAT PXAT NX XX GET KEEPTTL
+	opts, errReply := parseSetOptions(cmd, args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	var oldValue []byte
+	if opts.get {
+		old, err := k.getAsString(key)
 		if err != nil {
-			return handler.NewSyntaxErrReply()
+			return handler.NewErrReply(err.Error())
 		}
-		if rawCnt < 1 {
-			return handler.NewSyntaxErrReply()
+		if old != nil {
+			oldValue = copyBytes(old.Bytes())
 		}
-		cnt = rawCnt
 	}
 
-	list, err := k.getAsList(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
+	reply := func() handler.Reply {
+		if !opts.get {
+			return handler.NewOKReply()
+		}
+		if oldValue == nil {
+			return handler.NewNillReply()
+		}
+		return handler.NewBulkReply(oldValue)
 	}
 
-	if list == nil {
+	_, exist := k.data[key]
+	if (opts.nx && exist) || (opts.xx && !exist) {
+		cmd.Unchanged()
+		if opts.get {
+			return reply()
+		}
 		return handler.NewNillReply()
 	}
 
-	if cnt == 0 {
-		cnt = 1
-	}
-
-	poped := list.RPop(cnt)
-	if poped == nil {
-		return handler.NewNillReply()
-	}
+	k.put(key, value, opts.keepTTL)
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	if len(poped) == 1 {
-		return handler.NewBulkReply(poped[0])
+	// 条件选项已经生效，过期时间统一转为毫秒级时间戳，保证持久化内容的确定性
+	persistCmd := [][]byte{[]byte(database.CmdTypeSet), []byte(key), value}
+	switch {
+	case opts.expire:
+		if !opts.expiredAt.After(lib.TimeNow()) {
+			k.del(key)
+			cmd.Rewrite([][]byte{[]byte(database.CmdTypeDel), []byte(key)})
+			return reply()
+		}
+		k.expire(key, opts.expiredAt)
+		persistCmd = append(persistCmd, []byte("pxat"), []byte(strconv.FormatInt(opts.expiredAt.UnixMilli(), 10)))
+	case opts.keepTTL:
+		persistCmd = append(persistCmd, []byte("keepttl"))
 	}
 
-	return handler.NewMultiBulkReply(poped)
+	cmd.Rewrite(persistCmd)
+	return reply()
 }
 
-func (k *KVStore) LRange(cmd *database.Command) handler.Reply {
+func (k *KVStore) MSet(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	if len(args) != 3 {
+	if len(args)&1 == 1 {
 		return handler.NewSyntaxErrReply()
 	}
 
-	key := string(args[0])
-	start, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return handler.NewSyntaxErrReply()
+	for i := 0; i < len(args); i += 2 {
+		k.put(string(args[i]), args[i+1], false)
 	}
 
-	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
+	return handler.NewOKReply()
+}
+
+func (k *KVStore) MSetNX(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args)&1 == 1 {
 		return handler.NewSyntaxErrReply()
 	}
 
-	list, err := k.getAsList(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
+	// 只要有一个 key 已存在，就全部不执行
+	for i := 0; i < len(args); i += 2 {
+		if _, ok := k.data[string(args[i])]; ok {
+			cmd.Unchanged()
+			return handler.NewIntReply(0)
+		}
 	}
 
-	if list == nil {
-		return handler.NewNillReply()
+	for i := 0; i < len(args); i += 2 {
+		k.put(string(args[i]), args[i+1], false)
 	}
+	return handler.NewIntReply(1)
+}
 
-	if got := list.Range(start, stop); got != nil {
-		return handler.NewMultiBulkReply(got)
+func (k *KVStore) SetNX(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	if _, ok := k.data[key]; ok {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
 	}
 
-	return handler.NewNillReply()
+	k.put(key, args[1], false)
+	return handler.NewIntReply(1)
 }
 
-// set
-func (k *KVStore) SAdd(cmd *database.Command) handler.Reply {
+func (k *KVStore) GetSet(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	set, err := k.getAsSet(key)
+	old, err := k.getAsString(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if set == nil {
-		set = newSetEntity(key)
-		k.putAsSet(key, set)
+	var oldValue []byte
+	if old != nil {
+		oldValue = copyBytes(old.Bytes())
 	}
 
-	var added int64
-	for _, arg := range args[1:] {
-		added += set.Add(string(arg))
+	k.put(key, args[1], false)
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeSet), []byte(key), args[1]})
+	if oldValue == nil {
+		return handler.NewNillReply()
 	}
+	return handler.NewBulkReply(oldValue)
+}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	return handler.NewIntReply(added)
+func (k *KVStore) GetDel(cmd *database.Command) handler.Reply {
+	key := string(cmd.Args()[0])
+	str, err := k.getAsString(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if str == nil {
+		cmd.Unchanged()
+		return handler.NewNillReply()
+	}
+
+	k.del(key)
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeDel), []byte(key)})
+	return handler.NewBulkReply(copyBytes(str.Bytes()))
 }
 
-func (k *KVStore) SIsMember(cmd *database.Command) handler.Reply {
+func (k *KVStore) GetEx(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	if len(args) != 2 {
-		return handler.NewSyntaxErrReply()
+	key := string(args[0])
+
+	// 支持 EX PX EXAT PXAT PERSIST
+	opts, errReply := parseGetExOptions(cmd, args[1:])
+	if errReply != nil {
+		return errReply
 	}
 
-	key := string(args[0])
-	set, err := k.getAsSet(key)
+	str, err := k.getAsString(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if set == nil {
-		return handler.NewIntReply(0)
+	if str == nil {
+		cmd.Unchanged()
+		return handler.NewNillReply()
 	}
 
-	return handler.NewIntReply(set.Exist(string(args[1])))
+	reply := handler.NewBulkReply(copyBytes(str.Bytes()))
+	switch {
+	case opts.expire:
+		if !opts.expiredAt.After(lib.TimeNow()) {
+			k.del(key)
+			cmd.Rewrite([][]byte{[]byte(database.CmdTypeDel), []byte(key)})
+			return reply
+		}
+		k.expire(key, opts.expiredAt)
+		cmd.Rewrite(pexpireAtCmd(key, opts.expiredAt))
+	case opts.persist:
+		if _, ok := k.expiredAt[key]; !ok {
+			cmd.Unchanged()
+			return reply
+		}
+		k.cancelExpire(key)
+		cmd.Rewrite([][]byte{[]byte(database.CmdTypePersist), []byte(key)})
+	default:
+		cmd.Unchanged()
+	}
+
+	return reply
 }
 
-func (k *KVStore) SRem(cmd *database.Command) handler.Reply {
+func (k *KVStore) Append(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	set, err := k.getAsSet(key)
+	str, err := k.getAsRawString(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if set == nil {
-		return handler.NewIntReply(0)
+	if str == nil {
+		k.put(key, args[1], false)
+		return handler.NewIntReply(int64(len(args[1])))
 	}
 
-	var remed int64
-	for _, arg := range args[1:] {
-		remed += set.Rem(string(arg))
+	if str.Len()+int64(len(args[1])) > maxStringLen {
+		return handler.NewErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
 	}
+	return handler.NewIntReply(str.Append(args[1]))
+}
 
-	if remed > 0 {
-		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+func (k *KVStore) StrLen(cmd *database.Command) handler.Reply {
+	str, err := k.getAsString(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if str == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(str.Len())
+}
+
+func (k *KVStore) GetRange(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	end, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+
+	str, err := k.getAsString(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if str == nil {
+		return handler.NewBulkReply([]byte{})
+	}
+	return handler.NewBulkReply(copyBytes(str.GetRange(start, end)))
+}
+
+func (k *KVStore) SetRange(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return handler.NewErrReply("ERR offset is out of range")
+	}
+
+	value := args[2]
+	if offset+int64(len(value)) > maxStringLen {
+		return handler.NewErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	str, err := k.getAsRawString(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// value 为空时不做修改，也不会创建 key
+	if len(value) == 0 {
+		cmd.Unchanged()
+		if str == nil {
+			return handler.NewIntReply(0)
+		}
+		return handler.NewIntReply(str.Len())
+	}
+
+	if str == nil {
+		str = newStringEntity(key, nil)
+		k.data[key] = str
+	}
+	return handler.NewIntReply(str.SetRange(offset, value))
+}
+
+func (k *KVStore) Incr(cmd *database.Command) handler.Reply {
+	return k.incrBy(string(cmd.Args()[0]), 1)
+}
+
+func (k *KVStore) Decr(cmd *database.Command) handler.Reply {
+	return k.incrBy(string(cmd.Args()[0]), -1)
+}
+
+func (k *KVStore) IncrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	return k.incrBy(string(args[0]), delta)
+}
+
+func (k *KVStore) DecrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	if delta == math.MinInt64 {
+		return handler.NewErrReply("ERR decrement would overflow")
+	}
+	return k.incrBy(string(args[0]), -delta)
+}
+
+func (k *KVStore) IncrByFloat(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return handler.NewErrReply("ERR value is not a valid float")
+	}
+
+	str, err := k.getAsString(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var cur float64
+	if str != nil {
+		if cur, err = strconv.ParseFloat(string(str.Bytes()), 64); err != nil {
+			return handler.NewErrReply("ERR value is not a valid float")
+		}
+	}
+
+	res := cur + delta
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return handler.NewErrReply("ERR increment would produce NaN or Infinity")
+	}
+
+	// 浮点运算的结果以 set 的形式持久化，保证 aof 回放结果一致
+	value := []byte(strconv.FormatFloat(res, 'f', -1, 64))
+	k.put(key, value, true)
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeSet), []byte(key), value, []byte("keepttl")})
+	return handler.NewBulkReply(value)
+}
+
+// 自增不会影响 key 原有的过期时间
+func (k *KVStore) incrBy(key string, delta int64) handler.Reply {
+	str, err := k.getAsString(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var cur int64
+	if str != nil {
+		var ok bool
+		if cur, ok = stringToInt(str); !ok {
+			return handler.NewErrReply("ERR value is not an integer or out of range")
+		}
+	}
+
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return handler.NewErrReply("ERR increment or decrement would overflow")
+	}
+
+	k.data[key] = newIntStringEntity(key, cur+delta)
+	return handler.NewIntReply(cur + delta)
+}
+
+// list
+func (k *KVStore) LPush(cmd *database.Command) handler.Reply {
+	return k.push(cmd, true, false)
+}
+
+func (k *KVStore) LPushX(cmd *database.Command) handler.Reply {
+	return k.push(cmd, true, true)
+}
+
+func (k *KVStore) RPush(cmd *database.Command) handler.Reply {
+	return k.push(cmd, false, false)
+}
+
+func (k *KVStore) RPushX(cmd *database.Command) handler.Reply {
+	return k.push(cmd, false, true)
+}
+
+// xx 为 true 时，仅当 list 存在时才推入元素
+func (k *KVStore) push(cmd *database.Command, left, xx bool) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	list, err := k.getAsList(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if list == nil {
+		if xx {
+			cmd.Unchanged()
+			return handler.NewIntReply(0)
+		}
+		list = newQuickList(key)
+		k.putAsList(key, list)
+	}
+
+	for _, arg := range args[1:] {
+		if left {
+			list.LPush(arg)
+		} else {
+			list.RPush(arg)
+		}
+	}
+
+	return handler.NewIntReply(list.Len())
+}
+
+func (k *KVStore) LPop(cmd *database.Command) handler.Reply {
+	return k.pop(cmd, true)
+}
+
+func (k *KVStore) RPop(cmd *database.Command) handler.Reply {
+	return k.pop(cmd, false)
+}
+
+// 未指定 count 时返回单个元素，否则返回数组
+func (k *KVStore) pop(cmd *database.Command, left bool) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	if len(args) > 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	cnt := int64(1)
+	if len(args) == 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt < 0 {
+			return handler.NewErrReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	list, err := k.getAsList(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if list == nil {
+		cmd.Unchanged()
+		if len(args) == 2 {
+			return handler.NewNillMultiBulkReply()
+		}
+		return handler.NewNillReply()
+	}
+
+	if cnt == 0 {
+		cmd.Unchanged()
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	var poped [][]byte
+	if left {
+		poped = list.LPop(cnt)
+	} else {
+		poped = list.RPop(cnt)
+	}
+	k.delIfEmptyList(key, list)
+
+	if len(args) == 1 {
+		return handler.NewBulkReply(poped[0])
+	}
+	return handler.NewMultiBulkReply(poped)
+}
+
+func (k *KVStore) LLen(cmd *database.Command) handler.Reply {
+	list, err := k.getAsList(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(list.Len())
+}
+
+func (k *KVStore) LRange(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	start, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	stop, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, err := k.getAsList(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if list == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	return handler.NewMultiBulkReply(list.Range(start, stop))
+}
+
+func (k *KVStore) LIndex(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	index, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, err := k.getAsList(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		return handler.NewNillReply()
+	}
+
+	element, ok := list.Index(index)
+	if !ok {
+		return handler.NewNillReply()
+	}
+	return handler.NewBulkReply(element)
+}
+
+func (k *KVStore) LSet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	index, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, err := k.getAsList(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		return handler.NewErrReply("ERR no such key")
+	}
+
+	if !list.Set(index, args[2]) {
+		return handler.NewErrReply("ERR index out of range")
+	}
+	return handler.NewOKReply()
+}
+
+func (k *KVStore) LInsert(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	var before bool
+	switch strings.ToLower(string(args[1])) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return handler.NewSyntaxErrReply()
+	}
+
+	list, err := k.getAsList(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	if !list.Insert(args[2], args[3], before) {
+		cmd.Unchanged()
+		return handler.NewIntReply(-1)
+	}
+	return handler.NewIntReply(list.Len())
+}
+
+func (k *KVStore) LRem(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	cnt, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, err := k.getAsList(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	removed := list.Rem(cnt, args[2])
+	if removed == 0 {
+		cmd.Unchanged()
+	}
+	k.delIfEmptyList(key, list)
+	return handler.NewIntReply(removed)
+}
+
+func (k *KVStore) LTrim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	start, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	stop, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, err := k.getAsList(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if list == nil {
+		cmd.Unchanged()
+		return handler.NewOKReply()
+	}
+
+	list.Trim(start, stop)
+	k.delIfEmptyList(key, list)
+	return handler.NewOKReply()
+}
+
+func (k *KVStore) LPos(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	var errReply handler.Reply
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+
+		// 先匹配选项名，再解析选项值
+		switch strings.ToLower(string(args[i])) {
+		case "rank":
+			if rank, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			if rank == 0 {
+				return handler.NewErrReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			if rank == math.MinInt64 {
+				return handler.NewErrReply("ERR value is out of range")
+			}
+		case "count":
+			if count, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			if count < 0 {
+				return handler.NewErrReply("ERR COUNT can't be negative")
+			}
+		case "maxlen":
+			if maxLen, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			if maxLen < 0 {
+				return handler.NewErrReply("ERR MAXLEN can't be negative")
+			}
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	list, err := k.getAsList(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 未指定 count 时，仅返回首个匹配项的下标
+	if count == -1 {
+		if list == nil {
+			return handler.NewNillReply()
+		}
+		if positions := list.Pos(args[1], rank, 1, maxLen); len(positions) > 0 {
+			return handler.NewIntReply(positions[0])
+		}
+		return handler.NewNillReply()
+	}
+
+	if list == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	positions := list.Pos(args[1], rank, count, maxLen)
+	replies := make([]handler.Reply, 0, len(positions))
+	for _, pos := range positions {
+		replies = append(replies, handler.NewIntReply(pos))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+func (k *KVStore) LMove(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	fromLeft, toLeft, errReply := parseListDirections(args[2], args[3])
+	if errReply != nil {
+		return errReply
+	}
+
+	reply, moved := k.move(string(args[0]), string(args[1]), fromLeft, toLeft)
+	if !moved {
+		cmd.Unchanged()
+	}
+	return reply
+}
+
+func parseListDirections(from, to []byte) (bool, bool, handler.Reply) {
+	var fromLeft, toLeft bool
+	for i, arg := range [][]byte{from, to} {
+		switch strings.ToLower(string(arg)) {
+		case "left":
+			if i == 0 {
+				fromLeft = true
+			} else {
+				toLeft = true
+			}
+		case "right":
+		default:
+			return false, false, handler.NewSyntaxErrReply()
+		}
+	}
+	return fromLeft, toLeft, nil
+}
+
+func listDirection(left bool) []byte {
+	if left {
+		return []byte("left")
+	}
+	return []byte("right")
+}
+
+// 从 src 弹出一个元素推入 dst. src 不存在或者发生错误时，moved 为 false
+func (k *KVStore) move(src, dst string, fromLeft, toLeft bool) (reply handler.Reply, moved bool) {
+	srcList, err := k.getAsList(src)
+	if err != nil {
+		return handler.NewErrReply(err.Error()), false
+	}
+	// 目标 key 的类型需要在弹出元素前校验
+	dstList, err := k.getAsList(dst)
+	if err != nil {
+		return handler.NewErrReply(err.Error()), false
+	}
+
+	if srcList == nil {
+		return handler.NewNillReply(), false
+	}
+
+	var poped [][]byte
+	if fromLeft {
+		poped = srcList.LPop(1)
+	} else {
+		poped = srcList.RPop(1)
+	}
+	// src 与 dst 相同时，list 不会因为弹出元素而被删除
+	if src != dst {
+		k.delIfEmptyList(src, srcList)
+	} else {
+		dstList = srcList
+	}
+
+	if dstList == nil {
+		dstList = newQuickList(dst)
+		k.putAsList(dst, dstList)
+	}
+	if toLeft {
+		dstList.LPush(poped[0])
+	} else {
+		dstList.RPush(poped[0])
+	}
+	return handler.NewBulkReply(poped[0]), true
+}
+
+func (k *KVStore) BLPop(cmd *database.Command) handler.Reply {
+	return k.blockingPop(cmd, true)
+}
+
+func (k *KVStore) BRPop(cmd *database.Command) handler.Reply {
+	return k.blockingPop(cmd, false)
+}
+
+// 从第一个非空的 list 中弹出元素，所有 list 均为空时阻塞等待
+func (k *KVStore) blockingPop(cmd *database.Command, left bool) handler.Reply {
+	args := cmd.Args()
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		list, err := k.getAsList(string(key))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		// 空 list 会被删除，因此存在的 list 一定非空
+		if list == nil {
+			continue
+		}
+
+		var poped [][]byte
+		popCmd := database.CmdTypeLPop
+		if left {
+			poped = list.LPop(1)
+		} else {
+			poped = list.RPop(1)
+			popCmd = database.CmdTypeRPop
+		}
+		k.delIfEmptyList(string(key), list)
+
+		// 持久化为非阻塞的弹出指令
+		cmd.Rewrite([][]byte{[]byte(popCmd), key})
+		return handler.NewMultiBulkReply([][]byte{key, poped[0]})
+	}
+
+	cmd.Block(keys, timeout, handler.NewNillMultiBulkReply())
+	return nil
+}
+
+func (k *KVStore) BLMove(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	fromLeft, toLeft, errReply := parseListDirections(args[2], args[3])
+	if errReply != nil {
+		return errReply
+	}
+	timeout, errReply := parseBlockTimeout(args[4])
+	if errReply != nil {
+		return errReply
+	}
+	return k.blockingMove(cmd, fromLeft, toLeft, timeout)
+}
+
+func (k *KVStore) BRPopLPush(cmd *database.Command) handler.Reply {
+	timeout, errReply := parseBlockTimeout(cmd.Args()[2])
+	if errReply != nil {
+		return errReply
+	}
+	return k.blockingMove(cmd, false, true, timeout)
+}
+
+// src 为空时阻塞等待，直到 src 中有元素可以移动
+func (k *KVStore) blockingMove(cmd *database.Command, fromLeft, toLeft bool, timeout time.Duration) handler.Reply {
+	args := cmd.Args()
+	reply, moved := k.move(string(args[0]), string(args[1]), fromLeft, toLeft)
+	if handler.IsErrReply(reply) {
+		return reply
+	}
+
+	if !moved {
+		cmd.Block(args[:1], timeout, handler.NewNillMultiBulkReply())
+		return nil
+	}
+
+	// 持久化为非阻塞的 lmove 指令
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeLMove), args[0], args[1], listDirection(fromLeft), listDirection(toLeft)})
+	return reply
+}
+
+// 阻塞指令的超时时间，单位为秒，支持小数并精确到毫秒. 0 表示永不超时
+func parseBlockTimeout(arg []byte) (time.Duration, handler.Reply) {
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, handler.NewErrReply("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, handler.NewErrReply("ERR timeout is negative")
+	}
+	if timeout*1000 > float64(math.MaxInt64/int64(time.Millisecond)) {
+		return 0, handler.NewErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(timeout*1000) * time.Millisecond, nil
+}
+
+// set
+func (k *KVStore) SAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	set, err := k.getAsSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		set = newSetEntity(key, k.thresholds)
+		k.putAsSet(key, set)
+	}
+
+	var added int64
+	for _, arg := range args[1:] {
+		added += set.Add(string(arg))
+	}
+
+	return handler.NewIntReply(added)
+}
+
+func (k *KVStore) SIsMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	set, err := k.getAsSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		return handler.NewIntReply(0)
+	}
+
+	return handler.NewIntReply(set.Exist(string(args[1])))
+}
+
+func (k *KVStore) SRem(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	set, err := k.getAsSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	var remed int64
+	for _, arg := range args[1:] {
+		remed += set.Rem(string(arg))
+	}
+
+	if remed == 0 {
+		cmd.Unchanged()
+	}
+	k.delIfEmptySet(key, set)
+	return handler.NewIntReply(remed)
+}
+
+func (k *KVStore) SMembers(cmd *database.Command) handler.Reply {
+	set, err := k.getAsSet(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return newMembersReply(set.Members())
+}
+
+func (k *KVStore) SCard(cmd *database.Command) handler.Reply {
+	set, err := k.getAsSet(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(set.Len())
+}
+
+func (k *KVStore) SMIsMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	replies := make([]handler.Reply, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var exist int64
+		if set != nil {
+			exist = set.Exist(string(arg))
+		}
+		replies = append(replies, handler.NewIntReply(exist))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// 弹出的元素是随机的，因此持久化为 srem 指令
+func (k *KVStore) SPop(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	if len(args) > 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	cnt := int64(1)
+	if len(args) == 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt < 0 {
+			return handler.NewErrReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	set, err := k.getAsSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil || cnt == 0 {
+		cmd.Unchanged()
+		if len(args) == 2 {
+			return handler.NewEmptyMultiBulkReply()
+		}
+		return handler.NewNillReply()
+	}
+
+	poped := set.Pop(cnt)
+	k.delIfEmptySet(key, set)
+
+	remCmd := make([][]byte, 0, 2+len(poped))
+	remCmd = append(remCmd, []byte(database.CmdTypeSRem), args[0])
+	for _, member := range poped {
+		remCmd = append(remCmd, []byte(member))
+	}
+	cmd.Rewrite(remCmd)
+
+	if len(args) == 1 {
+		return handler.NewBulkReply([]byte(poped[0]))
+	}
+	return newMembersReply(poped)
+}
+
+// count 为负数时，返回的元素可能重复
+func (k *KVStore) SRandMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var cnt int64
+	if len(args) == 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt == math.MinInt64 {
+			return handler.NewErrReply("ERR value is out of range")
+		}
+	}
+
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if len(args) == 1 {
+		if set == nil {
+			return handler.NewNillReply()
+		}
+		return handler.NewBulkReply([]byte(set.RandMembers(1, true)[0]))
+	}
+
+	if set == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	if cnt < 0 {
+		return newMembersReply(set.RandMembers(-cnt, false))
+	}
+	return newMembersReply(set.RandMembers(cnt, true))
+}
+
+func (k *KVStore) SMove(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	src, dst, member := string(args[0]), string(args[1]), string(args[2])
+	srcSet, err := k.getAsSet(src)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	dstSet, err := k.getAsSet(dst)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if srcSet == nil || srcSet.Exist(member) == 0 {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	// src 与 dst 相同时不做任何操作
+	if src == dst {
+		cmd.Unchanged()
+		return handler.NewIntReply(1)
+	}
+
+	srcSet.Rem(member)
+	k.delIfEmptySet(src, srcSet)
+	if dstSet == nil {
+		dstSet = newSetEntity(dst, k.thresholds)
+		k.putAsSet(dst, dstSet)
+	}
+	dstSet.Add(member)
+	return handler.NewIntReply(1)
+}
+
+// 获取多个 set，key 不存在时对应位置为 nil
+func (k *KVStore) getAsSets(keys [][]byte) ([]Set, handler.Reply) {
+	sets := make([]Set, 0, len(keys))
+	for _, key := range keys {
+		set, err := k.getAsSet(string(key))
+		if err != nil {
+			return nil, handler.NewErrReply(err.Error())
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// 以 members 覆盖 dest 原有的数据以及过期时间，members 为空时删除 dest
+func (k *KVStore) storeSet(dest string, members []string) int64 {
+	k.del(dest)
+	if len(members) == 0 {
+		return 0
+	}
+
+	set := newSetEntity(dest, k.thresholds)
+	for _, member := range members {
+		set.Add(member)
+	}
+	k.putAsSet(dest, set)
+	return set.Len()
+}
+
+func (k *KVStore) SInter(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, func(sets []Set) []string {
+		return interSets(sets, 0)
+	}, false)
+}
+
+func (k *KVStore) SInterStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, func(sets []Set) []string {
+		return interSets(sets, 0)
+	}, true)
+}
+
+func (k *KVStore) SUnion(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, unionSets, false)
+}
+
+func (k *KVStore) SUnionStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, unionSets, true)
+}
+
+func (k *KVStore) SDiff(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, diffSets, false)
+}
+
+func (k *KVStore) SDiffStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd, diffSets, true)
+}
+
+// store 为 true 时，第一个参数为目标 key，运算结果写入目标 key 并返回元素个数
+func (k *KVStore) setAlgebra(cmd *database.Command, op func([]Set) []string, store bool) handler.Reply {
+	args := cmd.Args()
+	keys := args
+	if store {
+		keys = args[1:]
+	}
+
+	sets, errReply := k.getAsSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+
+	members := op(sets)
+	if !store {
+		return newMembersReply(members)
+	}
+	return handler.NewIntReply(k.storeSet(string(args[0]), members))
+}
+
+func (k *KVStore) SInterCard(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	numKeys, errReply := parseInt(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if numKeys <= 0 {
+		return handler.NewErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return handler.NewErrReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	var limit int64
+	for i := 1 + int(numKeys); i < len(args); i += 2 {
+		if strings.ToLower(string(args[i])) != "limit" || i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+		if limit, errReply = parseInt(args[i+1]); errReply != nil {
+			return errReply
+		}
+		if limit < 0 {
+			return handler.NewErrReply("ERR LIMIT can't be negative")
+		}
+	}
+
+	// key 的数量由 numkeys 决定，没有在指令表中声明，需要自行处理过期
+	keys := args[1 : 1+numKeys]
+	for _, key := range keys {
+		k.ExpirePreprocess(string(key))
+	}
+
+	sets, errReply := k.getAsSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	return handler.NewIntReply(int64(len(interSets(sets, limit))))
+}
+
+func newMembersReply(members []string) handler.Reply {
+	bulks := make([][]byte, 0, len(members))
+	for _, member := range members {
+		bulks = append(bulks, []byte(member))
+	}
+	return handler.NewMultiBulkReply(bulks)
+}
+
+// hash
+func (k *KVStore) HSet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args)&1 != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		hmap = newHashMapEntity(key, k.thresholds)
+		k.putAsHashMap(key, hmap)
+	}
+
+	var added int64
+	for i := 0; i < len(args)-1; i += 2 {
+		hkey := string(args[i+1])
+		hvalue := args[i+2]
+		added += hmap.Put(hkey, hvalue)
+	}
+
+	return handler.NewIntReply(added)
+}
+
+func (k *KVStore) HGet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		return handler.NewNillReply()
+	}
+
+	if v := hmap.Get(string(args[1])); v != nil {
+		return handler.NewBulkReply(v)
+	}
+
+	return handler.NewNillReply()
+}
+
+func (k *KVStore) HDel(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	var remed int64
+	for _, arg := range args[1:] {
+		remed += hmap.Del(string(arg))
+	}
+
+	if remed == 0 {
+		cmd.Unchanged()
+	}
+	k.delIfEmptyHashMap(key, hmap)
+	return handler.NewIntReply(remed)
+}
+
+func (k *KVStore) HGetAll(cmd *database.Command) handler.Reply {
+	hmap, err := k.getAsHashMap(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	res := make([][]byte, 0, 2*hmap.Len())
+	hmap.ForEach(func(field string, value []byte) bool {
+		res = append(res, []byte(field), value)
+		return true
+	})
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) HMGet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var value []byte
+		if hmap != nil {
+			value = hmap.Get(string(arg))
+		}
+		res = append(res, value)
+	}
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) HKeys(cmd *database.Command) handler.Reply {
+	return k.hashScan(cmd, func(field string, _ []byte) []byte {
+		return []byte(field)
+	})
+}
+
+func (k *KVStore) HVals(cmd *database.Command) handler.Reply {
+	return k.hashScan(cmd, func(_ string, value []byte) []byte {
+		return value
+	})
+}
+
+// 遍历 hash 的全部字段，通过 pick 选取返回的内容
+func (k *KVStore) hashScan(cmd *database.Command, pick func(field string, value []byte) []byte) handler.Reply {
+	hmap, err := k.getAsHashMap(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	res := make([][]byte, 0, hmap.Len())
+	hmap.ForEach(func(field string, value []byte) bool {
+		res = append(res, pick(field, value))
+		return true
+	})
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) HLen(cmd *database.Command) handler.Reply {
+	hmap, err := k.getAsHashMap(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(hmap.Len())
+}
+
+func (k *KVStore) HExists(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil || hmap.Get(string(args[1])) == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(1)
+}
+
+func (k *KVStore) HStrLen(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(int64(len(hmap.Get(string(args[1])))))
+}
+
+func (k *KVStore) HSetNX(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap != nil && hmap.Get(string(args[1])) != nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	if hmap == nil {
+		hmap = newHashMapEntity(key, k.thresholds)
+		k.putAsHashMap(key, hmap)
+	}
+	return handler.NewIntReply(hmap.Put(string(args[1]), args[2]))
+}
+
+func (k *KVStore) HIncrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	delta, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		hmap = newHashMapEntity(key, k.thresholds)
+		k.putAsHashMap(key, hmap)
+	}
+
+	res, err := hmap.IncrBy(string(args[1]), delta)
+	if err != nil {
+		k.delIfEmptyHashMap(key, hmap)
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 字段设有过期时间时，改写为 hset + hpexpireat，保证回放时已过期的字段被删除
+	if expiredAt, ok := hmap.ExpiredAt(string(args[1])); ok {
+		cmd.Rewrite([][]byte{[]byte(database.CmdTypeHSet), args[0], args[1], []byte(strconv.FormatInt(res, 10))},
+			hpexpireAtCmd(key, expiredAt.UnixMilli(), [][]byte{args[1]}))
+	}
+	return handler.NewIntReply(res)
+}
+
+func (k *KVStore) HIncrByFloat(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return handler.NewErrReply("ERR value is not a valid float")
+	}
+
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if hmap == nil {
+		hmap = newHashMapEntity(key, k.thresholds)
+		k.putAsHashMap(key, hmap)
+	}
+
+	value, err := hmap.IncrByFloat(string(args[1]), delta)
+	if err != nil {
+		k.delIfEmptyHashMap(key, hmap)
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 浮点运算的结果以 hset 的形式持久化，保证 aof 回放结果一致.
+	// hset 会清除字段的过期时间，因此随后以 hpexpireat 恢复，回放时已过期的字段会被删除
+	hset := [][]byte{[]byte(database.CmdTypeHSet), args[0], args[1], value}
+	if expiredAt, ok := hmap.ExpiredAt(string(args[1])); ok {
+		cmd.Rewrite(hset, hpexpireAtCmd(key, expiredAt.UnixMilli(), [][]byte{args[1]}))
+		return handler.NewBulkReply(value)
+	}
+	cmd.Rewrite(hset)
+	return handler.NewBulkReply(value)
+}
+
+func (k *KVStore) HExpire(cmd *database.Command) handler.Reply {
+	return k.hexpireGeneric(cmd, time.Second, false)
+}
+
+func (k *KVStore) HPExpire(cmd *database.Command) handler.Reply {
+	return k.hexpireGeneric(cmd, time.Millisecond, false)
+}
+
+func (k *KVStore) HExpireAt(cmd *database.Command) handler.Reply {
+	return k.hexpireGeneric(cmd, time.Second, true)
+}
+
+func (k *KVStore) HPExpireAt(cmd *database.Command) handler.Reply {
+	return k.hexpireGeneric(cmd, time.Millisecond, true)
+}
+
+func (k *KVStore) HTTL(cmd *database.Command) handler.Reply {
+	return k.httl(cmd, time.Second, false)
+}
+
+func (k *KVStore) HPTTL(cmd *database.Command) handler.Reply {
+	return k.httl(cmd, time.Millisecond, false)
+}
+
+func (k *KVStore) HExpireTime(cmd *database.Command) handler.Reply {
+	return k.httl(cmd, time.Second, true)
+}
+
+func (k *KVStore) HPExpireTime(cmd *database.Command) handler.Reply {
+	return k.httl(cmd, time.Millisecond, true)
+}
+
+// 对每个字段依次回复：-2 字段不存在，-1 字段没有过期时间，1 移除成功
+func (k *KVStore) HPersist(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var persisted bool
+	res := make([]handler.Reply, 0, len(fields))
+	for _, field := range fields {
+		switch {
+		case hmap == nil || hmap.Get(string(field)) == nil:
+			res = append(res, handler.NewIntReply(-2))
+		case hmap.Persist(string(field)) == 0:
+			res = append(res, handler.NewIntReply(-1))
+		default:
+			persisted = true
+			res = append(res, handler.NewIntReply(1))
+		}
+	}
+
+	if !persisted {
+		cmd.Unchanged()
+		return handler.NewArrayReply(res)
+	}
+	k.scheduleFieldExpire(key, hmap)
+	return handler.NewArrayReply(res)
+}
+
+// 对每个字段依次回复：-2 字段不存在，0 不满足 NX XX GT LT 条件，1 设置成功，2 过期时间已到，字段被删除
+func (k *KVStore) hexpireGeneric(cmd *database.Command, unit time.Duration, absolute bool) handler.Reply {
+	args := cmd.Args()
+	expiredAt, errReply := parseExpireTime(cmd, args[1], unit, absolute)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 至多携带一个条件选项
+	optArgs := args[2:]
+	var cond expireCondition
+	if strings.ToLower(string(optArgs[0])) != "fields" {
+		if cond, errReply = parseExpireCondition(optArgs[:1]); errReply != nil {
+			return errReply
+		}
+		optArgs = optArgs[1:]
+	}
+
+	fields, errReply := parseFields(optArgs)
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(args[0])
+	hmap, err := k.getAsHashMap(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	now := lib.TimeNow()
+	changed := make([][]byte, 0, len(fields))
+	res := make([]handler.Reply, 0, len(fields))
+	for _, field := range fields {
+		if hmap == nil || hmap.Get(string(field)) == nil {
+			res = append(res, handler.NewIntReply(-2))
+			continue
+		}
+
+		current, volatile := hmap.ExpiredAt(string(field))
+		if !cond.satisfied(current, volatile, expiredAt) {
+			res = append(res, handler.NewIntReply(0))
+			continue
+		}
+
+		changed = append(changed, field)
+		if !expiredAt.After(now) {
+			hmap.Del(string(field))
+			res = append(res, handler.NewIntReply(2))
+			continue
+		}
+		hmap.Expire(string(field), expiredAt)
+		res = append(res, handler.NewIntReply(1))
+	}
+
+	if len(changed) == 0 {
+		cmd.Unchanged()
+		return handler.NewArrayReply(res)
+	}
+
+	// 过期时间已到的字段以 hdel 的形式持久化，否则统一以毫秒级的绝对时间进行持久化
+	if !expiredAt.After(now) {
+		cmd.Rewrite(append([][]byte{[]byte(database.CmdTypeHDel), args[0]}, changed...))
+		k.delIfEmptyHashMap(key, hmap)
+	} else {
+		cmd.Rewrite(hpexpireAtCmd(key, expiredAt.UnixMilli(), changed))
+	}
+	if _, ok := k.data[key]; ok {
+		k.scheduleFieldExpire(key, hmap)
+	}
+	return handler.NewArrayReply(res)
+}
+
+// 对每个字段依次回复：-2 字段不存在，-1 字段没有过期时间，否则为剩余时间或者过期的时间戳
+func (k *KVStore) httl(cmd *database.Command, unit time.Duration, absolute bool) handler.Reply {
+	args := cmd.Args()
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(fields))
+	for _, field := range fields {
+		if hmap == nil || hmap.Get(string(field)) == nil {
+			res = append(res, handler.NewIntReply(-2))
+			continue
+		}
+
+		expiredAt, ok := hmap.ExpiredAt(string(field))
+		if !ok {
+			res = append(res, handler.NewIntReply(-1))
+			continue
+		}
+		res = append(res, handler.NewIntReply(ttlOf(expiredAt, unit, absolute)))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// count 为负数时，返回的字段可能重复
+func (k *KVStore) HRandField(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var (
+		cnt        int64
+		withValues bool
+	)
+	if len(args) >= 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt == math.MinInt64 || (cnt < -math.MaxInt64/2 && len(args) == 3) {
+			return handler.NewErrReply("ERR value is out of range")
+		}
+	}
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "withvalues" {
+			return handler.NewSyntaxErrReply()
+		}
+		withValues = true
+	}
+
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if len(args) == 1 {
+		if hmap == nil {
+			return handler.NewNillReply()
+		}
+		return handler.NewBulkReply([]byte(hmap.RandFields(1, true)[0]))
+	}
+
+	if hmap == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	var fields []string
+	if cnt < 0 {
+		fields = hmap.RandFields(-cnt, false)
+	} else {
+		fields = hmap.RandFields(cnt, true)
+	}
+
+	res := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		res = append(res, []byte(field))
+		if withValues {
+			res = append(res, hmap.Get(field))
+		}
+	}
+	return handler.NewMultiBulkReply(res)
+}
+
+// sorted set
+// NX XX GT LT 决定是否写入，CH 使返回值包含 score 发生变更的元素，INCR 将 score 作为增量并返回新的 score
+func (k *KVStore) ZAdd(cmd *database.Command) handler.Reply {
+	return k.zadd(cmd, cmd.Args())
+}
+
+// args 为 zadd 形式的参数，geoadd 将经纬度转为 score 后复用
+func (k *KVStore) zadd(cmd *database.Command, args [][]byte) handler.Reply {
+	key := string(args[0])
+
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)&1 != 0 {
+		return handler.NewSyntaxErrReply()
+	}
+	if nx && xx {
+		return handler.NewErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return handler.NewErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return handler.NewErrReply("ERR INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, 0, len(pairs)>>1)
+	for i := 0; i < len(pairs); i += 2 {
+		score, ok := parseScore(pairs[i])
+		if !ok {
+			return handler.NewErrReply("ERR value is not a valid float")
+		}
+		scores = append(scores, score)
+	}
+
+	zset, err := k.getAsSortedSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		if xx {
+			cmd.Unchanged()
+			if incr {
+				return handler.NewNillReply()
+			}
+			return handler.NewIntReply(0)
+		}
+		zset = newZSetEntity(key, k.thresholds)
+		k.putAsSortedSet(key, zset)
 	}
-	return handler.NewIntReply(remed)
+
+	var added, changed int64
+	for i, score := range scores {
+		member := string(pairs[2*i+1])
+		cur, exist := zset.Score(member)
+		if incr && exist {
+			if score += cur; math.IsNaN(score) {
+				return handler.NewErrReply("ERR resulting score is not a number (NaN)")
+			}
+			scores[i] = score
+		}
+
+		if (exist && nx) || (!exist && xx) || (exist && ((gt && score <= cur) || (lt && score >= cur))) {
+			// incr 模式下未写入时返回 nil
+			if incr {
+				cmd.Unchanged()
+				return handler.NewNillReply()
+			}
+			continue
+		}
+
+		if !exist {
+			added++
+		} else if score != cur {
+			changed++
+		}
+		zset.Add(score, member)
+	}
+
+	if incr {
+		// 以 zadd 的形式持久化自增后的 score
+		cmd.Rewrite([][]byte{[]byte(database.CmdTypeZAdd), args[0], []byte(formatScore(scores[0])), pairs[1]})
+		return handler.NewBulkReply([]byte(formatScore(scores[0])))
+	}
+
+	if added+changed == 0 {
+		cmd.Unchanged()
+	}
+	if ch {
+		return handler.NewIntReply(added + changed)
+	}
+	return handler.NewIntReply(added)
 }
 
-// hash
-func (k *KVStore) HSet(cmd *database.Command) handler.Reply {
+func (k *KVStore) ZIncrBy(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	if len(args)&1 != 1 {
-		return handler.NewSyntaxErrReply()
+	key := string(args[0])
+	delta, ok := parseScore(args[1])
+	if !ok {
+		return handler.NewErrReply("ERR value is not a valid float")
 	}
 
-	key := string(args[0])
-	hmap, err := k.getAsHashMap(key)
+	zset, err := k.getAsSortedSet(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if hmap == nil {
-		hmap = newHashMapEntity(key)
-		k.putAsHashMap(key, hmap)
+	if zset == nil {
+		zset = newZSetEntity(key, k.thresholds)
+		k.putAsSortedSet(key, zset)
 	}
 
-	for i := 0; i < len(args)-1; i += 2 {
-		hkey := string(args[i+1])
-		hvalue := args[i+2]
-		hmap.Put(hkey, hvalue)
+	cur, _ := zset.Score(string(args[2]))
+	score := cur + delta
+	if math.IsNaN(score) {
+		k.delIfEmptySortedSet(key, zset)
+		return handler.NewErrReply("ERR resulting score is not a number (NaN)")
 	}
+	zset.Add(score, string(args[2]))
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	return handler.NewIntReply(int64((len(args) - 1) >> 1))
+	// 以 zadd 的形式持久化自增后的 score
+	cmd.Rewrite([][]byte{[]byte(database.CmdTypeZAdd), args[0], []byte(formatScore(score)), args[2]})
+	return handler.NewBulkReply([]byte(formatScore(score)))
 }
 
-func (k *KVStore) HGet(cmd *database.Command) handler.Reply {
+func (k *KVStore) ZScore(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	key := string(args[0])
-	hmap, err := k.getAsHashMap(key)
+	zset, err := k.getAsSortedSet(string(args[0]))
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if hmap == nil {
+	if zset == nil {
+		return handler.NewNillReply()
+	}
+	score, ok := zset.Score(string(args[1]))
+	if !ok {
 		return handler.NewNillReply()
 	}
+	return handler.NewBulkReply([]byte(formatScore(score)))
+}
 
-	if v := hmap.Get(string(args[1])); v != nil {
-		return handler.NewBulkReply(v)
+// 不存在的 member 对应位置为 nil
+func (k *KVStore) ZMScore(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
 	}
 
-	return handler.NewNillReply()
+	res := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if zset == nil {
+			res = append(res, nil)
+			continue
+		}
+		if score, ok := zset.Score(string(arg)); ok {
+			res = append(res, []byte(formatScore(score)))
+		} else {
+			res = append(res, nil)
+		}
+	}
+	return handler.NewMultiBulkReply(res)
 }
 
-func (k *KVStore) HDel(cmd *database.Command) handler.Reply {
+func (k *KVStore) ZCount(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	min, ok1 := parseScoreBound(args[1])
+	max, ok2 := parseScoreBound(args[2])
+	if !ok1 || !ok2 {
+		return handler.NewErrReply("ERR min or max is not a float")
+	}
+	return k.count(string(args[0]), zrangeSpec{min: min, max: max})
+}
+
+func (k *KVStore) ZLexCount(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	min, ok1 := parseLexBound(args[1])
+	max, ok2 := parseLexBound(args[2])
+	if !ok1 || !ok2 {
+		return handler.NewErrReply("ERR min or max not valid string range item")
+	}
+	return k.count(string(args[0]), zrangeSpec{min: min, max: max, byLex: true})
+}
+
+func (k *KVStore) count(key string, spec zrangeSpec) handler.Reply {
+	zset, err := k.getAsSortedSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(zset.Count(spec))
+}
+
+func (k *KVStore) ZRem(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
-	hmap, err := k.getAsHashMap(key)
+	zset, err := k.getAsSortedSet(key)
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if hmap == nil {
+	if zset == nil {
+		cmd.Unchanged()
 		return handler.NewIntReply(0)
 	}
 
 	var remed int64
 	for _, arg := range args[1:] {
-		remed += hmap.Del(string(arg))
+		remed += zset.Rem(string(arg))
 	}
 
-	if remed > 0 {
-		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	if remed == 0 {
+		cmd.Unchanged()
 	}
+	k.delIfEmptySortedSet(key, zset)
 	return handler.NewIntReply(remed)
 }
 
-// sorted set
-func (k *KVStore) ZAdd(cmd *database.Command) handler.Reply {
+func (k *KVStore) ZCard(cmd *database.Command) handler.Reply {
+	zset, err := k.getAsSortedSet(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(zset.Len())
+}
+
+func (k *KVStore) ZRank(cmd *database.Command) handler.Reply {
+	return k.rank(cmd, false)
+}
+
+func (k *KVStore) ZRevRank(cmd *database.Command) handler.Reply {
+	return k.rank(cmd, true)
+}
+
+// 携带 withscore 选项时，同时返回 member 的 score
+func (k *KVStore) rank(cmd *database.Command, reverse bool) handler.Reply {
 	args := cmd.Args()
-	if len(args)&1 != 1 {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(string(args[2])) != "withscore") {
 		return handler.NewSyntaxErrReply()
 	}
+	withScore := len(args) == 3
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
 
-	key := string(args[0])
 	var (
-		scores  = make([]int64, 0, (len(args)-1)>>1)
-		members = make([]string, 0, (len(args)-1)>>1)
+		rank  int64
+		score float64
+		ok    bool
 	)
-
-	for i := 0; i < len(args)-1; i += 2 {
-		score, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-		if err != nil {
-			return handler.NewSyntaxErrReply()
+	if zset != nil {
+		rank, score, ok = zset.Rank(string(args[1]))
+	}
+	if !ok {
+		if withScore {
+			return handler.NewNillMultiBulkReply()
 		}
+		return handler.NewNillReply()
+	}
 
-		scores = append(scores, score)
-		members = append(members, string(args[i+2]))
+	if reverse {
+		rank = zset.Len() - 1 - rank
+	}
+	if !withScore {
+		return handler.NewIntReply(rank)
 	}
+	return handler.NewArrayReply([]handler.Reply{handler.NewIntReply(rank), handler.NewBulkReply([]byte(formatScore(score)))})
+}
 
-	zset, err := k.getAsSortedSet(key)
+func (k *KVStore) ZRange(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{unified: true})
+}
+
+func (k *KVStore) ZRevRange(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{reverse: true})
+}
+
+func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{byScore: true})
+}
+
+func (k *KVStore) ZRevRangeByScore(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{byScore: true, reverse: true})
+}
+
+func (k *KVStore) ZRangeByLex(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{byLex: true})
+}
+
+func (k *KVStore) ZRevRangeByLex(cmd *database.Command) handler.Reply {
+	return k.zrangeGeneric(cmd, zrangeMode{byLex: true, reverse: true})
+}
+
+// 将 src 中区间内的元素连同 score 写入 dst，覆盖 dst 原有的数据以及过期时间. 结果为空时删除 dst
+func (k *KVStore) ZRangeStore(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	req, errReply := parseZRangeRequest(args[1:], zrangeMode{unified: true, store: true})
+	if errReply != nil {
+		return errReply
+	}
+
+	items, errReply := k.zrangeItems(req)
+	if errReply != nil {
+		return errReply
+	}
+
+	return handler.NewIntReply(k.storeZSet(string(args[0]), items))
+}
+
+// 以 items 覆盖 dest 原有的数据以及过期时间，items 为空时删除 dest
+func (k *KVStore) storeZSet(dest string, items []zsetItem) int64 {
+	k.del(dest)
+	if len(items) == 0 {
+		return 0
+	}
+
+	zset := newZSetEntity(dest, k.thresholds)
+	for _, item := range items {
+		zset.Add(item.score, item.member)
+	}
+	k.putAsSortedSet(dest, zset)
+	return zset.Len()
+}
+
+func (k *KVStore) zrangeGeneric(cmd *database.Command, mode zrangeMode) handler.Reply {
+	req, errReply := parseZRangeRequest(cmd.Args(), mode)
+	if errReply != nil {
+		return errReply
+	}
+
+	items, errReply := k.zrangeItems(req)
+	if errReply != nil {
+		return errReply
+	}
+	if len(items) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return newZSetItemsReply(items, req.withScores)
+}
+
+func (k *KVStore) zrangeItems(req *zrangeRequest) ([]zsetItem, handler.Reply) {
+	zset, err := k.getAsSortedSet(req.key)
 	if err != nil {
-		return handler.NewErrReply(err.Error())
+		return nil, handler.NewErrReply(err.Error())
 	}
 
 	if zset == nil {
-		zset = newSkiplist(key)
-		k.putAsSortedSet(key, zset)
+		return []zsetItem{}, nil
+	}
+
+	if req.byScore || req.spec.byLex {
+		return zset.Range(req.spec), nil
 	}
 
-	for i := 0; i < len(scores); i++ {
-		zset.Add(scores[i], members[i])
+	start, stop, ok := normalizeRange(req.start, req.stop, zset.Len())
+	if !ok {
+		return []zsetItem{}, nil
 	}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	return handler.NewIntReply(int64(len(scores)))
+	// 降序排名 [start,stop] 对应升序排名 [len-1-stop,len-1-start]
+	if !req.spec.reverse {
+		return zset.RangeByRank(start, stop), nil
+	}
+	items := zset.RangeByRank(zset.Len()-1-stop, zset.Len()-1-start)
+	reverseZSetItems(items)
+	return items, nil
 }
 
-func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
-	args := cmd.Args()
-	if len(args) < 3 {
-		return handler.NewSyntaxErrReply()
+func newZSetItemsReply(items []zsetItem, withScores bool) handler.Reply {
+	res := make([][]byte, 0, 2*len(items))
+	for _, item := range items {
+		res = append(res, []byte(item.member))
+		if withScores {
+			res = append(res, []byte(formatScore(item.score)))
+		}
 	}
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) ZPopMin(cmd *database.Command) handler.Reply {
+	return k.zpop(cmd, false)
+}
+
+func (k *KVStore) ZPopMax(cmd *database.Command) handler.Reply {
+	return k.zpop(cmd, true)
+}
 
+func (k *KVStore) zpop(cmd *database.Command, max bool) handler.Reply {
+	args := cmd.Args()
 	key := string(args[0])
-	score1, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
+	if len(args) > 2 {
 		return handler.NewSyntaxErrReply()
 	}
-	score2, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return handler.NewSyntaxErrReply()
+
+	cnt := int64(1)
+	if len(args) == 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt < 0 {
+			return handler.NewErrReply("ERR value is out of range, must be positive")
+		}
 	}
 
 	zset, err := k.getAsSortedSet(key)
@@ -534,42 +2580,241 @@ func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if zset == nil {
-		return handler.NewNillReply()
+	if zset == nil || cnt == 0 {
+		cmd.Unchanged()
+		return handler.NewEmptyMultiBulkReply()
 	}
+	return newZSetItemsReply(k.popZSet(key, zset, cnt, max), true)
+}
 
-	rawRes := zset.Range(score1, score2)
-	if len(rawRes) == 0 {
-		return handler.NewNillReply()
+// 弹出 score 最小或者最大的至多 cnt 个元素，zset 为空时删除 key
+func (k *KVStore) popZSet(key string, zset SortedSet, cnt int64, max bool) []zsetItem {
+	if cnt > zset.Len() {
+		cnt = zset.Len()
 	}
 
-	res := make([][]byte, 0, len(rawRes))
-	for _, item := range rawRes {
-		res = append(res, []byte(item))
+	var items []zsetItem
+	if max {
+		items = zset.RangeByRank(zset.Len()-cnt, zset.Len()-1)
+		reverseZSetItems(items)
+	} else {
+		items = zset.RangeByRank(0, cnt-1)
 	}
 
-	return handler.NewMultiBulkReply(res)
+	for _, item := range items {
+		zset.Rem(item.member)
+	}
+	k.delIfEmptySortedSet(key, zset)
+	return items
 }
 
-func (k *KVStore) ZRem(cmd *database.Command) handler.Reply {
+func (k *KVStore) BZPopMin(cmd *database.Command) handler.Reply {
+	return k.blockingZPop(cmd, false)
+}
+
+func (k *KVStore) BZPopMax(cmd *database.Command) handler.Reply {
+	return k.blockingZPop(cmd, true)
+}
+
+// 从第一个非空的 zset 中弹出元素，所有 zset 均为空时阻塞等待
+func (k *KVStore) blockingZPop(cmd *database.Command, max bool) handler.Reply {
 	args := cmd.Args()
-	key := string(args[0])
-	zset, err := k.getAsSortedSet(key)
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		zset, err := k.getAsSortedSet(string(key))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		// 空 zset 会被删除，因此存在的 zset 一定非空
+		if zset == nil {
+			continue
+		}
+
+		item := k.popZSet(string(key), zset, 1, max)[0]
+
+		// 持久化为非阻塞的弹出指令
+		popCmd := database.CmdTypeZPopMin
+		if max {
+			popCmd = database.CmdTypeZPopMax
+		}
+		cmd.Rewrite([][]byte{[]byte(popCmd), key})
+		return handler.NewMultiBulkReply([][]byte{key, []byte(item.member), []byte(formatScore(item.score))})
+	}
+
+	cmd.Block(keys, timeout, handler.NewNillMultiBulkReply())
+	return nil
+}
+
+// count 为负数时，返回的元素可能重复
+func (k *KVStore) ZRandMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var (
+		cnt        int64
+		withScores bool
+	)
+	if len(args) >= 2 {
+		var errReply handler.Reply
+		if cnt, errReply = parseInt(args[1]); errReply != nil {
+			return errReply
+		}
+		if cnt == math.MinInt64 || (cnt < -math.MaxInt64/2 && len(args) == 3) {
+			return handler.NewErrReply("ERR value is out of range")
+		}
+	}
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "withscores" {
+			return handler.NewSyntaxErrReply()
+		}
+		withScores = true
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
 	if err != nil {
 		return handler.NewErrReply(err.Error())
 	}
 
+	if len(args) == 1 {
+		if zset == nil {
+			return handler.NewNillReply()
+		}
+		index := randIndexes(zset.Len(), 1, true)[0]
+		return handler.NewBulkReply([]byte(zset.RangeByRank(index, index)[0].member))
+	}
+
 	if zset == nil {
-		return handler.NewIntReply(0)
+		return handler.NewEmptyMultiBulkReply()
 	}
 
-	var remed int64
-	for _, arg := range args {
-		remed += zset.Rem(string(arg))
+	var indexes []int64
+	if cnt < 0 {
+		indexes = randIndexes(zset.Len(), -cnt, false)
+	} else {
+		indexes = randIndexes(zset.Len(), cnt, true)
 	}
 
-	if remed > 0 {
-		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	// 按照排名定位元素，skiplist 编码下耗时为 O(logn)
+	items := make([]zsetItem, 0, len(indexes))
+	for _, index := range indexes {
+		items = append(items, zset.RangeByRank(index, index)[0])
 	}
-	return handler.NewIntReply(remed)
+	return newZSetItemsReply(items, withScores)
+}
+
+func (k *KVStore) ZUnion(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, unionZSets, false, true)
+}
+
+func (k *KVStore) ZUnionStore(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, unionZSets, true, true)
+}
+
+func (k *KVStore) ZInter(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, interZSets, false, true)
+}
+
+func (k *KVStore) ZInterStore(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, interZSets, true, true)
+}
+
+func (k *KVStore) ZDiff(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, func(sources []zsetSource, _ []float64, _ zsetAggregate) []zsetItem {
+		return diffZSets(sources)
+	}, false, false)
+}
+
+func (k *KVStore) ZDiffStore(cmd *database.Command) handler.Reply {
+	return k.zsetAlgebra(cmd, func(sources []zsetSource, _ []float64, _ zsetAggregate) []zsetItem {
+		return diffZSets(sources)
+	}, true, false)
+}
+
+// 解析 numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES] 形式的参数.
+// store 为 true 时，第一个参数为目标 key，运算结果写入目标 key 并返回元素个数. weighted 为 false 时不支持 WEIGHTS 与 AGGREGATE 选项
+func (k *KVStore) zsetAlgebra(cmd *database.Command, op func([]zsetSource, []float64, zsetAggregate) []zsetItem, store, weighted bool) handler.Reply {
+	args := cmd.Args()
+	opArgs := args
+	if store {
+		opArgs = args[1:]
+	}
+
+	numKeys, errReply := parseInt(opArgs[0])
+	if errReply != nil {
+		return errReply
+	}
+	if numKeys <= 0 {
+		return handler.NewErrReply(fmt.Sprintf("ERR at least 1 input key is needed for '%s' command", cmd.Name()))
+	}
+	if numKeys > int64(len(opArgs)-1) {
+		return handler.NewSyntaxErrReply()
+	}
+
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	var (
+		aggregate  zsetAggregate
+		withScores bool
+	)
+	for i := 1 + int(numKeys); i < len(opArgs); i++ {
+		switch opt := strings.ToLower(string(opArgs[i])); {
+		case opt == "weights" && weighted && i+len(weights) < len(opArgs):
+			for j := range weights {
+				weight, ok := parseScore(opArgs[i+1+j])
+				if !ok {
+					return handler.NewErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += len(weights)
+		case opt == "aggregate" && weighted && i+1 < len(opArgs):
+			switch strings.ToLower(string(opArgs[i+1])) {
+			case "sum":
+				aggregate = zsetAggregateSum
+			case "min":
+				aggregate = zsetAggregateMin
+			case "max":
+				aggregate = zsetAggregateMax
+			default:
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+		case opt == "withscores" && !store:
+			withScores = true
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	// 源 key 的数量由 numkeys 决定，没有在指令表中声明，需要自行处理过期
+	sources := make([]zsetSource, 0, numKeys)
+	for _, key := range opArgs[1 : 1+numKeys] {
+		k.ExpirePreprocess(string(key))
+		v, ok := k.data[string(key)]
+		if !ok {
+			sources = append(sources, zsetSource{})
+			continue
+		}
+		switch v.(type) {
+		case SortedSet, Set:
+			sources = append(sources, newZSetSource(v))
+		default:
+			return handler.NewWrongTypeErrReply()
+		}
+	}
+
+	items := op(sources, weights, aggregate)
+	if !store {
+		return newZSetItemsReply(items, withScores)
+	}
+	return handler.NewIntReply(k.storeZSet(string(args[0]), items))
 }
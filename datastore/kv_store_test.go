@@ -0,0 +1,206 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+// 与 redis 默认值一致的紧凑编码阈值
+type testThinker struct{}
+
+func (testThinker) SetMaxIntsetEntries() int    { return 512 }
+func (testThinker) HashMaxListpackEntries() int { return 128 }
+func (testThinker) HashMaxListpackValue() int   { return 64 }
+func (testThinker) ZSetMaxListpackEntries() int { return 128 }
+func (testThinker) ZSetMaxListpackValue() int   { return 64 }
+
+var testThresholds = NewKVStore(testThinker{}).(*KVStore).thresholds
+
+func newTestCmd(cmdType database.CmdType, args ...string) *database.Command {
+	_args := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		_args = append(_args, []byte(arg))
+	}
+	return database.NewCommand(cmdType, _args)
+}
+
+func Test_kv_store_rename(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "src", "v", "ex", "100"))
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "dst", "old"))
+
+	reply := kvStore.Rename(newTestCmd(database.CmdTypeRename, "src", "dst"))
+	assert.Equal(t, handler.NewOKReply(), reply)
+
+	_, ok := kvStore.data["src"]
+	assert.False(t, ok)
+	_, ok = kvStore.expiredAt["src"]
+	assert.False(t, ok)
+	_, ok = kvStore.expiredAt["dst"]
+	assert.True(t, ok)
+
+	// 实体的 key 同步变更，保证 aof 重写正确
+	cmd := kvStore.data["dst"].(database.CmdAdapter).ToCmd()
+	assert.Equal(t, "dst", string(cmd[1]))
+	assert.Equal(t, "v", string(cmd[2]))
+
+	reply = kvStore.RenameNX(newTestCmd(database.CmdTypeRenameNX, "nope", "dst"))
+	assert.True(t, handler.IsErrReply(reply))
+}
+
+func Test_kv_store_copy(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "src", "a", "b"))
+
+	reply := kvStore.Copy(newTestCmd(database.CmdTypeCopy, "src", "dst"))
+	assert.Equal(t, handler.NewIntReply(1), reply)
+
+	// 深拷贝，互不影响
+	kvStore.SRem(newTestCmd(database.CmdTypeSRem, "src", "a"))
+	reply = kvStore.SIsMember(newTestCmd(database.CmdTypeSIsMember, "dst", "a"))
+	assert.Equal(t, handler.NewIntReply(1), reply)
+
+	reply = kvStore.Copy(newTestCmd(database.CmdTypeCopy, "src", "dst"))
+	assert.Equal(t, handler.NewIntReply(0), reply)
+
+	reply = kvStore.Del(newTestCmd(database.CmdTypeDel, "src", "dst", "nope"))
+	assert.Equal(t, handler.NewIntReply(2), reply)
+	assert.Empty(t, kvStore.data)
+}
+
+func Test_kv_store_expire(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v"))
+
+	assert.Equal(t, handler.NewIntReply(-1), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+	assert.Equal(t, handler.NewIntReply(-2), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "nope")))
+
+	t.Run("condition", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Expire(newTestCmd(database.CmdTypeExpire, "k", "100", "xx")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Expire(newTestCmd(database.CmdTypeExpire, "k", "100", "gt")))
+		assert.Equal(t, handler.NewIntReply(1), kvStore.Expire(newTestCmd(database.CmdTypeExpire, "k", "100", "nx")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Expire(newTestCmd(database.CmdTypeExpire, "k", "200", "lt")))
+		assert.Equal(t, handler.NewIntReply(1), kvStore.Expire(newTestCmd(database.CmdTypeExpire, "k", "200", "xx", "gt")))
+		assert.Equal(t, handler.NewIntReply(200), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+	})
+
+	t.Run("millisecond", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.PExpire(newTestCmd(database.CmdTypePExpire, "k", "1600")))
+		assert.Equal(t, handler.NewIntReply(2), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+		pttl := kvStore.PTTL(newTestCmd(database.CmdTypePTTL, "k")).(*handler.IntReply)
+		assert.True(t, pttl.Code > 1500 && pttl.Code <= 1600)
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.Persist(newTestCmd(database.CmdTypePersist, "k")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Persist(newTestCmd(database.CmdTypePersist, "k")))
+		assert.Equal(t, handler.NewIntReply(-1), kvStore.PTTL(newTestCmd(database.CmdTypePTTL, "k")))
+	})
+
+	t.Run("past", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.ExpireAt(newTestCmd(database.CmdTypeExpireAt, "k", "1")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "k")))
+	})
+}
+
+func Test_kv_store_set(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+
+	t.Run("condition", func(t *testing.T) {
+		assert.Equal(t, handler.NewNillReply(), kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v1", "xx")))
+		assert.Equal(t, handler.NewOKReply(), kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v1", "nx")))
+		assert.Equal(t, handler.NewNillReply(), kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v2", "nx")))
+		assert.Equal(t, handler.NewBulkReply([]byte("v1")), kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v2", "xx", "get")))
+		assert.Equal(t, handler.NewBulkReply([]byte("v2")), kvStore.Get(newTestCmd(database.CmdTypeGet, "k")))
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "px", "100000"))
+		assert.Equal(t, handler.NewIntReply(100), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "keepttl"))
+		assert.Equal(t, handler.NewIntReply(100), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+
+		// 覆盖写会清除原有的过期时间
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v"))
+		assert.Equal(t, handler.NewIntReply(-1), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "k")))
+	})
+
+	t.Run("syntax", func(t *testing.T) {
+		assert.True(t, handler.IsErrReply(kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "nx", "xx"))))
+		assert.True(t, handler.IsErrReply(kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "ex", "1", "px", "1"))))
+		assert.True(t, handler.IsErrReply(kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "keepttl", "exat", "1"))))
+		assert.True(t, handler.IsErrReply(kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "v", "ex", "0"))))
+	})
+}
+
+// 较小的紧凑编码阈值，便于触发编码转换
+type smallThinker struct {
+	testThinker
+}
+
+func (smallThinker) SetMaxIntsetEntries() int    { return 3 }
+func (smallThinker) HashMaxListpackEntries() int { return 3 }
+func (smallThinker) HashMaxListpackValue() int   { return 8 }
+func (smallThinker) ZSetMaxListpackEntries() int { return 3 }
+func (smallThinker) ZSetMaxListpackValue() int   { return 8 }
+
+func Test_kv_store_object_encoding(t *testing.T) {
+	kvStore := NewKVStore(smallThinker{}).(*KVStore)
+	encoding := func(key string) handler.Reply {
+		return kvStore.Object(newTestCmd(database.CmdTypeObject, "encoding", key))
+	}
+	expect := func(encoding string) handler.Reply {
+		return handler.NewBulkReply([]byte(encoding))
+	}
+
+	t.Run("string", func(t *testing.T) {
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "int", "12"))
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "str", "foo"))
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "raw", strings.Repeat("x", 45)))
+		assert.Equal(t, expect("int"), encoding("int"))
+		assert.Equal(t, expect("embstr"), encoding("str"))
+		assert.Equal(t, expect("raw"), encoding("raw"))
+		assert.Equal(t, handler.NewNillReply(), encoding("missing"))
+	})
+
+	t.Run("set", func(t *testing.T) {
+		kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "s", "3", "1", "2"))
+		assert.Equal(t, expect("intset"), encoding("s"))
+		kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "s", "4"))
+		assert.Equal(t, expect("hashtable"), encoding("s"))
+
+		kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "t", "1", "01"))
+		assert.Equal(t, expect("hashtable"), encoding("t"))
+		assert.Equal(t, handler.NewIntReply(2), kvStore.SCard(newTestCmd(database.CmdTypeSCard, "t")))
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", "1", "b", "2", "c", "3"))
+		assert.Equal(t, expect("listpack"), encoding("h"))
+		kvStore.HSet(newTestCmd(database.CmdTypeHSet, "h", "a", strings.Repeat("x", 9)))
+		assert.Equal(t, expect("hashtable"), encoding("h"))
+		assert.Equal(t, handler.NewBulkReply([]byte(strings.Repeat("x", 9))), kvStore.HGet(newTestCmd(database.CmdTypeHGet, "h", "a")))
+		assert.Equal(t, handler.NewBulkReply([]byte("3")), kvStore.HGet(newTestCmd(database.CmdTypeHGet, "h", "c")))
+	})
+
+	t.Run("zset", func(t *testing.T) {
+		kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "3", "c", "1", "a", "2", "b"))
+		assert.Equal(t, expect("listpack"), encoding("z"))
+		assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("a"), []byte("b")}), kvStore.ZRangeByScore(newTestCmd(database.CmdTypeZRangeByScore, "z", "0", "2")))
+		kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "4", "d"))
+		assert.Equal(t, expect("skiplist"), encoding("z"))
+		assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("a"), []byte("b")}), kvStore.ZRangeByScore(newTestCmd(database.CmdTypeZRangeByScore, "z", "0", "2")))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		kvStore.RPush(newTestCmd(database.CmdTypeRPush, "l", "a"))
+		assert.Equal(t, expect("quicklist"), encoding("l"))
+	})
+
+	assert.True(t, handler.IsErrReply(kvStore.Object(newTestCmd(database.CmdTypeObject, "freq", "s"))))
+}
@@ -1,6 +1,8 @@
 package datastore
 
 import (
+	"bytes"
+
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/handler"
 )
@@ -25,77 +27,513 @@ func (k *KVStore) putAsList(key string, list List) {
 
 type List interface {
 	LPush(value []byte)
+	// 弹出至多 cnt 个元素
 	LPop(cnt int64) [][]byte
 	RPush(value []byte)
 	RPop(cnt int64) [][]byte
 	Len() int64
+	// 下标均为闭区间，负数表示从尾部倒数，下同
 	Range(start, stop int64) [][]byte
-	database.CmdAdapter
+	Index(index int64) ([]byte, bool)
+	Set(index int64, value []byte) bool
+	// 在首个 pivot 的前方或后方插入元素，pivot 不存在时返回 false
+	Insert(pivot, value []byte, before bool) bool
+	// cnt 为正数时从头部开始删除至多 cnt 个元素，负数时从尾部开始，0 表示删除全部
+	Rem(cnt int64, value []byte) int64
+	// 仅保留 [start,stop] 范围内的元素
+	Trim(start, stop int64)
+	// 返回元素的下标. rank 为负数时从尾部开始查找，跳过前 |rank|-1 个匹配项.
+	// count 为 0 时返回全部匹配项，maxLen 为 0 时不限制比较的元素个数
+	Pos(value []byte, rank, count, maxLen int64) []int64
+	Entity
+}
+
+// 每个节点最多容纳的元素个数
+const quickListNodeSize = 128
+
+// 节点存储空间的初始容量. 存储空间按需倍增，避免小 list 占用过多内存
+const quickListNodeMinCap = 4
+
+// 分段存储的双端队列，与 redis 的 quicklist 类似. 由定长节点组成的双向链表，
+// 头尾的推入、弹出均为 O(1)，节点中的元素被全部弹出后，节点随即被回收
+type quickList struct {
+	key        string
+	head, tail *quickListNode
+	len        int64
+}
+
+type quickListNode struct {
+	prev, next *quickListNode
+	// 元素存放在 elements[start:end] 范围内
+	elements   [][]byte
+	start, end int
 }
 
-type listEntity struct {
-	key  string
-	data [][]byte
+func (n *quickListNode) size() int {
+	return n.end - n.start
+}
+
+// 存储空间是否已达到上限，无法继续扩容
+func (n *quickListNode) capped() bool {
+	return len(n.elements) == quickListNodeSize
+}
+
+// 倍增存储空间，不超过 quickListNodeSize. front 为 true 时在头部预留空间，否则在尾部预留
+func (n *quickListNode) grow(front bool) {
+	capacity := 2 * len(n.elements)
+	if capacity < quickListNodeMinCap {
+		capacity = quickListNodeMinCap
+	}
+	if capacity > quickListNodeSize {
+		capacity = quickListNodeSize
+	}
+
+	size := n.size()
+	elements := make([][]byte, capacity)
+	offset := 0
+	if front {
+		offset = capacity - size
+	}
+	copy(elements[offset:], n.elements[n.start:n.end])
+	n.elements, n.start, n.end = elements, offset, offset+size
 }
 
-func newListEntity(key string, elements ...[]byte) List {
-	return &listEntity{
-		key:  key,
-		data: elements,
+func newQuickList(key string, elements ...[]byte) List {
+	q := quickList{key: key}
+	for _, element := range elements {
+		q.RPush(element)
 	}
+	return &q
 }
 
-func (l *listEntity) LPush(value []byte) {
-	l.data = append([][]byte{value}, l.data...)
+func (q *quickList) LPush(value []byte) {
+	if q.head == nil || (q.head.start == 0 && q.head.capped()) {
+		q.linkAfter(nil, &quickListNode{})
+	}
+	if q.head.start == 0 {
+		// 头节点从尾部开始向前填充
+		q.head.grow(true)
+	}
+
+	q.head.start--
+	q.head.elements[q.head.start] = value
+	q.len++
 }
 
-func (l *listEntity) LPop(cnt int64) [][]byte {
-	if int64(len(l.data)) < cnt {
-		return nil
+func (q *quickList) LPop(cnt int64) [][]byte {
+	if cnt > q.len {
+		cnt = q.len
 	}
 
-	poped := l.data[:cnt]
-	l.data = l.data[cnt:]
+	poped := make([][]byte, 0, cnt)
+	for i := int64(0); i < cnt; i++ {
+		node := q.head
+		poped = append(poped, node.elements[node.start])
+		node.elements[node.start] = nil
+		node.start++
+		if node.size() == 0 {
+			q.unlink(node)
+		}
+	}
+	q.len -= cnt
 	return poped
 }
 
-func (l *listEntity) RPush(value []byte) {
-	l.data = append(l.data, value)
+func (q *quickList) RPush(value []byte) {
+	if q.tail == nil || (q.tail.end == len(q.tail.elements) && q.tail.capped()) {
+		q.linkAfter(q.tail, &quickListNode{})
+	}
+	if q.tail.end == len(q.tail.elements) {
+		q.tail.grow(false)
+	}
+
+	q.tail.elements[q.tail.end] = value
+	q.tail.end++
+	q.len++
 }
 
-func (l *listEntity) RPop(cnt int64) [][]byte {
-	if int64(len(l.data)) < cnt {
-		return nil
+func (q *quickList) RPop(cnt int64) [][]byte {
+	if cnt > q.len {
+		cnt = q.len
 	}
 
-	poped := l.data[int64(len(l.data))-cnt:]
-	l.data = l.data[:int64(len(l.data))-cnt]
+	// 按照弹出的顺序返回
+	poped := make([][]byte, 0, cnt)
+	for i := int64(0); i < cnt; i++ {
+		node := q.tail
+		node.end--
+		poped = append(poped, node.elements[node.end])
+		node.elements[node.end] = nil
+		if node.size() == 0 {
+			q.unlink(node)
+		}
+	}
+	q.len -= cnt
 	return poped
 }
 
-func (l *listEntity) Len() int64 {
-	return int64(len(l.data))
+func (q *quickList) Len() int64 {
+	return q.len
 }
 
-func (l *listEntity) Range(start, stop int64) [][]byte {
-	if stop == -1 {
-		stop = int64(len(l.data) - 1)
+func (q *quickList) Range(start, stop int64) [][]byte {
+	start, stop, ok := normalizeRange(start, stop, q.len)
+	if !ok {
+		return [][]byte{}
 	}
 
-	if start < 0 || start >= int64(len(l.data)) {
-		return nil
+	res := make([][]byte, 0, stop-start+1)
+	q.forEach(start, func(_ int64, element []byte) bool {
+		res = append(res, element)
+		return int64(len(res)) <= stop-start
+	})
+	return res
+}
+
+func (q *quickList) Index(index int64) ([]byte, bool) {
+	node, slot, ok := q.locate(index)
+	if !ok {
+		return nil, false
+	}
+	return node.elements[slot], true
+}
+
+func (q *quickList) Set(index int64, value []byte) bool {
+	node, slot, ok := q.locate(index)
+	if !ok {
+		return false
+	}
+	node.elements[slot] = value
+	return true
+}
+
+func (q *quickList) Insert(pivot, value []byte, before bool) bool {
+	index := int64(-1)
+	q.forEach(0, func(i int64, element []byte) bool {
+		if bytes.Equal(element, pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index == -1 {
+		return false
+	}
+
+	if !before {
+		index++
+	}
+	q.insert(index, value)
+	return true
+}
+
+func (q *quickList) Rem(cnt int64, value []byte) int64 {
+	limit := cnt
+	if limit < 0 {
+		limit = -limit
+	}
+
+	var removed int64
+	remove := func(element []byte) bool {
+		if (limit > 0 && removed >= limit) || !bytes.Equal(element, value) {
+			return false
+		}
+		removed++
+		return true
+	}
+
+	if cnt >= 0 {
+		for node := q.head; node != nil && (limit == 0 || removed < limit); {
+			next := node.next
+			q.compact(node, false, remove)
+			node = next
+		}
+	} else {
+		for node := q.tail; node != nil && removed < limit; {
+			prev := node.prev
+			q.compact(node, true, remove)
+			node = prev
+		}
+	}
+
+	q.len -= removed
+	return removed
+}
+
+func (q *quickList) Trim(start, stop int64) {
+	start, stop, ok := normalizeRange(start, stop, q.len)
+	if !ok {
+		q.head, q.tail, q.len = nil, nil, 0
+		return
+	}
+
+	q.dropTail(q.len - 1 - stop)
+	q.dropHead(start)
+}
+
+func (q *quickList) Pos(value []byte, rank, count, maxLen int64) []int64 {
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+
+	res := make([]int64, 0, 1)
+	var scanned int64
+	match := func(index int64, element []byte) bool {
+		if maxLen > 0 && scanned >= maxLen {
+			return false
+		}
+		scanned++
+
+		if !bytes.Equal(element, value) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		res = append(res, index)
+		return count == 0 || int64(len(res)) < count
 	}
 
-	if stop < 0 || stop >= int64(len(l.data)) || stop < start {
-		return nil
+	if rank > 0 {
+		q.forEach(0, match)
+	} else {
+		q.forEachReverse(match)
 	}
+	return res
+}
+
+// 在下标 index 处插入元素，原有的元素后移
+func (q *quickList) insert(index int64, value []byte) {
+	if index == 0 {
+		q.LPush(value)
+		return
+	}
+	if index == q.len {
+		q.RPush(value)
+		return
+	}
+
+	node, slot, _ := q.locate(index)
+	if node.size() == quickListNodeSize {
+		// 节点已满时，拆分为两个节点
+		q.split(node)
+		node, slot, _ = q.locate(index)
+	}
+
+	// 存储空间已满时扩容，扩容后元素的位置不变
+	if node.start == 0 && node.end == len(node.elements) {
+		node.grow(false)
+	}
+
+	if node.end < len(node.elements) {
+		copy(node.elements[slot+1:node.end+1], node.elements[slot:node.end])
+		node.elements[slot] = value
+		node.end++
+	} else {
+		copy(node.elements[node.start-1:slot-1], node.elements[node.start:slot])
+		node.elements[slot-1] = value
+		node.start--
+	}
+	q.len++
+}
+
+// 将节点后半部分的元素迁移到新节点中
+func (q *quickList) split(node *quickListNode) {
+	mid := (node.start + node.end) / 2
+	next := quickListNode{elements: append([][]byte{}, node.elements[mid:node.end]...), end: node.end - mid}
+	for i := mid; i < node.end; i++ {
+		node.elements[i] = nil
+	}
+	node.end = mid
+	q.linkAfter(node, &next)
+}
+
+// 删除节点中满足 remove 的元素. reverse 为 true 时从尾部开始判断，剩余元素向尾部聚拢
+func (q *quickList) compact(node *quickListNode, reverse bool, remove func(element []byte) bool) {
+	if !reverse {
+		w := node.start
+		for r := node.start; r < node.end; r++ {
+			if !remove(node.elements[r]) {
+				node.elements[w] = node.elements[r]
+				w++
+			}
+		}
+		for i := w; i < node.end; i++ {
+			node.elements[i] = nil
+		}
+		node.end = w
+	} else {
+		w := node.end - 1
+		for r := node.end - 1; r >= node.start; r-- {
+			if !remove(node.elements[r]) {
+				node.elements[w] = node.elements[r]
+				w--
+			}
+		}
+		for i := node.start; i <= w; i++ {
+			node.elements[i] = nil
+		}
+		node.start = w + 1
+	}
+
+	if node.size() == 0 {
+		q.unlink(node)
+	}
+}
+
+// 丢弃头部的 cnt 个元素
+func (q *quickList) dropHead(cnt int64) {
+	q.len -= cnt
+	for cnt > 0 {
+		node := q.head
+		if n := int64(node.size()); n <= cnt {
+			q.unlink(node)
+			cnt -= n
+			continue
+		}
+		for i := node.start; i < node.start+int(cnt); i++ {
+			node.elements[i] = nil
+		}
+		node.start += int(cnt)
+		cnt = 0
+	}
+}
+
+// 丢弃尾部的 cnt 个元素
+func (q *quickList) dropTail(cnt int64) {
+	q.len -= cnt
+	for cnt > 0 {
+		node := q.tail
+		if n := int64(node.size()); n <= cnt {
+			q.unlink(node)
+			cnt -= n
+			continue
+		}
+		for i := node.end - int(cnt); i < node.end; i++ {
+			node.elements[i] = nil
+		}
+		node.end -= int(cnt)
+		cnt = 0
+	}
+}
+
+// 获取下标对应的节点以及元素在节点中的位置，从距离较近的一端开始查找
+func (q *quickList) locate(index int64) (*quickListNode, int, bool) {
+	if index < 0 {
+		index += q.len
+	}
+	if index < 0 || index >= q.len {
+		return nil, 0, false
+	}
+
+	if index < q.len/2 {
+		node := q.head
+		for index >= int64(node.size()) {
+			index -= int64(node.size())
+			node = node.next
+		}
+		return node, node.start + int(index), true
+	}
+
+	node := q.tail
+	index = q.len - 1 - index
+	for index >= int64(node.size()) {
+		index -= int64(node.size())
+		node = node.prev
+	}
+	return node, node.end - 1 - int(index), true
+}
+
+// 从下标 start 处开始正序遍历，f 返回 false 时终止
+func (q *quickList) forEach(start int64, f func(index int64, element []byte) bool) {
+	index := start
+	node := q.head
+	for node != nil && start >= int64(node.size()) {
+		start -= int64(node.size())
+		node = node.next
+	}
+
+	for ; node != nil; node = node.next {
+		for i := node.start + int(start); i < node.end; i++ {
+			if !f(index, node.elements[i]) {
+				return
+			}
+			index++
+		}
+		start = 0
+	}
+}
+
+// 从尾部开始逆序遍历，f 返回 false 时终止
+func (q *quickList) forEachReverse(f func(index int64, element []byte) bool) {
+	index := q.len - 1
+	for node := q.tail; node != nil; node = node.prev {
+		for i := node.end - 1; i >= node.start; i-- {
+			if !f(index, node.elements[i]) {
+				return
+			}
+			index--
+		}
+	}
+}
+
+// 将 node 链接到 prev 之后，prev 为 nil 时作为头节点
+func (q *quickList) linkAfter(prev, node *quickListNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = q.head
+		q.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+
+	if node.next == nil {
+		q.tail = node
+	} else {
+		node.next.prev = node
+	}
+}
+
+func (q *quickList) unlink(node *quickListNode) {
+	if node.prev == nil {
+		q.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+	if node.next == nil {
+		q.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+	node.prev, node.next = nil, nil
+}
+
+func (q *quickList) Rename(key string) {
+	q.key = key
+}
+
+func (q *quickList) Clone(key string) Entity {
+	clone := quickList{key: key}
+	q.forEach(0, func(_ int64, element []byte) bool {
+		clone.RPush(element)
+		return true
+	})
+	return &clone
+}
 
-	return l.data[start : stop+1]
+func (q *quickList) Encoding() string {
+	return "quicklist"
 }
 
-func (l *listEntity) ToCmd() [][]byte {
-	args := make([][]byte, 0, 2+l.Len())
-	args = append(args, []byte(database.CmdTypeRPush), []byte(l.key))
-	args = append(args, l.data...)
+func (q *quickList) ToCmd() [][]byte {
+	args := make([][]byte, 0, 2+q.Len())
+	args = append(args, []byte(database.CmdTypeRPush), []byte(q.key))
+	q.forEach(0, func(_ int64, element []byte) bool {
+		args = append(args, element)
+		return true
+	})
 	return args
 }
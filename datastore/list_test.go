@@ -8,11 +8,12 @@ import (
 	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
 	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
 func Test_list_crud(t *testing.T) {
-	list := newListEntity("")
+	list := newQuickList("")
 	l := make([][]byte, 0, 1000)
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
 	for i := 0; i < 1000; i++ {
@@ -41,8 +42,9 @@ func Test_list_crud(t *testing.T) {
 			l = l[2:]
 			assert.Equal(t, expect, actual)
 
+			// 按照弹出的顺序返回
 			actual = list.RPop(2)
-			expect = l[len(l)-2:]
+			expect = [][]byte{l[len(l)-1], l[len(l)-2]}
 			l = l[:len(l)-2]
 			assert.Equal(t, expect, actual)
 		}
@@ -50,7 +52,7 @@ func Test_list_crud(t *testing.T) {
 }
 
 func Test_list_to_cmds(t *testing.T) {
-	list := newListEntity("")
+	list := newQuickList("")
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
 	l := make([]int, 0, 1000)
 	// 插入1000条数据
@@ -88,3 +90,288 @@ func Test_list_to_cmds(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
 }
+
+func Test_list_quicklist_nodes(t *testing.T) {
+	list := newQuickList("").(*quickList)
+	for i := 0; i < 3*quickListNodeSize; i++ {
+		list.LPush([]byte(cast.ToString(i)))
+	}
+	assert.Equal(t, []byte(cast.ToString(3*quickListNodeSize-1)), list.Range(0, 0)[0])
+	assert.Equal(t, []byte("0"), list.Range(-1, -1)[0])
+
+	// 弹出的元素所在节点被回收
+	poped := list.RPop(2*quickListNodeSize + 1)
+	assert.Equal(t, 2*quickListNodeSize+1, len(poped))
+	assert.Equal(t, []byte("0"), poped[0])
+	assert.Equal(t, []byte(cast.ToString(2*quickListNodeSize)), poped[2*quickListNodeSize])
+	assert.Equal(t, list.head, list.tail)
+	assert.Equal(t, int64(quickListNodeSize-1), list.Len())
+
+	// 个数超出长度时，弹出全部元素
+	assert.Equal(t, quickListNodeSize-1, len(list.LPop(quickListNodeSize)))
+	assert.Nil(t, list.head)
+	assert.Nil(t, list.tail)
+
+	list.RPush([]byte("a"))
+	list.LPush([]byte("b"))
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("a")}, list.Range(0, -1))
+	// 小 list 的存储空间按需分配，头尾推入共用同一个节点
+	assert.Equal(t, list.head, list.tail)
+	assert.LessOrEqual(t, len(list.head.elements), 2*quickListNodeMinCap)
+
+	// 向已满的节点中插入元素时，节点被拆分
+	expect := make([][]byte, 0, 2*quickListNodeSize)
+	for i := 0; i < 2*quickListNodeSize; i++ {
+		list.RPush([]byte(cast.ToString(i)))
+		expect = append(expect, []byte(cast.ToString(i)))
+	}
+	expect = append(expect[:2], expect...)
+	expect[0], expect[1] = []byte("b"), []byte("a")
+	for _, i := range []int{3, quickListNodeSize + 3, 10} {
+		assert.True(t, list.Insert([]byte(cast.ToString(i)), []byte("x"), true))
+		index := 0
+		for string(expect[index]) != cast.ToString(i) {
+			index++
+		}
+		expect = append(expect[:index], append([][]byte{[]byte("x")}, expect[index:]...)...)
+	}
+	assert.Equal(t, expect, list.Range(0, -1))
+}
+
+// 与基于切片的实现进行比对
+func Test_list_ops(t *testing.T) {
+	list := newQuickList("")
+	l := make([][]byte, 0)
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	randValue := func() []byte {
+		return []byte(cast.ToString(rander.Intn(50)))
+	}
+
+	for i := 0; i < 5000; i++ {
+		switch op := rander.Intn(10); {
+		case op < 3:
+			value := randValue()
+			list.RPush(value)
+			l = append(l, value)
+		case op < 5:
+			pivot, value, before := randValue(), randValue(), rander.Intn(2) == 0
+			index := -1
+			for j := range l {
+				if string(l[j]) == string(pivot) {
+					index = j
+					break
+				}
+			}
+			assert.Equal(t, index != -1, list.Insert(pivot, value, before))
+			if index == -1 {
+				continue
+			}
+			if !before {
+				index++
+			}
+			l = append(l[:index], append([][]byte{value}, l[index:]...)...)
+		case op < 6:
+			value, cnt := randValue(), int64(rander.Intn(5)-2)
+			var removed int64
+			expect := make([][]byte, 0, len(l))
+			if cnt >= 0 {
+				for _, element := range l {
+					if string(element) == string(value) && (cnt == 0 || removed < cnt) {
+						removed++
+						continue
+					}
+					expect = append(expect, element)
+				}
+			} else {
+				for j := len(l) - 1; j >= 0; j-- {
+					if string(l[j]) == string(value) && removed < -cnt {
+						removed++
+						continue
+					}
+					expect = append([][]byte{l[j]}, expect...)
+				}
+			}
+			assert.Equal(t, removed, list.Rem(cnt, value))
+			l = expect
+		case op < 7:
+			if len(l) == 0 {
+				continue
+			}
+			index := rander.Intn(len(l))
+			value := randValue()
+			assert.True(t, list.Set(int64(index-len(l)), value))
+			l[index] = value
+		case op < 8:
+			start, stop := int64(rander.Intn(7)-1), int64(-rander.Intn(5)-1)
+			list.Trim(start, stop)
+			if s, e, ok := normalizeRange(start, stop, int64(len(l))); ok {
+				l = l[s : e+1]
+			} else {
+				l = l[:0]
+			}
+		default:
+			value := randValue()
+			var expect []int64
+			for j := len(l) - 1; j >= 0; j-- {
+				if string(l[j]) == string(value) {
+					expect = append(expect, int64(j))
+				}
+			}
+			positions := list.Pos(value, -1, 0, 0)
+			assert.Equal(t, len(expect), len(positions))
+			if len(expect) > 1 {
+				assert.Equal(t, expect[1:2], list.Pos(value, -2, 1, 0))
+			}
+		}
+
+		assert.Equal(t, int64(len(l)), list.Len())
+		assert.Equal(t, l, list.Range(0, -1))
+		if len(l) > 0 {
+			element, ok := list.Index(-1)
+			assert.True(t, ok)
+			assert.Equal(t, l[len(l)-1], element)
+		}
+	}
+}
+
+func Test_list_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.RPush(newTestCmd(database.CmdTypeRPush, "l", "a", "b", "c", "b"))
+
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("b"), []byte("c")}), kvStore.LRange(newTestCmd(database.CmdTypeLRange, "l", "-3", "-2")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.LPos(newTestCmd(database.CmdTypeLPos, "l", "b")))
+	assert.Equal(t, handler.NewArrayReply([]handler.Reply{handler.NewIntReply(3), handler.NewIntReply(1)}),
+		kvStore.LPos(newTestCmd(database.CmdTypeLPos, "l", "b", "rank", "-1", "count", "0")))
+	assert.Equal(t, handler.NewNillReply(), kvStore.LPos(newTestCmd(database.CmdTypeLPos, "l", "b", "maxlen", "1")))
+	// 未知选项返回语法错误，而不是数值错误
+	assert.Equal(t, handler.NewSyntaxErrReply(), kvStore.LPos(newTestCmd(database.CmdTypeLPos, "l", "b", "foo", "bar")))
+	assert.Equal(t, handler.NewErrReply("ERR value is not an integer or out of range"), kvStore.LPos(newTestCmd(database.CmdTypeLPos, "l", "b", "rank", "x")))
+
+	assert.Equal(t, handler.NewBulkReply([]byte("b")), kvStore.LMove(newTestCmd(database.CmdTypeLMove, "l", "l2", "right", "left")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.LPushX(newTestCmd(database.CmdTypeLPushX, "l3", "x")))
+	assert.Equal(t, handler.NewIntReply(2), kvStore.RPushX(newTestCmd(database.CmdTypeRPushX, "l2", "x")))
+
+	// 个数超出长度时，返回剩余的全部元素，list 随即被删除
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("c"), []byte("b"), []byte("a")}), kvStore.RPop(newTestCmd(database.CmdTypeRPop, "l", "10")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "l")))
+	assert.Equal(t, handler.NewNillMultiBulkReply(), kvStore.LPop(newTestCmd(database.CmdTypeLPop, "l", "1")))
+	assert.Equal(t, handler.NewNillReply(), kvStore.LPop(newTestCmd(database.CmdTypeLPop, "l")))
+	assert.True(t, handler.IsErrReply(kvStore.LPop(newTestCmd(database.CmdTypeLPop, "l2", "-1"))))
+
+	assert.True(t, handler.IsErrReply(kvStore.LSet(newTestCmd(database.CmdTypeLSet, "l2", "2", "y"))))
+	assert.Equal(t, handler.NewOKReply(), kvStore.LSet(newTestCmd(database.CmdTypeLSet, "l2", "-1", "y")))
+	assert.Equal(t, handler.NewIntReply(-1), kvStore.LInsert(newTestCmd(database.CmdTypeLInsert, "l2", "before", "z", "a")))
+	assert.Equal(t, handler.NewIntReply(3), kvStore.LInsert(newTestCmd(database.CmdTypeLInsert, "l2", "after", "b", "a")))
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("b"), []byte("a"), []byte("y")}), kvStore.LRange(newTestCmd(database.CmdTypeLRange, "l2", "0", "-1")))
+
+	assert.Equal(t, handler.NewOKReply(), kvStore.LTrim(newTestCmd(database.CmdTypeLTrim, "l2", "5", "10")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.LLen(newTestCmd(database.CmdTypeLLen, "l2")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "l2")))
+}
+
+// 替换前基于切片实现的 list，作为性能对比的基准
+type sliceList struct {
+	data [][]byte
+}
+
+func (l *sliceList) LPush(value []byte) {
+	l.data = append([][]byte{value}, l.data...)
+}
+
+func (l *sliceList) LPop(cnt int64) [][]byte {
+	poped := l.data[:cnt]
+	l.data = l.data[cnt:]
+	return poped
+}
+
+func (l *sliceList) RPush(value []byte) {
+	l.data = append(l.data, value)
+}
+
+func (l *sliceList) RPop(cnt int64) [][]byte {
+	poped := l.data[int64(len(l.data))-cnt:]
+	l.data = l.data[:int64(len(l.data))-cnt]
+	return poped
+}
+
+type benchList interface {
+	LPush(value []byte)
+	LPop(cnt int64) [][]byte
+	RPush(value []byte)
+	RPop(cnt int64) [][]byte
+}
+
+func benchmarkList(b *testing.B, newList func() benchList) {
+	value := []byte("value")
+	b.Run("lpush", func(b *testing.B) {
+		list := newList()
+		for i := 0; i < b.N; i++ {
+			list.LPush(value)
+		}
+	})
+
+	b.Run("rpush", func(b *testing.B) {
+		list := newList()
+		for i := 0; i < b.N; i++ {
+			list.RPush(value)
+		}
+	})
+
+	// 小 list 场景，关注每个 list 的内存分配
+	b.Run("small", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			list := newList()
+			list.LPush(value)
+			list.RPush(value)
+		}
+	})
+
+	// 队列场景，头部推入，尾部弹出
+	b.Run("queue", func(b *testing.B) {
+		list := newList()
+		for i := 0; i < 1000; i++ {
+			list.LPush(value)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			list.LPush(value)
+			list.RPop(1)
+		}
+	})
+}
+
+func Benchmark_list_quicklist(b *testing.B) {
+	benchmarkList(b, func() benchList {
+		return newQuickList("")
+	})
+}
+
+func Benchmark_list_slice(b *testing.B) {
+	benchmarkList(b, func() benchList {
+		return &sliceList{}
+	})
+}
+
+func Test_list_blocking_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.RPush(newTestCmd(database.CmdTypeRPush, "l", "a", "b"))
+
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("l"), []byte("b")}), kvStore.BRPop(newTestCmd(database.CmdTypeBRPop, "x", "l", "0")))
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("l"), []byte("a")}), kvStore.BLPop(newTestCmd(database.CmdTypeBLPop, "l", "0.5")))
+	// list 为空时被删除，指令进入阻塞
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "l")))
+	assert.Nil(t, kvStore.BLPop(newTestCmd(database.CmdTypeBLPop, "l", "0")))
+
+	kvStore.RPush(newTestCmd(database.CmdTypeRPush, "src", "a", "b"))
+	assert.Equal(t, handler.NewBulkReply([]byte("b")), kvStore.BRPopLPush(newTestCmd(database.CmdTypeBRPopLPush, "src", "dst", "0")))
+	assert.Equal(t, handler.NewBulkReply([]byte("a")), kvStore.BLMove(newTestCmd(database.CmdTypeBLMove, "src", "dst", "left", "right", "0")))
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("b"), []byte("a")}), kvStore.LRange(newTestCmd(database.CmdTypeLRange, "dst", "0", "-1")))
+	assert.Nil(t, kvStore.BLMove(newTestCmd(database.CmdTypeBLMove, "src", "dst", "left", "right", "1")))
+
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "s", "foo"))
+	assert.True(t, handler.IsErrReply(kvStore.BLPop(newTestCmd(database.CmdTypeBLPop, "x", "s", "0"))))
+	assert.True(t, handler.IsErrReply(kvStore.BLPop(newTestCmd(database.CmdTypeBLPop, "l", "-1"))))
+	assert.True(t, handler.IsErrReply(kvStore.BLPop(newTestCmd(database.CmdTypeBLPop, "l", "foo"))))
+	assert.True(t, handler.IsErrReply(kvStore.BLMove(newTestCmd(database.CmdTypeBLMove, "src", "dst", "up", "right", "0"))))
+}
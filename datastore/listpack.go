@@ -0,0 +1,86 @@
+package datastore
+
+import "encoding/binary"
+
+// 紧凑列表. 元素依次存放在连续的内存中，每个元素编码为 uvarint 长度前缀 + 内容.
+// 查找与修改均需要线性遍历，仅适用于元素数量较少的场景. 返回的元素引用了底层内存，修改 listpack 后不再有效
+type listpack struct {
+	data []byte
+	size int
+}
+
+func newListpack() *listpack {
+	return &listpack{}
+}
+
+func (l *listpack) Len() int {
+	return l.size
+}
+
+// 解析 offset 处的元素，返回元素内容以及下一个元素的偏移量
+func (l *listpack) entry(offset int) ([]byte, int) {
+	n, width := binary.Uvarint(l.data[offset:])
+	start := offset + width
+	end := start + int(n)
+	return l.data[start:end:end], end
+}
+
+// 依次遍历元素，f 返回 false 时终止遍历. offset 为元素在 listpack 中的偏移量
+func (l *listpack) ForEach(f func(offset int, entry []byte) bool) {
+	for offset := 0; offset < len(l.data); {
+		entry, next := l.entry(offset)
+		if !f(offset, entry) {
+			return
+		}
+		offset = next
+	}
+}
+
+// 按照 <first, second> 成对遍历元素
+func (l *listpack) ForEachPair(f func(offset int, first, second []byte) bool) {
+	for offset := 0; offset < len(l.data); {
+		first, next := l.entry(offset)
+		second, next := l.entry(next)
+		if !f(offset, first, second) {
+			return
+		}
+		offset = next
+	}
+}
+
+// 在 offset 处插入元素，offset 为 -1 时追加到末尾
+func (l *listpack) Insert(offset int, entries ...[]byte) {
+	var encoded []byte
+	for _, entry := range entries {
+		encoded = binary.AppendUvarint(encoded, uint64(len(entry)))
+		encoded = append(encoded, entry...)
+	}
+
+	if offset < 0 {
+		offset = len(l.data)
+	}
+	l.data = append(l.data, encoded...)
+	copy(l.data[offset+len(encoded):], l.data[offset:len(l.data)-len(encoded)])
+	copy(l.data[offset:], encoded)
+	l.size += len(entries)
+}
+
+// 删除从 offset 开始的 cnt 个元素
+func (l *listpack) Delete(offset, cnt int) {
+	end := offset
+	for i := 0; i < cnt; i++ {
+		_, end = l.entry(end)
+	}
+	l.data = append(l.data[:offset], l.data[end:]...)
+	l.size -= cnt
+}
+
+// 替换 offset 处的元素
+func (l *listpack) Replace(offset int, entry []byte) {
+	l.Delete(offset, 1)
+	l.Insert(offset, entry)
+}
+
+func (l *listpack) Clone() *listpack {
+	return &listpack{data: append([]byte{}, l.data...), size: l.size}
+}
@@ -0,0 +1,53 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_listpack_ops(t *testing.T) {
+	lp := newListpack()
+	entries := func() []string {
+		res := make([]string, 0, lp.Len())
+		lp.ForEach(func(_ int, entry []byte) bool {
+			res = append(res, string(entry))
+			return true
+		})
+		return res
+	}
+	offsetOf := func(target string) int {
+		res := -1
+		lp.ForEach(func(offset int, entry []byte) bool {
+			if string(entry) == target {
+				res = offset
+				return false
+			}
+			return true
+		})
+		return res
+	}
+
+	long := strings.Repeat("x", 300)
+	lp.Insert(-1, []byte("a"), []byte(""), []byte(long))
+	lp.Insert(0, []byte("head"))
+	assert.Equal(t, []string{"head", "a", "", long}, entries())
+
+	lp.Insert(offsetOf(long), []byte("b"))
+	assert.Equal(t, []string{"head", "a", "", "b", long}, entries())
+
+	lp.Replace(offsetOf("a"), []byte(long))
+	lp.Delete(offsetOf("b"), 2)
+	assert.Equal(t, []string{"head", long, ""}, entries())
+	assert.Equal(t, 3, lp.Len())
+
+	pairs := make([]string, 0)
+	cloned := lp.Clone()
+	lp.Delete(0, 1)
+	cloned.ForEachPair(func(_ int, first, second []byte) bool {
+		pairs = append(pairs, string(first)+"="+string(second))
+		return false
+	})
+	assert.Equal(t, []string{"head=" + long}, pairs)
+}
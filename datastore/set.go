@@ -1,8 +1,13 @@
 package datastore
 
 import (
+	"math/rand"
+	"sort"
+	"strconv"
+
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/handler"
+	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
 func (k *KVStore) getAsSet(key string) (Set, error) {
@@ -23,34 +28,116 @@ func (k *KVStore) putAsSet(key string, set Set) {
 	k.data[key] = set
 }
 
+func (k *KVStore) delIfEmptySet(key string, set Set) {
+	if set.Len() == 0 {
+		k.del(key)
+	}
+}
+
 type Set interface {
 	Add(value string) int64
 	Exist(value string) int64
 	Rem(value string) int64
-	database.CmdAdapter
+	Len() int64
+	Members() []string
+	// 遍历全部元素，f 返回 false 时终止遍历
+	ForEach(f func(member string) bool)
+	// 随机返回 count 个元素. distinct 为 true 时元素互不重复，至多返回全部元素
+	RandMembers(count int64, distinct bool) []string
+	// 随机弹出至多 count 个元素
+	Pop(count int64) []string
+	Entity
 }
 
+// datastore 仅在 executor 的 goroutine 中访问，无需加锁
+var entityRander = rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+
+// 从 [0, size) 中随机选取 count 个下标. distinct 为 true 时下标互不重复，count 不小于 size 时按序返回全部下标
+func randIndexes(size, count int64, distinct bool) []int64 {
+	if size == 0 || count <= 0 {
+		return []int64{}
+	}
+
+	if !distinct {
+		indexes := make([]int64, 0, count)
+		for i := int64(0); i < count; i++ {
+			indexes = append(indexes, entityRander.Int63n(size))
+		}
+		return indexes
+	}
+
+	if count >= size {
+		indexes := make([]int64, 0, size)
+		for i := int64(0); i < size; i++ {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+
+	// 在下标的虚拟副本上执行前 count 轮 Fisher-Yates 洗牌，swapped 记录被交换过的位置，耗时为 O(count)
+	indexes := make([]int64, 0, count)
+	swapped := make(map[int64]int64, count)
+	at := func(i int64) int64 {
+		if index, ok := swapped[i]; ok {
+			return index
+		}
+		return i
+	}
+	for i := int64(0); i < count; i++ {
+		j := i + entityRander.Int63n(size-i)
+		index := at(j)
+		swapped[j] = at(i)
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// 元素均为整数且数量不超过 set-max-intset-entries 时采用 intset 编码，否则转为 hashtable 编码.
+// hashtable 编码下元素平铺在 members 中，container 记录元素在 members 中的下标，以便在 O(1) 时间内随机选取和删除元素
 type setEntity struct {
-	key       string
-	container map[string]struct{}
+	key        string
+	intset     *intset
+	container  map[string]int
+	members    []string
+	thresholds *encodingThresholds
 }
 
-func newSetEntity(key string) Set {
+func newSetEntity(key string, thresholds *encodingThresholds) Set {
 	return &setEntity{
-		key:       key,
-		container: make(map[string]struct{}),
+		key:        key,
+		intset:     newIntset(),
+		thresholds: thresholds,
 	}
 }
 
 func (s *setEntity) Add(value string) int64 {
+	if s.intset != nil {
+		v, ok := parseIntsetValue(value)
+		if ok && (s.intset.Len() < s.thresholds.setMaxIntsetEntries || s.intset.Contains(v)) {
+			if s.intset.Add(v) {
+				return 1
+			}
+			return 0
+		}
+		s.convert()
+	}
+
 	if _, ok := s.container[value]; ok {
 		return 0
 	}
-	s.container[value] = struct{}{}
+	s.container[value] = len(s.members)
+	s.members = append(s.members, value)
 	return 1
 }
 
 func (s *setEntity) Exist(value string) int64 {
+	if s.intset != nil {
+		if v, ok := parseIntsetValue(value); ok && s.intset.Contains(v) {
+			return 1
+		}
+		return 0
+	}
+
 	if _, ok := s.container[value]; ok {
 		return 1
 	}
@@ -58,19 +145,192 @@ func (s *setEntity) Exist(value string) int64 {
 }
 
 func (s *setEntity) Rem(value string) int64 {
-	if _, ok := s.container[value]; ok {
-		delete(s.container, value)
-		return 1
+	if s.intset != nil {
+		if v, ok := parseIntsetValue(value); ok && s.intset.Remove(v) {
+			return 1
+		}
+		return 0
 	}
-	return 0
+
+	index, ok := s.container[value]
+	if !ok {
+		return 0
+	}
+
+	// 将末尾元素移动到被删除元素的位置
+	last := s.members[len(s.members)-1]
+	s.members[index] = last
+	s.container[last] = index
+	s.members[len(s.members)-1] = ""
+	s.members = s.members[:len(s.members)-1]
+	delete(s.container, value)
+	return 1
+}
+
+// intset 转为 hashtable 编码
+func (s *setEntity) convert() {
+	s.container = make(map[string]int, s.intset.Len())
+	s.members = make([]string, 0, s.intset.Len())
+	for i := 0; i < s.intset.Len(); i++ {
+		member := strconv.FormatInt(s.intset.Get(i), 10)
+		s.container[member] = i
+		s.members = append(s.members, member)
+	}
+	s.intset = nil
+}
+
+func (s *setEntity) Len() int64 {
+	if s.intset != nil {
+		return int64(s.intset.Len())
+	}
+	return int64(len(s.members))
+}
+
+// 第 i 个元素，intset 编码下元素有序
+func (s *setEntity) at(i int64) string {
+	if s.intset != nil {
+		return strconv.FormatInt(s.intset.Get(int(i)), 10)
+	}
+	return s.members[i]
+}
+
+func (s *setEntity) Members() []string {
+	members := make([]string, 0, s.Len())
+	s.ForEach(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+func (s *setEntity) ForEach(f func(member string) bool) {
+	for i := int64(0); i < s.Len(); i++ {
+		if !f(s.at(i)) {
+			return
+		}
+	}
+}
+
+func (s *setEntity) RandMembers(count int64, distinct bool) []string {
+	indexes := randIndexes(s.Len(), count, distinct)
+	members := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		members = append(members, s.at(index))
+	}
+	return members
+}
+
+func (s *setEntity) Pop(count int64) []string {
+	if count > s.Len() {
+		count = s.Len()
+	}
+
+	poped := make([]string, 0, count)
+	for i := int64(0); i < count; i++ {
+		member := s.at(entityRander.Int63n(s.Len()))
+		s.Rem(member)
+		poped = append(poped, member)
+	}
+	return poped
+}
+
+func (s *setEntity) Rename(key string) {
+	s.key = key
+}
+
+func (s *setEntity) Clone(key string) Entity {
+	cloned := setEntity{key: key, thresholds: s.thresholds}
+	if s.intset != nil {
+		cloned.intset = s.intset.Clone()
+		return &cloned
+	}
+
+	cloned.container = make(map[string]int, len(s.container))
+	for member, index := range s.container {
+		cloned.container[member] = index
+	}
+	cloned.members = s.Members()
+	return &cloned
+}
+
+func (s *setEntity) Encoding() string {
+	if s.intset != nil {
+		return "intset"
+	}
+	return "hashtable"
 }
 
 func (s *setEntity) ToCmd() [][]byte {
-	args := make([][]byte, 0, 2+len(s.container))
+	args := make([][]byte, 0, 2+s.Len())
 	args = append(args, []byte(database.CmdTypeSAdd), []byte(s.key))
-	for k := range s.container {
-		args = append(args, []byte(k))
-	}
+	s.ForEach(func(member string) bool {
+		args = append(args, []byte(member))
+		return true
+	})
 
 	return args
 }
+
+// 求交集，nil 表示 key 不存在. 从元素最少的 set 开始遍历，limit 大于 0 时至多返回 limit 个元素
+func interSets(sets []Set, limit int64) []string {
+	for _, set := range sets {
+		if set == nil {
+			return []string{}
+		}
+	}
+
+	sorted := make([]Set, len(sets))
+	copy(sorted, sets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Len() < sorted[j].Len()
+	})
+
+	members := make([]string, 0)
+	sorted[0].ForEach(func(member string) bool {
+		for _, set := range sorted[1:] {
+			if set.Exist(member) == 0 {
+				return true
+			}
+		}
+		members = append(members, member)
+		return limit <= 0 || int64(len(members)) < limit
+	})
+	return members
+}
+
+func unionSets(sets []Set) []string {
+	members := make([]string, 0)
+	union := make(map[string]struct{})
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		set.ForEach(func(member string) bool {
+			if _, ok := union[member]; !ok {
+				union[member] = struct{}{}
+				members = append(members, member)
+			}
+			return true
+		})
+	}
+	return members
+}
+
+// 求第一个 set 与其余 set 的差集
+func diffSets(sets []Set) []string {
+	if sets[0] == nil {
+		return []string{}
+	}
+
+	members := make([]string, 0)
+	sets[0].ForEach(func(member string) bool {
+		for _, set := range sets[1:] {
+			if set != nil && set.Exist(member) == 1 {
+				return true
+			}
+		}
+		members = append(members, member)
+		return true
+	})
+	return members
+}
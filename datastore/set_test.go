@@ -8,11 +8,12 @@ import (
 	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
 	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
 func Test_set_crud(t *testing.T) {
-	set := newSetEntity("")
+	set := newSetEntity("", testThresholds)
 	s := make(map[int]struct{}, 1000)
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
 
@@ -47,7 +48,7 @@ func Test_set_crud(t *testing.T) {
 }
 
 func Test_set_to_cmd(t *testing.T) {
-	set := newSetEntity("")
+	set := newSetEntity("", testThresholds)
 	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
 	s := make(map[int]struct{}, 1000)
 	// 插入1000条数据
@@ -88,3 +89,116 @@ func Test_set_to_cmd(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
 }
+
+func Test_set_rand(t *testing.T) {
+	set := newSetEntity("", testThresholds)
+	for i := 0; i < 10; i++ {
+		set.Add(cast.ToString(i))
+	}
+
+	t.Run("distinct", func(t *testing.T) {
+		members := set.RandMembers(5, true)
+		assert.Len(t, members, 5)
+		distinct := make(map[string]struct{})
+		for _, member := range members {
+			assert.Equal(t, int64(1), set.Exist(member))
+			distinct[member] = struct{}{}
+		}
+		assert.Len(t, distinct, 5)
+		assert.Len(t, set.RandMembers(20, true), 10)
+		assert.Len(t, set.RandMembers(20, false), 20)
+	})
+
+	t.Run("uniform", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			for _, member := range set.RandMembers(3, true) {
+				counts[member]++
+			}
+		}
+		for _, cnt := range counts {
+			assert.InDelta(t, 3000, cnt, 300)
+		}
+	})
+
+	t.Run("pop", func(t *testing.T) {
+		poped := set.Pop(4)
+		assert.Len(t, poped, 4)
+		for _, member := range poped {
+			assert.Equal(t, int64(0), set.Exist(member))
+		}
+		assert.Equal(t, int64(6), set.Len())
+		assert.Len(t, set.Pop(10), 6)
+		assert.Equal(t, int64(0), set.Len())
+	})
+}
+
+func Test_set_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "s", "a", "b", "c"))
+
+	assert.Equal(t, handler.NewIntReply(3), kvStore.SCard(newTestCmd(database.CmdTypeSCard, "s")))
+	assert.Equal(t, handler.NewArrayReply([]handler.Reply{handler.NewIntReply(1), handler.NewIntReply(0)}),
+		kvStore.SMIsMember(newTestCmd(database.CmdTypeSMIsMember, "s", "a", "x")))
+	assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.SRandMember(newTestCmd(database.CmdTypeSRandMember, "missing", "3")))
+	assert.Equal(t, handler.NewNillReply(), kvStore.SPop(newTestCmd(database.CmdTypeSPop, "missing")))
+
+	assert.Equal(t, handler.NewIntReply(1), kvStore.SMove(newTestCmd(database.CmdTypeSMove, "s", "d", "a")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.SMove(newTestCmd(database.CmdTypeSMove, "s", "d", "a")))
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("a")}), kvStore.SMembers(newTestCmd(database.CmdTypeSMembers, "d")))
+
+	// 弹出全部元素后删除 key
+	reply := kvStore.SPop(newTestCmd(database.CmdTypeSPop, "s", "5"))
+	assert.Len(t, reply.(*handler.MultiBulkReply).Args(), 2)
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "s")))
+
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "str", "foo"))
+	assert.True(t, handler.IsErrReply(kvStore.SMove(newTestCmd(database.CmdTypeSMove, "d", "str", "a"))))
+	assert.True(t, handler.IsErrReply(kvStore.SPop(newTestCmd(database.CmdTypeSPop, "d", "-1"))))
+	assert.True(t, handler.IsErrReply(kvStore.SCard(newTestCmd(database.CmdTypeSCard, "str"))))
+}
+
+func Test_set_algebra(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "a", "1", "2", "3", "4"))
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "b", "2", "3", "5"))
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "c", "3"))
+	members := func(reply handler.Reply) []string {
+		res := make([]string, 0)
+		for _, arg := range reply.(*handler.MultiBulkReply).Args() {
+			res = append(res, string(arg))
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	assert.Equal(t, []string{"2", "3"}, members(kvStore.SInter(newTestCmd(database.CmdTypeSInter, "a", "b"))))
+	assert.Equal(t, []string{}, members(kvStore.SInter(newTestCmd(database.CmdTypeSInter, "a", "missing"))))
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, members(kvStore.SUnion(newTestCmd(database.CmdTypeSUnion, "a", "b", "missing"))))
+	assert.Equal(t, []string{"1", "4"}, members(kvStore.SDiff(newTestCmd(database.CmdTypeSDiff, "a", "b", "c"))))
+
+	assert.Equal(t, handler.NewIntReply(2), kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "2", "a", "b")))
+	assert.Equal(t, handler.NewIntReply(1), kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "2", "a", "b", "limit", "1")))
+	assert.True(t, handler.IsErrReply(kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "0", "a"))))
+	assert.True(t, handler.IsErrReply(kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "3", "a", "b"))))
+	assert.True(t, handler.IsErrReply(kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "1", "a", "limit", "-1"))))
+
+	// key 没有在指令表中声明，由 handler 处理过期
+	kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "expired", "1"))
+	kvStore.expire("expired", lib.TimeNow().Add(-1))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.SInterCard(newTestCmd(database.CmdTypeSInterCard, "1", "expired")))
+
+	// 目标 key 原有的数据以及过期时间被覆盖
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "dest", "foo", "ex", "100"))
+	assert.Equal(t, handler.NewIntReply(2), kvStore.SDiffStore(newTestCmd(database.CmdTypeSDiffStore, "dest", "a", "b")))
+	assert.Equal(t, []string{"1", "4"}, members(kvStore.SMembers(newTestCmd(database.CmdTypeSMembers, "dest"))))
+	assert.Equal(t, handler.NewIntReply(-1), kvStore.TTL(newTestCmd(database.CmdTypeTTL, "dest")))
+
+	assert.Equal(t, handler.NewIntReply(1), kvStore.SInterStore(newTestCmd(database.CmdTypeSInterStore, "a", "a", "b", "c")))
+	assert.Equal(t, []string{"3"}, members(kvStore.SMembers(newTestCmd(database.CmdTypeSMembers, "a"))))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.SUnionStore(newTestCmd(database.CmdTypeSUnionStore, "dest", "missing")))
+	assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "dest")))
+
+	kvStore.Set(newTestCmd(database.CmdTypeSet, "str", "foo"))
+	assert.True(t, handler.IsErrReply(kvStore.SUnion(newTestCmd(database.CmdTypeSUnion, "a", "str"))))
+}
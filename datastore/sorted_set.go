@@ -3,7 +3,9 @@ package datastore
 import (
 	"math"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/handler"
@@ -28,17 +30,366 @@ func (k *KVStore) putAsSortedSet(key string, zset SortedSet) {
 	k.data[key] = zset
 }
 
+func (k *KVStore) delIfEmptySortedSet(key string, zset SortedSet) {
+	if zset.Len() == 0 {
+		k.del(key)
+	}
+}
+
 type SortedSet interface {
-	Add(score int64, member string)
+	Add(score float64, member string)
 	Rem(member string) int64
-	Range(score1, score2 int64) []string
-	database.CmdAdapter
+	// 按照 spec 指定的 score 或者字典序区间查询元素
+	Range(spec zrangeSpec) []zsetItem
+	Len() int64
+	Score(member string) (float64, bool)
+	// 统计 spec 区间内的元素数量，忽略 reverse 与 LIMIT
+	Count(spec zrangeSpec) int64
+	// member 按照升序排列的排名，从 0 开始
+	Rank(member string) (int64, float64, bool)
+	// 返回排名在 [start,stop] 内的元素，调用方保证 0 <= start <= stop < Len()
+	RangeByRank(start, stop int64) []zsetItem
+	Entity
+}
+
+type zsetItem struct {
+	member string
+	score  float64
+}
+
+// zset 区间的端点. inf 用于字典序区间的 - 与 +，分别取值 -1 与 1
+type zsetBound struct {
+	score     float64
+	member    string
+	inf       int
+	exclusive bool
+}
+
+// zset 的区间查询. byLex 为 true 时按照 member 的字典序比较，要求全部元素的 score 相同.
+// reverse 为 true 时按照降序返回，offset 与 count 为 LIMIT 选项，count 为负数时不限制数量
+type zrangeSpec struct {
+	min, max      zsetBound
+	byLex         bool
+	reverse       bool
+	offset, count int64
+}
+
+// 闭区间 [min,max] 内的全部元素
+func scoreRange(min, max float64) zrangeSpec {
+	return zrangeSpec{min: zsetBound{score: min}, max: zsetBound{score: max}, count: -1}
+}
+
+func (r *zrangeSpec) aboveMin(score float64, member string) bool {
+	if !r.byLex {
+		if r.min.exclusive {
+			return score > r.min.score
+		}
+		return score >= r.min.score
+	}
+
+	if r.min.inf != 0 {
+		return r.min.inf < 0
+	}
+	if r.min.exclusive {
+		return member > r.min.member
+	}
+	return member >= r.min.member
+}
+
+func (r *zrangeSpec) belowMax(score float64, member string) bool {
+	if !r.byLex {
+		if r.max.exclusive {
+			return score < r.max.score
+		}
+		return score <= r.max.score
+	}
+
+	if r.max.inf != 0 {
+		return r.max.inf > 0
+	}
+	if r.max.exclusive {
+		return member < r.max.member
+	}
+	return member <= r.max.member
+}
+
+// 区间的数量是否已满足 LIMIT 限制
+func (r *zrangeSpec) full(cnt int) bool {
+	return r.count >= 0 && int64(cnt) >= r.count
+}
+
+// 解析 score 区间的端点，( 前缀表示开区间
+func parseScoreBound(arg []byte) (zsetBound, bool) {
+	var bound zsetBound
+	if len(arg) > 0 && arg[0] == '(' {
+		bound.exclusive = true
+		arg = arg[1:]
+	}
+	score, ok := parseScore(arg)
+	bound.score = score
+	return bound, ok
+}
+
+// 解析字典序区间的端点，- 与 + 分别表示负无穷与正无穷，[ 与 ( 前缀分别表示闭区间与开区间
+func parseLexBound(arg []byte) (zsetBound, bool) {
+	switch {
+	case string(arg) == "-":
+		return zsetBound{inf: -1}, true
+	case string(arg) == "+":
+		return zsetBound{inf: 1}, true
+	case len(arg) > 0 && arg[0] == '[':
+		return zsetBound{member: string(arg[1:])}, true
+	case len(arg) > 0 && arg[0] == '(':
+		return zsetBound{member: string(arg[1:]), exclusive: true}, true
+	default:
+		return zsetBound{}, false
+	}
+}
+
+// 元素数量不超过 zset-max-listpack-entries 且 member 长度不超过 zset-max-listpack-value 时采用 listpack 编码，
+// member 与 score 按照 <score, member> 升序成对存放. 否则转为 skiplist 编码
+type zsetEntity struct {
+	key        string
+	listpack   *listpack
+	skiplist   SortedSet
+	thresholds *encodingThresholds
+}
+
+func newZSetEntity(key string, thresholds *encodingThresholds) SortedSet {
+	return &zsetEntity{
+		key:        key,
+		listpack:   newListpack(),
+		thresholds: thresholds,
+	}
+}
+
+func (z *zsetEntity) Add(score float64, member string) {
+	if z.listpack != nil {
+		if len(member) <= z.thresholds.zsetMaxListpackValue {
+			z.addListpack(score, member)
+			return
+		}
+		z.convert()
+	}
+
+	z.skiplist.Add(score, member)
+}
+
+func (z *zsetEntity) addListpack(score float64, member string) {
+	if offset, oldScore, ok := z.find(member); ok {
+		if oldScore == score {
+			return
+		}
+		z.listpack.Delete(offset, 2)
+	}
+
+	// 插入到首个排序更靠后的元素之前
+	inserted := -1
+	z.listpack.ForEachPair(func(offset int, rawMember, rawScore []byte) bool {
+		if zsetLess(score, member, parseListpackScore(rawScore), string(rawMember)) {
+			inserted = offset
+			return false
+		}
+		return true
+	})
+	z.listpack.Insert(inserted, []byte(member), []byte(formatScore(score)))
+
+	if z.listpack.Len()/2 > z.thresholds.zsetMaxListpackEntries {
+		z.convert()
+	}
+}
+
+// 查找 member 在 listpack 中的偏移量以及 score
+func (z *zsetEntity) find(member string) (int, float64, bool) {
+	target, score := -1, float64(0)
+	z.listpack.ForEachPair(func(offset int, rawMember, rawScore []byte) bool {
+		if string(rawMember) == member {
+			target, score = offset, parseListpackScore(rawScore)
+			return false
+		}
+		return true
+	})
+	return target, score, target >= 0
+}
+
+func parseListpackScore(rawScore []byte) float64 {
+	score, _ := parseScore(rawScore)
+	return score
+}
+
+// listpack 转为 skiplist 编码
+func (z *zsetEntity) convert() {
+	z.skiplist = newSkiplist(z.key)
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		z.skiplist.Add(parseListpackScore(rawScore), string(rawMember))
+		return true
+	})
+	z.listpack = nil
+}
+
+func (z *zsetEntity) Rem(member string) int64 {
+	if z.listpack == nil {
+		return z.skiplist.Rem(member)
+	}
+
+	offset, _, ok := z.find(member)
+	if !ok {
+		return 0
+	}
+	z.listpack.Delete(offset, 2)
+	return 1
+}
+
+// 线性遍历 listpack，先筛选出区间内的全部元素，再处理 reverse 与 LIMIT
+func (z *zsetEntity) Range(spec zrangeSpec) []zsetItem {
+	if z.listpack == nil {
+		return z.skiplist.Range(spec)
+	}
+
+	if spec.offset < 0 {
+		return []zsetItem{}
+	}
+
+	items := []zsetItem{}
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		score, member := parseListpackScore(rawScore), string(rawMember)
+		if !spec.belowMax(score, member) {
+			return false
+		}
+		if spec.aboveMin(score, member) {
+			items = append(items, zsetItem{member: member, score: score})
+		}
+		return true
+	})
+
+	if spec.reverse {
+		reverseZSetItems(items)
+	}
+	if spec.offset >= int64(len(items)) {
+		return []zsetItem{}
+	}
+	items = items[spec.offset:]
+	if spec.full(len(items)) {
+		items = items[:spec.count]
+	}
+	return items
+}
+
+func (z *zsetEntity) Len() int64 {
+	if z.listpack == nil {
+		return z.skiplist.Len()
+	}
+	return int64(z.listpack.Len() / 2)
+}
+
+func (z *zsetEntity) Score(member string) (float64, bool) {
+	if z.listpack == nil {
+		return z.skiplist.Score(member)
+	}
+
+	_, score, ok := z.find(member)
+	return score, ok
+}
+
+func (z *zsetEntity) Count(spec zrangeSpec) int64 {
+	if z.listpack == nil {
+		return z.skiplist.Count(spec)
+	}
+
+	var cnt int64
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		score, member := parseListpackScore(rawScore), string(rawMember)
+		if !spec.belowMax(score, member) {
+			return false
+		}
+		if spec.aboveMin(score, member) {
+			cnt++
+		}
+		return true
+	})
+	return cnt
+}
+
+func (z *zsetEntity) Rank(member string) (int64, float64, bool) {
+	if z.listpack == nil {
+		return z.skiplist.Rank(member)
+	}
+
+	var (
+		rank, target int64 = 0, -1
+		score        float64
+	)
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		if string(rawMember) == member {
+			target, score = rank, parseListpackScore(rawScore)
+			return false
+		}
+		rank++
+		return true
+	})
+	return target, score, target >= 0
 }
 
+func (z *zsetEntity) RangeByRank(start, stop int64) []zsetItem {
+	if z.listpack == nil {
+		return z.skiplist.RangeByRank(start, stop)
+	}
+
+	var rank int64
+	res := make([]zsetItem, 0, stop-start+1)
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		if rank >= start {
+			res = append(res, zsetItem{member: string(rawMember), score: parseListpackScore(rawScore)})
+		}
+		rank++
+		return rank <= stop
+	})
+	return res
+}
+
+func (z *zsetEntity) Rename(key string) {
+	z.key = key
+	if z.skiplist != nil {
+		z.skiplist.Rename(key)
+	}
+}
+
+func (z *zsetEntity) Clone(key string) Entity {
+	cloned := zsetEntity{key: key, thresholds: z.thresholds}
+	if z.listpack != nil {
+		cloned.listpack = z.listpack.Clone()
+	} else {
+		cloned.skiplist = z.skiplist.Clone(key).(SortedSet)
+	}
+	return &cloned
+}
+
+func (z *zsetEntity) Encoding() string {
+	if z.listpack != nil {
+		return "listpack"
+	}
+	return "skiplist"
+}
+
+func (z *zsetEntity) ToCmd() [][]byte {
+	if z.listpack == nil {
+		return z.skiplist.ToCmd()
+	}
+
+	args := make([][]byte, 0, 2+z.listpack.Len())
+	args = append(args, []byte(database.CmdTypeZAdd), []byte(z.key))
+	z.listpack.ForEachPair(func(_ int, rawMember, rawScore []byte) bool {
+		args = append(args, append([]byte{}, rawScore...), append([]byte{}, rawMember...))
+		return true
+	})
+	return args
+}
+
+// 按照 <score, member> 排序的跳表，score 相同时按照 member 的字典序排序. 每个 member 对应一个节点，
+// 各层指针记录跨越的节点数量 span，从而可以在 O(logn) 时间内完成排名相关的查询
 type skiplist struct {
 	key           string
-	scoreToNode   map[int64]*skipnode
-	memberToScore map[string]int64
+	memberToScore map[string]float64
 	head          *skipnode
 	rander        *rand.Rand
 }
@@ -46,14 +397,13 @@ type skiplist struct {
 func newSkiplist(key string) SortedSet {
 	return &skiplist{
 		key:           key,
-		memberToScore: make(map[string]int64),
-		scoreToNode:   make(map[int64]*skipnode),
-		head:          newSkipnode(0, 0),
+		memberToScore: make(map[string]float64),
+		head:          newSkipnode(0, "", 0),
 		rander:        rand.New((rand.NewSource(lib.TimeNow().UnixNano()))),
 	}
 }
 
-func (s *skiplist) Add(score int64, member string) {
+func (s *skiplist) Add(score float64, member string) {
 	// 之前存在，需要删除
 	oldScore, ok := s.memberToScore[member]
 	if ok {
@@ -64,31 +414,49 @@ func (s *skiplist) Add(score int64, member string) {
 	}
 
 	s.memberToScore[member] = score
-	node, ok := s.scoreToNode[score]
-	if ok {
-		node.members[member] = struct{}{}
-		return
+
+	// update[i] 为第 i 层中插入位置的前驱节点，rank[i] 为前驱节点的排名
+	level := len(s.head.nexts)
+	update := make([]*skipnode, level)
+	rank := make([]int64, level)
+	move := s.head
+	for i := level - 1; i >= 0; i-- {
+		if i < level-1 {
+			rank[i] = rank[i+1]
+		}
+		for move.nexts[i] != nil && move.nexts[i].less(score, member) {
+			rank[i] += move.spans[i]
+			move = move.nexts[i]
+		}
+		update[i] = move
 	}
 
-	// 新插入，roll 出高度
-	height := s.roll()
-	for int64(len(s.head.nexts)) < height+1 {
+	// 新插入，roll 出高度. 新增的层中，head 直接跨越全部节点
+	height := int(s.roll()) + 1
+	for len(s.head.nexts) < height {
 		s.head.nexts = append(s.head.nexts, nil)
+		s.head.spans = append(s.head.spans, int64(len(s.memberToScore)-1))
+		update = append(update, s.head)
+		rank = append(rank, 0)
 	}
 
-	inserted := newSkipnode(score, height+1)
-	inserted.members[member] = struct{}{}
-	s.scoreToNode[score] = inserted
-
-	move := s.head
-	for i := height; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score {
-			move = move.nexts[i]
-			continue
-		}
+	inserted := newSkipnode(score, member, int64(height))
+	if update[0] != s.head {
+		inserted.prev = update[0]
+	}
+	if next := update[0].nexts[0]; next != nil {
+		next.prev = inserted
+	}
+	for i := 0; i < height; i++ {
+		inserted.nexts[i] = update[i].nexts[i]
+		update[i].nexts[i] = inserted
+		inserted.spans[i] = update[i].spans[i] - (rank[0] - rank[i])
+		update[i].spans[i] = rank[0] - rank[i] + 1
+	}
 
-		inserted.nexts[i] = move.nexts[i]
-		move.nexts[i] = inserted
+	// 更高的层跨越了新节点
+	for i := height; i < len(update); i++ {
+		update[i].spans[i]++
 	}
 }
 
@@ -102,38 +470,137 @@ func (s *skiplist) Rem(member string) int64 {
 	return 1
 }
 
-// [score1,score2]
-func (s *skiplist) Range(score1, score2 int64) []string {
-	if score2 == -1 {
-		score2 = math.MaxInt64
+func (s *skiplist) Range(spec zrangeSpec) []zsetItem {
+	if spec.offset < 0 {
+		return []zsetItem{}
 	}
 
-	if score1 > score2 {
-		return []string{}
+	var node *skipnode
+	if spec.reverse {
+		node = s.last(&spec)
+	} else {
+		node = s.first(&spec)
 	}
 
+	res := []zsetItem{}
+	for ; node != nil && !spec.full(len(res)); node = node.next(spec.reverse) {
+		if spec.reverse && !spec.aboveMin(node.score, node.member) {
+			break
+		}
+		if !spec.reverse && !spec.belowMax(node.score, node.member) {
+			break
+		}
+		res = append(res, zsetItem{member: node.member, score: node.score})
+	}
+	return res
+}
+
+// 区间内跳过 offset 个元素后的首个节点. 借助 span 定位，耗时为 O(logn)
+func (s *skiplist) first(spec *zrangeSpec) *skipnode {
+	var rank int64
 	move := s.head
 	for i := len(s.head.nexts) - 1; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score1 {
+		for move.nexts[i] != nil && !spec.aboveMin(move.nexts[i].score, move.nexts[i].member) {
+			rank += move.spans[i]
 			move = move.nexts[i]
 		}
 	}
+	return s.byRank(rank + 1 + spec.offset)
+}
 
-	// 来到了 level0 层，move.nexts[i] 如果存在，就是首个 >= score1 的元素
-	if len(move.nexts) == 0 || move.nexts[0] == nil {
-		return []string{}
+// 区间内跳过 offset 个元素后的末尾节点
+func (s *skiplist) last(spec *zrangeSpec) *skipnode {
+	var rank int64
+	move := s.head
+	for i := len(s.head.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && spec.belowMax(move.nexts[i].score, move.nexts[i].member) {
+			rank += move.spans[i]
+			move = move.nexts[i]
+		}
+	}
+	if rank-spec.offset <= 0 {
+		return nil
+	}
+	return s.byRank(rank - spec.offset)
+}
+
+func (s *skiplist) Len() int64 {
+	return int64(len(s.memberToScore))
+}
+
+func (s *skiplist) Score(member string) (float64, bool) {
+	score, ok := s.memberToScore[member]
+	return score, ok
+}
+
+// 以区间两端的排名之差作为元素数量，耗时为 O(logn)
+func (s *skiplist) Count(spec zrangeSpec) int64 {
+	var below, notAbove int64
+	move := s.head
+	for i := len(s.head.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && !spec.aboveMin(move.nexts[i].score, move.nexts[i].member) {
+			below += move.spans[i]
+			move = move.nexts[i]
+		}
+	}
+
+	move = s.head
+	for i := len(s.head.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && spec.belowMax(move.nexts[i].score, move.nexts[i].member) {
+			notAbove += move.spans[i]
+			move = move.nexts[i]
+		}
+	}
+
+	if notAbove < below {
+		return 0
+	}
+	return notAbove - below
+}
+
+// 排名从 0 开始
+func (s *skiplist) Rank(member string) (int64, float64, bool) {
+	score, ok := s.memberToScore[member]
+	if !ok {
+		return 0, 0, false
 	}
 
-	res := []string{}
-	for move.nexts[0] != nil && move.nexts[0].score >= score1 && move.nexts[0].score <= score2 {
-		for member := range move.nexts[0].members {
-			res = append(res, member)
+	var rank int64
+	move := s.head
+	for i := len(s.head.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && (move.nexts[i].less(score, member) || move.nexts[i].member == member) {
+			rank += move.spans[i]
+			move = move.nexts[i]
 		}
-		move = move.nexts[0]
+	}
+	return rank - 1, score, true
+}
+
+// [start,stop] 为已经修正过的合法排名
+func (s *skiplist) RangeByRank(start, stop int64) []zsetItem {
+	res := make([]zsetItem, 0, stop-start+1)
+	for move := s.byRank(start + 1); move != nil && int64(len(res)) <= stop-start; move = move.nexts[0] {
+		res = append(res, zsetItem{member: move.member, score: move.score})
 	}
 	return res
 }
 
+// 查找排名为 rank 的节点，rank 从 1 开始. 排名超出范围时返回 nil
+func (s *skiplist) byRank(rank int64) *skipnode {
+	var traversed int64
+	move := s.head
+	for i := len(s.head.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && traversed+move.spans[i] <= rank {
+			traversed += move.spans[i]
+			move = move.nexts[i]
+		}
+		if traversed == rank {
+			return move
+		}
+	}
+	return nil
+}
+
 func (s *skiplist) roll() int64 {
 	var level int64
 	for s.rander.Intn(2) > 0 {
@@ -142,52 +609,359 @@ func (s *skiplist) roll() int64 {
 	return level
 }
 
-func (s *skiplist) rem(score int64, member string) {
+func (s *skiplist) rem(score float64, member string) {
 	delete(s.memberToScore, member)
-	skipnode := s.scoreToNode[score]
-
-	delete(skipnode.members, member)
-	if len(skipnode.members) > 0 {
-		return
-	}
-
-	delete(s.scoreToNode, score)
 	move := s.head
 	for i := len(s.head.nexts) - 1; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score {
+		for move.nexts[i] != nil && move.nexts[i].less(score, member) {
 			move = move.nexts[i]
 		}
 
-		if move.nexts[i] == nil || move.nexts[i].score > score {
+		// 未指向被删除节点的层，跨越的节点数减一
+		if move.nexts[i] == nil || move.nexts[i].member != member {
+			move.spans[i]--
 			continue
 		}
 
 		remed := move.nexts[i]
-		move.nexts[i] = move.nexts[i].nexts[i]
+		if i == 0 && remed.nexts[0] != nil {
+			remed.nexts[0].prev = remed.prev
+		}
+		move.nexts[i] = remed.nexts[i]
+		move.spans[i] += remed.spans[i] - 1
 		remed.nexts[i] = nil
 	}
+
+	// 移除空的顶层
+	for level := len(s.head.nexts); level > 0 && s.head.nexts[level-1] == nil; level-- {
+		s.head.nexts = s.head.nexts[:level-1]
+		s.head.spans = s.head.spans[:level-1]
+	}
+}
+
+// 按序遍历全部节点，f 返回 false 时终止遍历
+func (s *skiplist) forEach(f func(score float64, member string) bool) {
+	if len(s.head.nexts) == 0 {
+		return
+	}
+	for move := s.head.nexts[0]; move != nil; move = move.nexts[0] {
+		if !f(move.score, move.member) {
+			return
+		}
+	}
+}
+
+func (s *skiplist) Rename(key string) {
+	s.key = key
+}
+
+func (s *skiplist) Clone(key string) Entity {
+	cloned := newSkiplist(key)
+	s.forEach(func(score float64, member string) bool {
+		cloned.Add(score, member)
+		return true
+	})
+	return cloned
+}
+
+func (s *skiplist) Encoding() string {
+	return "skiplist"
 }
 
 func (s *skiplist) ToCmd() [][]byte {
 	args := make([][]byte, 0, 2+2*len(s.memberToScore))
 	args = append(args, []byte(database.CmdTypeZAdd), []byte(s.key))
-	for member, score := range s.memberToScore {
-		scoreStr := strconv.FormatInt(score, 10)
-		args = append(args, []byte(scoreStr), []byte(member))
-	}
+	s.forEach(func(score float64, member string) bool {
+		args = append(args, []byte(formatScore(score)), []byte(member))
+		return true
+	})
 	return args
 }
 
+// spans[i] 为第 i 层指针跨越的节点数量，即 nexts[i] 与当前节点的排名之差. 指针为空时，跨越至末尾.
+// prev 指向第 0 层的前驱节点，首个节点的 prev 为 nil
 type skipnode struct {
-	score   int64
-	members map[string]struct{}
-	nexts   []*skipnode
+	score  float64
+	member string
+	prev   *skipnode
+	nexts  []*skipnode
+	spans  []int64
 }
 
-func newSkipnode(score, height int64) *skipnode {
+func newSkipnode(score float64, member string, height int64) *skipnode {
 	return &skipnode{
-		score:   score,
-		members: make(map[string]struct{}),
-		nexts:   make([]*skipnode, height),
+		score:  score,
+		member: member,
+		nexts:  make([]*skipnode, height),
+		spans:  make([]int64, height),
+	}
+}
+
+func (n *skipnode) next(reverse bool) *skipnode {
+	if reverse {
+		return n.prev
+	}
+	return n.nexts[0]
+}
+
+// 节点是否排在 <score, member> 之前
+func (n *skipnode) less(score float64, member string) bool {
+	return zsetLess(n.score, n.member, score, member)
+}
+
+func zsetLess(score1 float64, member1 string, score2 float64, member2 string) bool {
+	if score1 != score2 {
+		return score1 < score2
+	}
+	return member1 < member2
+}
+
+// 解析 score，支持 -inf 与 +inf
+func parseScore(raw []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// score 的最短十进制表示，能够被 parseScore 精确还原
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	if abs := math.Abs(score); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// zrange 类指令的默认模式. unified 为 true 时支持 BYSCORE BYLEX REV 选项，store 为 true 时不支持 WITHSCORES 选项
+type zrangeMode struct {
+	byScore, byLex, reverse bool
+	unified, store          bool
+}
+
+// 按照排名查询时使用 start 与 stop，否则使用 spec
+type zrangeRequest struct {
+	key         string
+	byScore     bool
+	withScores  bool
+	start, stop int64
+	spec        zrangeSpec
+}
+
+// 解析 key min max [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES] 形式的参数.
+// 按照 score 或者字典序降序查询时，区间的端点依次为 max min
+func parseZRangeRequest(args [][]byte, mode zrangeMode) (*zrangeRequest, handler.Reply) {
+	req := zrangeRequest{
+		key:     string(args[0]),
+		byScore: mode.byScore,
+		spec:    zrangeSpec{byLex: mode.byLex, reverse: mode.reverse, count: -1},
+	}
+
+	var limited bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "withscores" && !mode.store:
+			req.withScores = true
+		case opt == "byscore" && mode.unified:
+			req.byScore = true
+		case opt == "bylex" && mode.unified:
+			req.spec.byLex = true
+		case opt == "rev" && mode.unified:
+			req.spec.reverse = true
+		case opt == "limit" && i+2 < len(args):
+			offset, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			count, errReply := parseInt(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			req.spec.offset, req.spec.count, limited = offset, count, true
+			i += 2
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	if req.byScore && req.spec.byLex {
+		return nil, handler.NewSyntaxErrReply()
+	}
+	if limited && !req.byScore && !req.spec.byLex {
+		return nil, handler.NewErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if req.withScores && req.spec.byLex {
+		return nil, handler.NewErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	minArg, maxArg := args[1], args[2]
+	if req.spec.reverse && (req.byScore || req.spec.byLex) {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	switch {
+	case req.byScore:
+		var ok1, ok2 bool
+		req.spec.min, ok1 = parseScoreBound(minArg)
+		req.spec.max, ok2 = parseScoreBound(maxArg)
+		if !ok1 || !ok2 {
+			return nil, handler.NewErrReply("ERR min or max is not a float")
+		}
+	case req.spec.byLex:
+		var ok1, ok2 bool
+		req.spec.min, ok1 = parseLexBound(minArg)
+		req.spec.max, ok2 = parseLexBound(maxArg)
+		if !ok1 || !ok2 {
+			return nil, handler.NewErrReply("ERR min or max not valid string range item")
+		}
+	default:
+		var errReply handler.Reply
+		if req.start, errReply = parseInt(minArg); errReply != nil {
+			return nil, errReply
+		}
+		if req.stop, errReply = parseInt(maxArg); errReply != nil {
+			return nil, errReply
+		}
+	}
+	return &req, nil
+}
+
+// zset 集合运算中 score 的聚合方式
+type zsetAggregate int
+
+const (
+	zsetAggregateSum zsetAggregate = iota
+	zsetAggregateMin
+	zsetAggregateMax
+)
+
+func (a zsetAggregate) apply(cur, score float64) float64 {
+	switch a {
+	case zsetAggregateMin:
+		return math.Min(cur, score)
+	case zsetAggregateMax:
+		return math.Max(cur, score)
+	default:
+		// 与 redis 一致，inf 与 -inf 相加得到的 nan 视为 0
+		if sum := cur + score; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+}
+
+// 集合运算的输入. 普通 set 也可以参与运算，其中元素的 score 均视为 1
+type zsetSource map[string]float64
+
+func newZSetSource(v interface{}) zsetSource {
+	source := make(zsetSource)
+	switch v := v.(type) {
+	case SortedSet:
+		for _, item := range v.Range(scoreRange(math.Inf(-1), math.Inf(1))) {
+			source[item.member] = item.score
+		}
+	case Set:
+		v.ForEach(func(member string) bool {
+			source[member] = 1
+			return true
+		})
+	}
+	return source
+}
+
+// score 乘以权重，与 redis 一致，0 与 inf 相乘得到的 nan 视为 0
+func weightScore(score, weight float64) float64 {
+	if v := score * weight; !math.IsNaN(v) {
+		return v
+	}
+	return 0
+}
+
+func unionZSets(sources []zsetSource, weights []float64, aggregate zsetAggregate) []zsetItem {
+	union := make(map[string]float64)
+	for i, source := range sources {
+		for member, score := range source {
+			score = weightScore(score, weights[i])
+			if cur, ok := union[member]; ok {
+				score = aggregate.apply(cur, score)
+			}
+			union[member] = score
+		}
+	}
+	return sortedZSetItems(union)
+}
+
+// 从元素最少的输入开始遍历
+func interZSets(sources []zsetSource, weights []float64, aggregate zsetAggregate) []zsetItem {
+	smallest := 0
+	for i, source := range sources {
+		if len(source) < len(sources[smallest]) {
+			smallest = i
+		}
+	}
+
+	inter := make(map[string]float64)
+	for member := range sources[smallest] {
+		var (
+			score float64
+			ok    = true
+		)
+		for i, source := range sources {
+			v, exist := source[member]
+			if !exist {
+				ok = false
+				break
+			}
+			if i == 0 {
+				score = weightScore(v, weights[i])
+			} else {
+				score = aggregate.apply(score, weightScore(v, weights[i]))
+			}
+		}
+		if ok {
+			inter[member] = score
+		}
+	}
+	return sortedZSetItems(inter)
+}
+
+// 求第一个输入与其余输入的差集，保留第一个输入中的 score
+func diffZSets(sources []zsetSource) []zsetItem {
+	diff := make(map[string]float64)
+	for member, score := range sources[0] {
+		var exist bool
+		for _, source := range sources[1:] {
+			if _, exist = source[member]; exist {
+				break
+			}
+		}
+		if !exist {
+			diff[member] = score
+		}
+	}
+	return sortedZSetItems(diff)
+}
+
+func sortedZSetItems(memberToScore map[string]float64) []zsetItem {
+	items := make([]zsetItem, 0, len(memberToScore))
+	for member, score := range memberToScore {
+		items = append(items, zsetItem{member: member, score: score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return zsetLess(items[i].score, items[i].member, items[j].score, items[j].member)
+	})
+	return items
+}
+
+func reverseZSetItems(items []zsetItem) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
 	}
 }
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"strings"
@@ -10,15 +11,24 @@ import (
 	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
 	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib"
 )
 
+func rangeMembers(zset SortedSet, min, max float64) []string {
+	members := []string{}
+	for _, item := range zset.Range(scoreRange(min, max)) {
+		members = append(members, item.member)
+	}
+	return members
+}
+
 func Test_skiplist_add_rem_range(t *testing.T) {
 	skiplist := newSkiplist("")
 	// 添加 1000 条指令
 	for i := 0; i < 1000; i++ {
-		skiplist.Add(int64(i), fmt.Sprintf("%d_0", i))
-		skiplist.Add(int64(i), fmt.Sprintf("%d_1", i))
+		skiplist.Add(float64(i), fmt.Sprintf("%d_0", i))
+		skiplist.Add(float64(i), fmt.Sprintf("%d_1", i))
 	}
 
 	// 随机移除 1000 个 member
@@ -35,7 +45,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 	t.Run("single_score", func(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			score := int64(rander.Intn(1000))
-			member := skiplist.Range(score, score)
+			member := rangeMembers(skiplist, float64(score), float64(score))
 			sort.Slice(member, func(i, j int) bool {
 				return member[i] < member[j]
 			})
@@ -57,7 +67,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			leftScore := int64(rander.Intn(501))
 			rightScore := leftScore + int64(rander.Intn(500))
-			member := skiplist.Range(leftScore, rightScore)
+			member := rangeMembers(skiplist, float64(leftScore), float64(rightScore))
 			sort.Slice(member, func(i, j int) bool {
 				splitted1 := strings.Split(member[i], "_")
 				splitted2 := strings.Split(member[j], "_")
@@ -85,8 +95,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 	t.Run("with_maximum_right_range", func(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			leftScore := int64(rander.Intn(1000))
-			rightScore := int64(-1)
-			member := skiplist.Range(leftScore, rightScore)
+			member := rangeMembers(skiplist, float64(leftScore), math.Inf(1))
 			sort.Slice(member, func(i, j int) bool {
 				splitted1 := strings.Split(member[i], "_")
 				splitted2 := strings.Split(member[j], "_")
@@ -124,9 +133,9 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 			continue
 		}
 		memberSet[member] = struct{}{}
-		skiplist.Add(score1, member)
+		skiplist.Add(float64(score1), member)
 		score2 := int64(rander.Intn(1000))
-		skiplist.Add(score2, member)
+		skiplist.Add(float64(score2), member)
 		scoreToMembers[score2] = append(scoreToMembers[score2], member)
 	}
 
@@ -136,7 +145,7 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 				return cast.ToInt(members[i]) < cast.ToInt(members[j])
 			})
 
-			actualMembers := skiplist.Range(score, score)
+			actualMembers := rangeMembers(skiplist, float64(score), float64(score))
 			sort.Slice(actualMembers, func(i, j int) bool {
 				return cast.ToInt(actualMembers[i]) < cast.ToInt(actualMembers[j])
 			})
@@ -149,7 +158,7 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 				if oldScore == score {
 					continue
 				}
-				for _, gotMember := range skiplist.Range(oldScore, oldScore) {
+				for _, gotMember := range rangeMembers(skiplist, float64(oldScore), float64(oldScore)) {
 					if gotMember == member {
 						t.Errorf("old score: %d, members: %s", oldScore, gotMember)
 					}
@@ -168,7 +177,7 @@ func Test_skiplist_to_cmd(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		score := rander.Intn(1000)
 		member := rander.Intn(1000)
-		skiplist.Add(int64(score), cast.ToString(member))
+		skiplist.Add(float64(score), cast.ToString(member))
 		memberToScore[member] = score
 	}
 
@@ -219,3 +228,406 @@ func Test_skiplist_to_cmd(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
 }
+
+func Test_zset_float_score(t *testing.T) {
+	zsets := map[string]SortedSet{
+		"listpack": newZSetEntity("z", testThresholds),
+		"skiplist": newSkiplist("z"),
+	}
+	// 非常量运算，结果为 0.30000000000000004
+	tenth := 0.1
+	for encoding, zset := range zsets {
+		t.Run(encoding, func(t *testing.T) {
+			zset.Add(1.5, "b")
+			zset.Add(1.5, "a")
+			zset.Add(math.Inf(-1), "min")
+			zset.Add(math.Inf(1), "max")
+			zset.Add(tenth+0.2, "c")
+			zset.Add(-2.25, "d")
+
+			// score 相同时按照 member 的字典序排序
+			assert.Equal(t, []string{"min", "d", "c", "a", "b", "max"}, rangeMembers(zset, math.Inf(-1), math.Inf(1)))
+			assert.Equal(t, []string{"c", "a", "b"}, rangeMembers(zset, tenth+0.2, 1.5))
+			assert.Equal(t, []string{"a", "b"}, rangeMembers(zset, 0.3000000000000001, 1.5))
+			assert.Equal(t, []string{"max"}, rangeMembers(zset, math.Inf(1), math.Inf(1)))
+
+			// aof 回放得到完全一致的 score 与顺序
+			cmd := zset.ToCmd()
+			assert.Equal(t, "-inf", string(cmd[2]))
+			assert.Equal(t, "0.30000000000000004", string(cmd[6]))
+			assert.Equal(t, "inf", string(cmd[12]))
+			replayed := newSkiplist("z")
+			for i := 2; i < len(cmd); i += 2 {
+				score, ok := parseScore(cmd[i])
+				assert.True(t, ok)
+				replayed.Add(score, string(cmd[i+1]))
+			}
+			assert.Equal(t, cmd, replayed.ToCmd())
+		})
+	}
+}
+
+func Test_zset_format_score(t *testing.T) {
+	for _, score := range []float64{0, 1, -1, 1.5, 1e6, 1e21, -1e-7, 1 << 52, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		got, ok := parseScore([]byte(formatScore(score)))
+		assert.True(t, ok)
+		assert.Equal(t, score, got)
+	}
+	assert.Equal(t, "1000000", formatScore(1e6))
+	assert.Equal(t, "1e+21", formatScore(1e21))
+
+	_, ok := parseScore([]byte("nan"))
+	assert.False(t, ok)
+	_, ok = parseScore([]byte("1.5x"))
+	assert.False(t, ok)
+}
+
+func Test_skiplist_rank(t *testing.T) {
+	skiplist := newSkiplist("")
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	memberToScore := make(map[string]float64)
+	for i := 0; i < 3000; i++ {
+		member := cast.ToString(rander.Intn(500))
+		if rander.Intn(3) == 0 {
+			skiplist.Rem(member)
+			delete(memberToScore, member)
+			continue
+		}
+		score := float64(rander.Intn(100))
+		skiplist.Add(score, member)
+		memberToScore[member] = score
+	}
+
+	expected := make([]zsetItem, 0, len(memberToScore))
+	for member, score := range memberToScore {
+		expected = append(expected, zsetItem{member: member, score: score})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return zsetLess(expected[i].score, expected[i].member, expected[j].score, expected[j].member)
+	})
+
+	assert.Equal(t, int64(len(expected)), skiplist.Len())
+	for i, item := range expected {
+		rank, score, ok := skiplist.Rank(item.member)
+		assert.True(t, ok)
+		assert.Equal(t, int64(i), rank)
+		assert.Equal(t, item.score, score)
+	}
+	_, _, ok := skiplist.Rank("nope")
+	assert.False(t, ok)
+
+	for i := 0; i < 100; i++ {
+		start := int64(rander.Intn(len(expected)))
+		stop := start + int64(rander.Intn(len(expected)-int(start)))
+		assert.Equal(t, expected[start:stop+1], skiplist.RangeByRank(start, stop))
+	}
+}
+
+func Test_zset_rank_cmd(t *testing.T) {
+	for _, thinker := range []Thinker{testThinker{}, smallThinker{}} {
+		kvStore := NewKVStore(thinker).(*KVStore)
+		kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "1", "a", "2", "b", "2", "c", "3.5", "d"))
+		bulks := func(values ...string) handler.Reply {
+			res := make([][]byte, 0, len(values))
+			for _, value := range values {
+				res = append(res, []byte(value))
+			}
+			return handler.NewMultiBulkReply(res)
+		}
+
+		assert.Equal(t, handler.NewIntReply(4), kvStore.ZCard(newTestCmd(database.CmdTypeZCard, "z")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.ZCard(newTestCmd(database.CmdTypeZCard, "nope")))
+
+		assert.Equal(t, handler.NewIntReply(2), kvStore.ZRank(newTestCmd(database.CmdTypeZRank, "z", "c")))
+		assert.Equal(t, handler.NewIntReply(1), kvStore.ZRevRank(newTestCmd(database.CmdTypeZRevRank, "z", "c")))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{handler.NewIntReply(0), handler.NewBulkReply([]byte("3.5"))}),
+			kvStore.ZRevRank(newTestCmd(database.CmdTypeZRevRank, "z", "d", "withscore")))
+		assert.Equal(t, handler.NewNillReply(), kvStore.ZRank(newTestCmd(database.CmdTypeZRank, "z", "nope")))
+		assert.Equal(t, handler.NewNillMultiBulkReply(), kvStore.ZRank(newTestCmd(database.CmdTypeZRank, "nope", "a", "withscore")))
+
+		assert.Equal(t, bulks("a", "b", "c", "d"), kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "z", "0", "-1")))
+		assert.Equal(t, bulks("b", "2", "c", "2"), kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "z", "1", "2", "withscores")))
+		assert.Equal(t, bulks("d", "c"), kvStore.ZRevRange(newTestCmd(database.CmdTypeZRevRange, "z", "0", "1")))
+		assert.Equal(t, bulks("b", "a"), kvStore.ZRevRange(newTestCmd(database.CmdTypeZRevRange, "z", "-2", "100")))
+		assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "z", "3", "1")))
+		assert.True(t, handler.IsErrReply(kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "z", "0", "1", "foo"))))
+
+		// 元素被全部移除后删除 key
+		kvStore.ZRem(newTestCmd(database.CmdTypeZRem, "z", "a", "b", "c", "d"))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "z")))
+	}
+}
+
+func Test_zset_range_spec(t *testing.T) {
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	zsets := []SortedSet{newZSetEntity("", testThresholds), newSkiplist("")}
+	all := make([]zsetItem, 0, 100)
+	for i := 0; i < 100; i++ {
+		item := zsetItem{member: fmt.Sprintf("m%02d", i), score: float64(rander.Intn(20))}
+		all = append(all, item)
+		for _, zset := range zsets {
+			zset.Add(item.score, item.member)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return zsetLess(all[i].score, all[i].member, all[j].score, all[j].member)
+	})
+
+	// 参照实现：筛选、翻转后再处理 LIMIT
+	expect := func(spec zrangeSpec) []zsetItem {
+		items := []zsetItem{}
+		for _, item := range all {
+			if spec.aboveMin(item.score, item.member) && spec.belowMax(item.score, item.member) {
+				items = append(items, item)
+			}
+		}
+		if spec.reverse {
+			reverseZSetItems(items)
+		}
+		if spec.offset < 0 || spec.offset >= int64(len(items)) {
+			return []zsetItem{}
+		}
+		items = items[spec.offset:]
+		if spec.count >= 0 && spec.count < int64(len(items)) {
+			items = items[:spec.count]
+		}
+		return items
+	}
+
+	for i := 0; i < 500; i++ {
+		spec := zrangeSpec{
+			min:     zsetBound{score: float64(rander.Intn(22) - 1), exclusive: rander.Intn(2) == 0},
+			max:     zsetBound{score: float64(rander.Intn(22) - 1), exclusive: rander.Intn(2) == 0},
+			reverse: rander.Intn(2) == 0,
+			offset:  int64(rander.Intn(30) - 2),
+			count:   int64(rander.Intn(30) - 2),
+		}
+		for _, zset := range zsets {
+			assert.Equal(t, expect(spec), zset.Range(spec), "%+v %s", spec, zset.Encoding())
+		}
+
+		// count 忽略 reverse 与 LIMIT
+		spec.reverse, spec.offset, spec.count = false, 0, -1
+		for _, zset := range zsets {
+			assert.Equal(t, int64(len(expect(spec))), zset.Count(spec), "%+v %s", spec, zset.Encoding())
+		}
+	}
+}
+
+func Test_zset_range_cmd(t *testing.T) {
+	for _, thinker := range []Thinker{testThinker{}, smallThinker{}} {
+		kvStore := NewKVStore(thinker).(*KVStore)
+		kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "1", "a", "2", "b", "2.5", "c", "3", "d", "+inf", "e"))
+		kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "lex", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"))
+		bulks := func(values ...string) handler.Reply {
+			res := make([][]byte, 0, len(values))
+			for _, value := range values {
+				res = append(res, []byte(value))
+			}
+			return handler.NewMultiBulkReply(res)
+		}
+		zrange := func(cmdType database.CmdType, args ...string) handler.Reply {
+			cmd := newTestCmd(cmdType, args...)
+			switch cmdType {
+			case database.CmdTypeZRange:
+				return kvStore.ZRange(cmd)
+			case database.CmdTypeZRangeByScore:
+				return kvStore.ZRangeByScore(cmd)
+			case database.CmdTypeZRevRangeByScore:
+				return kvStore.ZRevRangeByScore(cmd)
+			case database.CmdTypeZRangeByLex:
+				return kvStore.ZRangeByLex(cmd)
+			default:
+				return kvStore.ZRevRangeByLex(cmd)
+			}
+		}
+
+		t.Run("byscore", func(t *testing.T) {
+			assert.Equal(t, bulks("b", "c", "d"), zrange(database.CmdTypeZRangeByScore, "z", "(1", "3"))
+			assert.Equal(t, bulks("c", "2.5", "d", "3"), zrange(database.CmdTypeZRangeByScore, "z", "-inf", "+inf", "withscores", "limit", "2", "2"))
+			assert.Equal(t, bulks("e", "d"), zrange(database.CmdTypeZRevRangeByScore, "z", "+inf", "(2.5"))
+			assert.Equal(t, bulks("d", "c"), zrange(database.CmdTypeZRange, "z", "(inf", "2", "byscore", "rev", "limit", "0", "2"))
+			assert.Equal(t, bulks("e", "inf"), zrange(database.CmdTypeZRange, "z", "(3", "inf", "byscore", "withscores"))
+			assert.Equal(t, handler.NewEmptyMultiBulkReply(), zrange(database.CmdTypeZRangeByScore, "z", "(1", "1"))
+			assert.Equal(t, handler.NewEmptyMultiBulkReply(), zrange(database.CmdTypeZRangeByScore, "nope", "-inf", "inf"))
+		})
+
+		t.Run("bylex", func(t *testing.T) {
+			assert.Equal(t, bulks("a", "b", "c"), zrange(database.CmdTypeZRangeByLex, "lex", "-", "[c"))
+			assert.Equal(t, bulks("e", "d"), zrange(database.CmdTypeZRevRangeByLex, "lex", "+", "(c"))
+			assert.Equal(t, bulks("c", "b"), zrange(database.CmdTypeZRange, "lex", "[d", "-", "bylex", "rev", "limit", "1", "2"))
+		})
+
+		t.Run("rank", func(t *testing.T) {
+			assert.Equal(t, bulks("e", "d"), zrange(database.CmdTypeZRange, "z", "0", "1", "rev"))
+			assert.Equal(t, bulks("a", "1"), zrange(database.CmdTypeZRange, "z", "0", "0", "withscores"))
+		})
+
+		t.Run("error", func(t *testing.T) {
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRange, "z", "0", "1", "limit", "0", "1")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRange, "lex", "-", "+", "bylex", "withscores")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRange, "z", "0", "1", "byscore", "bylex")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRangeByScore, "z", "a", "1")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRangeByLex, "lex", "a", "+")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRangeByScore, "z", "0", "1", "rev")))
+			assert.True(t, handler.IsErrReply(zrange(database.CmdTypeZRangeByScore, "z", "0", "1", "limit", "0")))
+		})
+
+		t.Run("store", func(t *testing.T) {
+			assert.Equal(t, handler.NewIntReply(2), kvStore.ZRangeStore(newTestCmd(database.CmdTypeZRangeStore, "dst", "z", "2", "(3", "byscore")))
+			assert.Equal(t, bulks("b", "2", "c", "2.5"), zrange(database.CmdTypeZRange, "dst", "0", "-1", "withscores"))
+			assert.True(t, handler.IsErrReply(kvStore.ZRangeStore(newTestCmd(database.CmdTypeZRangeStore, "dst", "z", "0", "1", "withscores"))))
+
+			// 结果为空时删除 dst
+			assert.Equal(t, handler.NewIntReply(0), kvStore.ZRangeStore(newTestCmd(database.CmdTypeZRangeStore, "dst", "z", "5", "6")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "dst")))
+		})
+	}
+}
+
+func Test_zset_score_cmd(t *testing.T) {
+	for _, thinker := range []Thinker{testThinker{}, smallThinker{}} {
+		kvStore := NewKVStore(thinker).(*KVStore)
+		zadd := func(args ...string) handler.Reply {
+			return kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, append([]string{"z"}, args...)...))
+		}
+		score := func(member string) handler.Reply {
+			return kvStore.ZScore(newTestCmd(database.CmdTypeZScore, "z", member))
+		}
+
+		t.Run("zadd flags", func(t *testing.T) {
+			assert.Equal(t, handler.NewIntReply(0), zadd("xx", "1", "a"))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "z")))
+
+			assert.Equal(t, handler.NewIntReply(3), zadd("1", "a", "2", "b", "3", "c"))
+			assert.Equal(t, handler.NewIntReply(1), zadd("nx", "10", "a", "4", "d"))
+			assert.Equal(t, handler.NewBulkReply([]byte("1")), score("a"))
+			assert.Equal(t, handler.NewIntReply(1), zadd("xx", "ch", "5", "a", "5", "e", "2", "b"))
+			assert.Equal(t, handler.NewNillReply(), score("e"))
+
+			// gt lt 仅限制已存在元素的更新
+			assert.Equal(t, handler.NewIntReply(2), zadd("gt", "ch", "1", "a", "9", "b", "0", "f"))
+			assert.Equal(t, handler.NewIntReply(1), zadd("lt", "ch", "7", "a", "0.5", "c"))
+			assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("5"), []byte("9"), []byte("0.5"), nil}),
+				kvStore.ZMScore(newTestCmd(database.CmdTypeZMScore, "z", "a", "b", "c", "nope")))
+
+			assert.Equal(t, handler.NewBulkReply([]byte("6.5")), zadd("incr", "1.5", "a"))
+			assert.Equal(t, handler.NewNillReply(), zadd("incr", "gt", "-1", "a"))
+			assert.Equal(t, handler.NewNillReply(), zadd("nx", "incr", "1", "a"))
+
+			assert.True(t, handler.IsErrReply(zadd("nx", "xx", "1", "a")))
+			assert.True(t, handler.IsErrReply(zadd("gt", "lt", "1", "a")))
+			assert.True(t, handler.IsErrReply(zadd("nx", "gt", "1", "a")))
+			assert.True(t, handler.IsErrReply(zadd("incr", "1", "a", "2", "b")))
+			assert.True(t, handler.IsErrReply(zadd("1", "a", "2")))
+			assert.True(t, handler.IsErrReply(zadd("ch")))
+			assert.True(t, handler.IsErrReply(zadd("x", "a")))
+		})
+
+		t.Run("zincrby", func(t *testing.T) {
+			cmd := newTestCmd(database.CmdTypeZIncrBy, "z", "-0.25", "a")
+			assert.Equal(t, handler.NewBulkReply([]byte("6.25")), kvStore.ZIncrBy(cmd))
+			assert.Equal(t, handler.NewBulkReply([]byte("3")), kvStore.ZIncrBy(newTestCmd(database.CmdTypeZIncrBy, "z", "3", "new")))
+
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "inf", "inf", "a"))
+			assert.True(t, handler.IsErrReply(kvStore.ZIncrBy(newTestCmd(database.CmdTypeZIncrBy, "inf", "-inf", "a"))))
+			assert.True(t, handler.IsErrReply(kvStore.ZIncrBy(newTestCmd(database.CmdTypeZIncrBy, "z", "foo", "a"))))
+
+			// nan 错误不会遗留空的 zset
+			assert.True(t, handler.IsErrReply(kvStore.ZIncrBy(newTestCmd(database.CmdTypeZIncrBy, "e", "nan", "a"))))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "e")))
+		})
+
+		t.Run("count", func(t *testing.T) {
+			// a:6.25 b:9 c:0.5 d:4 f:0 new:3
+			assert.Equal(t, handler.NewIntReply(3), kvStore.ZCount(newTestCmd(database.CmdTypeZCount, "z", "(0.5", "6.25")))
+			assert.Equal(t, handler.NewIntReply(6), kvStore.ZCount(newTestCmd(database.CmdTypeZCount, "z", "-inf", "+inf")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.ZCount(newTestCmd(database.CmdTypeZCount, "z", "5", "1")))
+			assert.True(t, handler.IsErrReply(kvStore.ZCount(newTestCmd(database.CmdTypeZCount, "z", "a", "1"))))
+
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "lex", "0", "a", "0", "b", "0", "c", "0", "d"))
+			assert.Equal(t, handler.NewIntReply(2), kvStore.ZLexCount(newTestCmd(database.CmdTypeZLexCount, "lex", "(a", "[c")))
+			assert.Equal(t, handler.NewIntReply(4), kvStore.ZLexCount(newTestCmd(database.CmdTypeZLexCount, "lex", "-", "+")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.ZLexCount(newTestCmd(database.CmdTypeZLexCount, "nope", "-", "+")))
+			assert.True(t, handler.IsErrReply(kvStore.ZLexCount(newTestCmd(database.CmdTypeZLexCount, "lex", "a", "+"))))
+		})
+	}
+}
+
+func Test_zset_pop_algebra_cmd(t *testing.T) {
+	for _, thinker := range []Thinker{testThinker{}, smallThinker{}} {
+		kvStore := NewKVStore(thinker).(*KVStore)
+		items := func(pairs ...string) handler.Reply {
+			args := make([][]byte, 0, len(pairs))
+			for _, pair := range pairs {
+				args = append(args, []byte(pair))
+			}
+			return handler.NewMultiBulkReply(args)
+		}
+
+		t.Run("pop", func(t *testing.T) {
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "1", "a", "2", "b", "3", "c", "4", "d"))
+			assert.Equal(t, items("a", "1"), kvStore.ZPopMin(newTestCmd(database.CmdTypeZPopMin, "z")))
+			assert.Equal(t, items("d", "4", "c", "3"), kvStore.ZPopMax(newTestCmd(database.CmdTypeZPopMax, "z", "2")))
+			assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.ZPopMin(newTestCmd(database.CmdTypeZPopMin, "z", "0")))
+			assert.True(t, handler.IsErrReply(kvStore.ZPopMin(newTestCmd(database.CmdTypeZPopMin, "z", "-1"))))
+
+			// 弹出最后一个元素后删除 key
+			assert.Equal(t, items("b", "2"), kvStore.ZPopMin(newTestCmd(database.CmdTypeZPopMin, "z", "10")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "z")))
+			assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.ZPopMax(newTestCmd(database.CmdTypeZPopMax, "z")))
+
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "1", "a", "2", "b"))
+			cmd := newTestCmd(database.CmdTypeBZPopMax, "nope", "z", "0")
+			assert.Equal(t, items("z", "b", "2"), kvStore.BZPopMax(cmd))
+			assert.Equal(t, handler.NewIntReply(1), kvStore.ZCard(newTestCmd(database.CmdTypeZCard, "z")))
+		})
+
+		t.Run("randmember", func(t *testing.T) {
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "r", "1", "a", "2", "b", "3", "c"))
+			assert.Equal(t, handler.NewNillReply(), kvStore.ZRandMember(newTestCmd(database.CmdTypeZRandMember, "nope")))
+			assert.Equal(t, items("a", "1", "b", "2", "c", "3"),
+				kvStore.ZRandMember(newTestCmd(database.CmdTypeZRandMember, "r", "5", "withscores")))
+
+			reply := kvStore.ZRandMember(newTestCmd(database.CmdTypeZRandMember, "r", "-5")).(*handler.MultiBulkReply)
+			assert.Len(t, reply.Args(), 5)
+			assert.True(t, handler.IsErrReply(kvStore.ZRandMember(newTestCmd(database.CmdTypeZRandMember, "r", "1", "foo"))))
+		})
+
+		t.Run("algebra", func(t *testing.T) {
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z1", "1", "a", "2", "b", "3", "c"))
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "z2", "10", "b", "20", "c", "30", "d"))
+			kvStore.SAdd(newTestCmd(database.CmdTypeSAdd, "s", "c", "d"))
+
+			assert.Equal(t, items("a", "1", "b", "12", "c", "23", "d", "30"),
+				kvStore.ZUnion(newTestCmd(database.CmdTypeZUnion, "2", "z1", "z2", "withscores")))
+			assert.Equal(t, items("a", "2", "b", "4", "c", "6", "d", "30"),
+				kvStore.ZUnion(newTestCmd(database.CmdTypeZUnion, "3", "z1", "z2", "nope", "weights", "2", "1", "1", "aggregate", "min", "withscores")))
+			// set 中元素的 score 视为 1
+			assert.Equal(t, items("c", "1"),
+				kvStore.ZInter(newTestCmd(database.CmdTypeZInter, "3", "z1", "z2", "s", "aggregate", "min", "withscores")))
+			assert.Equal(t, items("a", "b"), kvStore.ZDiff(newTestCmd(database.CmdTypeZDiff, "2", "z1", "s")))
+
+			assert.Equal(t, handler.NewIntReply(2), kvStore.ZInterStore(newTestCmd(database.CmdTypeZInterStore, "dst", "2", "z1", "z2", "aggregate", "max")))
+			assert.Equal(t, items("b", "10", "c", "20"), kvStore.ZRange(newTestCmd(database.CmdTypeZRange, "dst", "0", "-1", "withscores")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.ZDiffStore(newTestCmd(database.CmdTypeZDiffStore, "dst", "2", "z1", "z1")))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "dst")))
+
+			assert.True(t, handler.IsErrReply(kvStore.ZUnion(newTestCmd(database.CmdTypeZUnion, "0", "z1"))))
+			assert.True(t, handler.IsErrReply(kvStore.ZUnion(newTestCmd(database.CmdTypeZUnion, "3", "z1", "z2"))))
+			assert.True(t, handler.IsErrReply(kvStore.ZUnion(newTestCmd(database.CmdTypeZUnion, "2", "z1", "z2", "weights", "1", "x"))))
+			assert.True(t, handler.IsErrReply(kvStore.ZDiff(newTestCmd(database.CmdTypeZDiff, "1", "z1", "weights", "1"))))
+			assert.True(t, handler.IsErrReply(kvStore.ZUnionStore(newTestCmd(database.CmdTypeZUnionStore, "dst", "1", "z1", "withscores"))))
+
+			kvStore.Set(newTestCmd(database.CmdTypeSet, "str", "v"))
+			assert.True(t, handler.IsErrReply(kvStore.ZInter(newTestCmd(database.CmdTypeZInter, "2", "z1", "str"))))
+
+			// 源 key 没有在指令表中声明，由 handler 处理过期
+			kvStore.ZAdd(newTestCmd(database.CmdTypeZAdd, "expired", "1", "x"))
+			kvStore.expire("expired", lib.TimeNow().Add(-1))
+			assert.Equal(t, handler.NewIntReply(0), kvStore.ZUnionStore(newTestCmd(database.CmdTypeZUnionStore, "dst", "1", "expired")))
+			_, ok := kvStore.data["expired"]
+			assert.False(t, ok)
+		})
+	}
+}
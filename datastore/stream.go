@@ -0,0 +1,888 @@
+package datastore
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+	"github.com/xiaoxuxiansheng/goredis/lib"
+)
+
+func (k *KVStore) getAsStream(key string) (Stream, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	stream, ok := v.(Stream)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return stream, nil
+}
+
+func (k *KVStore) putAsStream(key string, stream Stream) {
+	k.data[key] = stream
+}
+
+func newInvalidStreamIDErrReply() handler.Reply {
+	return handler.NewErrReply("ERR Invalid stream ID specified as stream command argument")
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func (k *KVStore) XAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	opts, idIndex, errReply := parseStreamOptions(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	if fields := len(args) - idIndex - 1; fields == 0 || fields%2 != 0 {
+		return handler.NewErrReply("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	// 与 redis 一致，ms-* 表示由服务端生成 seq，* 表示 ms 与 seq 均由服务端生成
+	var (
+		id       streamID
+		autoMs   = string(args[idIndex]) == "*"
+		autoSeq  = autoMs
+		parsedOK bool
+	)
+	if !autoMs {
+		if ms := strings.TrimSuffix(string(args[idIndex]), "-*"); ms != string(args[idIndex]) {
+			id.ms, parsedOK = parseStreamIDPart(ms)
+			autoSeq = true
+		} else {
+			id, parsedOK = parseStreamID(args[idIndex], 0)
+		}
+		if !parsedOK {
+			return newInvalidStreamIDErrReply()
+		}
+		if !autoSeq && id == (streamID{}) {
+			return handler.NewErrReply("ERR The ID specified in XADD must be greater than 0-0")
+		}
+	}
+
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil && opts.noMkStream {
+		cmd.Unchanged()
+		return handler.NewNillReply()
+	}
+
+	var last streamID
+	if stream != nil {
+		last = stream.LastID()
+	}
+	switch {
+	case autoMs:
+		if last == maxStreamID {
+			return handler.NewErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		if ms := uint64(lib.TimeNow().UnixMilli()); ms > last.ms {
+			id = streamID{ms: ms}
+		} else {
+			id, _ = last.incr()
+		}
+	case autoSeq:
+		if id.ms == last.ms && last.seq < math.MaxUint64 {
+			id.seq = last.seq + 1
+		} else if id.ms > last.ms {
+			id.seq = 0
+		} else {
+			return handler.NewErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	default:
+		if !last.Less(id) {
+			return handler.NewErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+
+	if stream == nil {
+		stream = newStreamEntity(key)
+		k.putAsStream(key, stream)
+	}
+	stream.Add(id, args[idIndex+1:])
+	stream.Trim(opts.trim)
+
+	// 持久化时使用生成的 ID，保证 aof 回放得到相同的 ID
+	rewritten := make([][]byte, 0, len(args)+1)
+	rewritten = append(rewritten, []byte(database.CmdTypeXAdd))
+	rewritten = append(rewritten, args[:idIndex]...)
+	rewritten = append(rewritten, []byte(id.String()))
+	rewritten = append(rewritten, args[idIndex+1:]...)
+	cmd.Rewrite(rewritten)
+	return handler.NewBulkReply([]byte(id.String()))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func (k *KVStore) XTrim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	opts, _, errReply := parseStreamOptions(args, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	trimmed := stream.Trim(opts.trim)
+	if trimmed == 0 {
+		cmd.Unchanged()
+	}
+	return handler.NewIntReply(trimmed)
+}
+
+// 条目被全部删除后，stream 仍然保留，以便记录最后一个 ID
+func (k *KVStore) XDel(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	ids := make([]streamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return newInvalidStreamIDErrReply()
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		cmd.Unchanged()
+		return handler.NewIntReply(0)
+	}
+
+	var deleted int64
+	for _, id := range ids {
+		if stream.Del(id) {
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		cmd.Unchanged()
+	}
+	return handler.NewIntReply(deleted)
+}
+
+func (k *KVStore) XLen(cmd *database.Command) handler.Reply {
+	stream, err := k.getAsStream(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(stream.Len())
+}
+
+func (k *KVStore) XRange(cmd *database.Command) handler.Reply {
+	return k.xrange(cmd, false)
+}
+
+func (k *KVStore) XRevRange(cmd *database.Command) handler.Reply {
+	return k.xrange(cmd, true)
+}
+
+// XRANGE key start end [COUNT count]，XREVRANGE key end start [COUNT count]
+func (k *KVStore) xrange(cmd *database.Command, reverse bool) handler.Reply {
+	args := cmd.Args()
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseStreamRangeBound(startArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseStreamRangeBound(endArg, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	count := int64(-1)
+	for i := 3; i < len(args); i++ {
+		if strings.ToLower(string(args[i])) != "count" || i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+		if count, errReply = parseInt(args[i+1]); errReply != nil {
+			return errReply
+		}
+		if count < 0 {
+			count = 0
+		}
+		i++
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	// 与 redis 一致，count 为 0 时返回 nil
+	if count == 0 {
+		return handler.NewNillMultiBulkReply()
+	}
+	if count < 0 {
+		count = 0
+	}
+	return newStreamEntriesReply(stream.Range(start, end, count, reverse))
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func (k *KVStore) XRead(cmd *database.Command) handler.Reply {
+	readArgs, errReply := parseStreamReadArgs(cmd, false)
+	if errReply != nil {
+		return errReply
+	}
+	keys, idArgs, count := readArgs.keys, readArgs.ids, readArgs.count
+
+	// streams 之后的 key 位置不固定，没有在指令表中声明，需要自行处理过期
+	for _, key := range keys {
+		k.ExpirePreprocess(string(key))
+	}
+
+	ids := make([]streamID, len(keys))
+	for i, key := range keys {
+		stream, err := k.getAsStream(string(key))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if string(idArgs[i]) == "$" {
+			if stream != nil {
+				ids[i] = stream.LastID()
+			}
+			continue
+		}
+		id, ok := parseStreamID(idArgs[i], 0)
+		if !ok {
+			return newInvalidStreamIDErrReply()
+		}
+		ids[i] = id
+	}
+
+	replies := make([]handler.Reply, 0, len(keys))
+	for i, key := range keys {
+		stream, _ := k.getAsStream(string(key))
+		if stream == nil {
+			continue
+		}
+		// 读取 ID 大于给定 ID 的条目
+		start, ok := ids[i].incr()
+		if !ok {
+			continue
+		}
+		if entries := stream.Range(start, maxStreamID, count, false); len(entries) > 0 {
+			replies = append(replies, handler.NewArrayReply([]handler.Reply{
+				handler.NewBulkReply(key), newStreamEntriesReply(entries),
+			}))
+		}
+	}
+	if len(replies) > 0 {
+		return handler.NewArrayReply(replies)
+	}
+	if !readArgs.block {
+		return handler.NewNillMultiBulkReply()
+	}
+
+	// 阻塞前将 $ 替换为当前最后一个条目的 ID，被唤醒重新执行时只读取阻塞之后写入的条目
+	for i := range idArgs {
+		idArgs[i] = []byte(ids[i].String())
+	}
+	cmd.Block(keys, readArgs.timeout, handler.NewNillMultiBulkReply())
+	return nil
+}
+
+type streamReadArgs struct {
+	count   int64
+	block   bool
+	timeout time.Duration
+	// 仅用于 xreadgroup
+	group, consumer string
+	noAck           bool
+	keys, ids       [][]byte
+}
+
+// 解析 xread 与 xreadgroup 的参数. keys 与 ids 引用了指令的参数，修改 ids 会作用到指令上
+func parseStreamReadArgs(cmd *database.Command, xreadgroup bool) (streamReadArgs, handler.Reply) {
+	args := cmd.Args()
+	readArgs := streamReadArgs{}
+	var groupGiven bool
+	streams := -1
+	for i := 0; i < len(args) && streams < 0; i++ {
+		moreArgs := len(args) - 1 - i
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "count" && moreArgs > 0:
+			count, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return readArgs, errReply
+			}
+			if count > 0 {
+				readArgs.count = count
+			}
+			i++
+		case opt == "block" && moreArgs > 0:
+			timeout, errReply := parseStreamBlockTimeout(args[i+1])
+			if errReply != nil {
+				return readArgs, errReply
+			}
+			readArgs.block, readArgs.timeout = true, timeout
+			i++
+		case opt == "group" && moreArgs > 1:
+			if !xreadgroup {
+				return readArgs, handler.NewErrReply("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			readArgs.group, readArgs.consumer, groupGiven = string(args[i+1]), string(args[i+2]), true
+			i += 2
+		case opt == "noack" && xreadgroup:
+			readArgs.noAck = true
+		case opt == "streams":
+			streams = i + 1
+		default:
+			return readArgs, handler.NewSyntaxErrReply()
+		}
+	}
+	if streams < 0 {
+		return readArgs, handler.NewSyntaxErrReply()
+	}
+	if (len(args)-streams)%2 != 0 || len(args) == streams {
+		return readArgs, handler.NewErrReply(fmt.Sprintf(
+			"ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", cmd.Name()))
+	}
+	if xreadgroup && !groupGiven {
+		return readArgs, handler.NewErrReply("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	readArgs.keys = args[streams : streams+(len(args)-streams)/2]
+	readArgs.ids = args[streams+len(readArgs.keys):]
+	return readArgs, nil
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func (k *KVStore) XSetID(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	id, ok := parseStreamID(args[1], 0)
+	if !ok {
+		return newInvalidStreamIDErrReply()
+	}
+
+	entriesAdded := int64(-1)
+	var (
+		maxDeletedID    streamID
+		maxDeletedGiven bool
+	)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			var errReply handler.Reply
+			if entriesAdded, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			if entriesAdded < 0 {
+				return handler.NewErrReply("ERR entries_added must be positive")
+			}
+		case "maxdeletedid":
+			if maxDeletedID, ok = parseStreamID(args[i+1], 0); !ok {
+				return newInvalidStreamIDErrReply()
+			}
+			if id.Less(maxDeletedID) {
+				return handler.NewErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			maxDeletedGiven = true
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewErrReply("ERR no such key")
+	}
+	if entriesAdded >= 0 && stream.Len() > entriesAdded {
+		return handler.NewErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if entries := stream.Range(streamID{}, maxStreamID, 1, true); len(entries) > 0 && id.Less(entries[0].id) {
+		return handler.NewErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+
+	stream.SetID(id)
+	if entriesAdded >= 0 {
+		stream.SetEntriesAdded(uint64(entriesAdded))
+	}
+	if maxDeletedGiven {
+		stream.SetMaxDeletedID(maxDeletedID)
+	}
+	return handler.NewOKReply()
+}
+
+// 条目回复为 [id, [field, value, ...]] 形式的数组
+func newStreamEntriesReply(entries []streamEntry) handler.Reply {
+	replies := make([]handler.Reply, 0, len(entries))
+	for _, entry := range entries {
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(entry.id.String())), handler.NewMultiBulkReply(entry.fields),
+		}))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// xread 的阻塞时间，单位为毫秒. 0 表示永不超时
+func parseStreamBlockTimeout(arg []byte) (time.Duration, handler.Reply) {
+	timeout, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, handler.NewErrReply("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return 0, handler.NewErrReply("ERR timeout is negative")
+	}
+	if timeout > math.MaxInt64/int64(time.Millisecond) {
+		return 0, handler.NewErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(timeout) * time.Millisecond, nil
+}
+
+// 解析 xrange 的区间端点. - 与 + 分别表示最小与最大的 ID，( 前缀表示开区间. 省略 seq 时，起点取 0，终点取最大值
+func parseStreamRangeBound(arg []byte, start bool) (streamID, handler.Reply) {
+	switch string(arg) {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	missingSeq := uint64(0)
+	if !start {
+		missingSeq = math.MaxUint64
+	}
+	id, ok := parseStreamID(arg, missingSeq)
+	if !ok {
+		return streamID{}, newInvalidStreamIDErrReply()
+	}
+	if !exclusive {
+		return id, nil
+	}
+
+	if start {
+		if id, ok = id.incr(); !ok {
+			return streamID{}, handler.NewErrReply("ERR invalid start ID for the interval")
+		}
+		return id, nil
+	}
+	if id, ok = id.decr(); !ok {
+		return streamID{}, handler.NewErrReply("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+// 裁剪策略. maxLen 不小于 0 时按照长度裁剪，否则 minID 非空时按照 ID 裁剪
+type streamTrimSpec struct {
+	maxLen int64
+	minID  *streamID
+	// 单次至多裁剪的条目数，0 表示不限制
+	limit int64
+}
+
+type streamOptions struct {
+	noMkStream bool
+	trim       streamTrimSpec
+}
+
+// 解析 xadd 与 xtrim 的选项. xadd 为 true 时，遇到首个非选项参数即视为 ID 并返回其下标.
+// 近似裁剪 ~ 按照精确裁剪处理，仍然遵循 LIMIT 的限制
+func parseStreamOptions(args [][]byte, xadd bool) (streamOptions, int, handler.Reply) {
+	opts := streamOptions{trim: streamTrimSpec{maxLen: -1}}
+	var (
+		strategy   string
+		approx     bool
+		limitGiven bool
+		i          = 1
+	)
+options:
+	for ; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		switch opt := strings.ToLower(string(args[i])); {
+		case xadd && opt == "*":
+			break options
+		case (opt == "maxlen" || opt == "minid") && moreArgs > 0:
+			if strategy != "" {
+				return opts, 0, handler.NewErrReply("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			strategy, approx = opt, false
+			if next := string(args[i+1]); moreArgs >= 2 && (next == "~" || next == "=") {
+				approx = next == "~"
+				i++
+			}
+			i++
+			if opt == "maxlen" {
+				maxLen, errReply := parseInt(args[i])
+				if errReply != nil {
+					return opts, 0, errReply
+				}
+				if maxLen < 0 {
+					return opts, 0, handler.NewErrReply("ERR The MAXLEN argument must be >= 0.")
+				}
+				opts.trim.maxLen = maxLen
+				continue
+			}
+			minID, ok := parseStreamID(args[i], 0)
+			if !ok {
+				return opts, 0, newInvalidStreamIDErrReply()
+			}
+			opts.trim.minID = &minID
+		case opt == "limit" && moreArgs > 0:
+			limit, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return opts, 0, errReply
+			}
+			if limit < 0 {
+				return opts, 0, handler.NewErrReply("ERR The LIMIT argument must be >= 0.")
+			}
+			opts.trim.limit, limitGiven = limit, true
+			i++
+		case xadd && opt == "nomkstream":
+			opts.noMkStream = true
+		case xadd:
+			// 非选项参数视为 ID
+			break options
+		default:
+			return opts, 0, handler.NewSyntaxErrReply()
+		}
+	}
+
+	if !xadd && strategy == "" {
+		return opts, 0, handler.NewErrReply("ERR syntax error, XTRIM must be called with a trimming strategy")
+	}
+	if limitGiven && strategy == "" {
+		return opts, 0, handler.NewErrReply("ERR syntax error, LIMIT cannot be used without specifying a trimming strategy")
+	}
+	if limitGiven && !approx {
+		return opts, 0, handler.NewErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if xadd && i >= len(args) {
+		return opts, 0, handler.NewErrReply("ERR wrong number of arguments for 'xadd' command")
+	}
+	return opts, i, nil
+}
+
+// 条目 ID，由毫秒时间戳与同一毫秒内的序号组成，在 stream 内单调递增
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+func (s streamID) Less(other streamID) bool {
+	if s.ms != other.ms {
+		return s.ms < other.ms
+	}
+	return s.seq < other.seq
+}
+
+func (s streamID) String() string {
+	return strconv.FormatUint(s.ms, 10) + "-" + strconv.FormatUint(s.seq, 10)
+}
+
+// 后继 ID，s 已经是最大的 ID 时返回 false
+func (s streamID) incr() (streamID, bool) {
+	switch {
+	case s.seq < math.MaxUint64:
+		return streamID{ms: s.ms, seq: s.seq + 1}, true
+	case s.ms < math.MaxUint64:
+		return streamID{ms: s.ms + 1}, true
+	default:
+		return s, false
+	}
+}
+
+// 前驱 ID，s 为 0-0 时返回 false
+func (s streamID) decr() (streamID, bool) {
+	switch {
+	case s.seq > 0:
+		return streamID{ms: s.ms, seq: s.seq - 1}, true
+	case s.ms > 0:
+		return streamID{ms: s.ms - 1, seq: math.MaxUint64}, true
+	default:
+		return s, false
+	}
+}
+
+// 解析 <ms>-<seq> 形式的 ID，省略 seq 时取 missingSeq
+func parseStreamID(arg []byte, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(string(arg), "-")
+	ms, ok := parseStreamIDPart(msPart)
+	if !ok {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms: ms, seq: missingSeq}, true
+	}
+	seq, ok := parseStreamIDPart(seqPart)
+	if !ok {
+		return streamID{}, false
+	}
+	return streamID{ms: ms, seq: seq}, true
+}
+
+func parseStreamIDPart(part string) (uint64, bool) {
+	v, err := strconv.ParseUint(part, 10, 64)
+	return v, err == nil
+}
+
+type streamEntry struct {
+	id streamID
+	// field 与 value 交替排列
+	fields [][]byte
+}
+
+type Stream interface {
+	Len() int64
+	// 最后一次写入的 ID，条目被删除后仍然保留
+	LastID() streamID
+	// 追加条目，调用方保证 id 大于 LastID
+	Add(id streamID, fields [][]byte)
+	// 返回 [start, end] 范围内的条目，count 大于 0 时至多返回 count 个. reverse 为 true 时按照 ID 从大到小返回
+	Range(start, end streamID, count int64, reverse bool) []streamEntry
+	Del(id streamID) bool
+	// 从头部开始裁剪，返回裁剪的条目数
+	Trim(spec streamTrimSpec) int64
+	SetID(id streamID)
+	SetEntriesAdded(entriesAdded uint64)
+	SetMaxDeletedID(id streamID)
+	// 条目是否仍然存在
+	Exist(id streamID) bool
+	// 消费组，不存在时返回 nil
+	Group(name string) *streamGroup
+	CreateGroup(name string, lastID streamID) bool
+	DestroyGroup(name string) bool
+	Entity
+}
+
+// 条目按照 ID 递增存放在切片中. 追加与头部裁剪的耗时为 O(1)，范围查询通过二分查找定位，删除中间的条目需要移动后续条目
+type streamEntity struct {
+	key     string
+	entries []streamEntry
+	lastID  streamID
+	// 历史上写入过的条目总数，以及被 xdel 删除的最大 ID. 不参与读写逻辑，仅用于持久化时完整还原
+	entriesAdded uint64
+	maxDeletedID streamID
+	groups       map[string]*streamGroup
+}
+
+func newStreamEntity(key string) Stream {
+	return &streamEntity{key: key}
+}
+
+func (s *streamEntity) Len() int64 {
+	return int64(len(s.entries))
+}
+
+func (s *streamEntity) LastID() streamID {
+	return s.lastID
+}
+
+func (s *streamEntity) Add(id streamID, fields [][]byte) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// 第一个 ID 不小于 id 的条目下标
+func (s *streamEntity) search(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].id.Less(id)
+	})
+}
+
+func (s *streamEntity) Range(start, end streamID, count int64, reverse bool) []streamEntry {
+	if end.Less(start) {
+		return []streamEntry{}
+	}
+
+	lo := s.search(start)
+	hi := sort.Search(len(s.entries), func(i int) bool {
+		return end.Less(s.entries[i].id)
+	})
+	if count > 0 && int64(hi-lo) > count {
+		if reverse {
+			lo = hi - int(count)
+		} else {
+			hi = lo + int(count)
+		}
+	}
+
+	entries := make([]streamEntry, 0, hi-lo)
+	entries = append(entries, s.entries[lo:hi]...)
+	if reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries
+}
+
+func (s *streamEntity) Del(id streamID) bool {
+	if !s.Exist(id) {
+		return false
+	}
+
+	i := s.search(id)
+	copy(s.entries[i:], s.entries[i+1:])
+	s.entries[len(s.entries)-1] = streamEntry{}
+	s.entries = s.entries[:len(s.entries)-1]
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+func (s *streamEntity) Trim(spec streamTrimSpec) int64 {
+	var trimmed int
+	switch {
+	case spec.maxLen >= 0 && int64(len(s.entries)) > spec.maxLen:
+		trimmed = len(s.entries) - int(spec.maxLen)
+	case spec.minID != nil:
+		trimmed = s.search(*spec.minID)
+	}
+	if spec.limit > 0 && int64(trimmed) > spec.limit {
+		trimmed = int(spec.limit)
+	}
+
+	// 释放被裁剪条目的引用，底层数组在扩容时被回收
+	for i := 0; i < trimmed; i++ {
+		s.entries[i] = streamEntry{}
+	}
+	s.entries = s.entries[trimmed:]
+	return int64(trimmed)
+}
+
+func (s *streamEntity) Exist(id streamID) bool {
+	i := s.search(id)
+	return i < len(s.entries) && s.entries[i].id == id
+}
+
+func (s *streamEntity) Group(name string) *streamGroup {
+	return s.groups[name]
+}
+
+func (s *streamEntity) CreateGroup(name string, lastID streamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	if s.groups == nil {
+		s.groups = make(map[string]*streamGroup)
+	}
+	s.groups[name] = newStreamGroup(name, lastID)
+	return true
+}
+
+func (s *streamEntity) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+func (s *streamEntity) SetID(id streamID) {
+	s.lastID = id
+}
+
+func (s *streamEntity) SetEntriesAdded(entriesAdded uint64) {
+	s.entriesAdded = entriesAdded
+}
+
+func (s *streamEntity) SetMaxDeletedID(id streamID) {
+	s.maxDeletedID = id
+}
+
+func (s *streamEntity) Rename(key string) {
+	s.key = key
+}
+
+// 条目写入后不再修改，fields 可以共享
+func (s *streamEntity) Clone(key string) Entity {
+	cloned := *s
+	cloned.key = key
+	cloned.entries = append([]streamEntry{}, s.entries...)
+	if s.groups != nil {
+		cloned.groups = make(map[string]*streamGroup, len(s.groups))
+		for name, group := range s.groups {
+			cloned.groups[name] = group.clone()
+		}
+	}
+	return &cloned
+}
+
+func (s *streamEntity) Encoding() string {
+	return "stream"
+}
+
+// 每个条目对应一条携带 ID 的 xadd 指令，首个条目由 ToCmd 还原，其余条目、ID 信息以及消费组由 ExtraCmds 还原.
+// 没有条目时，通过 MAXLEN 0 写入后立即裁剪，得到空的 stream
+func (s *streamEntity) ToCmd() [][]byte {
+	if len(s.entries) > 0 {
+		return streamXAddCmd(s.key, s.entries[0])
+	}
+
+	id := s.lastID
+	if id == (streamID{}) {
+		id = streamID{seq: 1}
+	}
+	return [][]byte{[]byte(database.CmdTypeXAdd), []byte(s.key), []byte("MAXLEN"), []byte("0"), []byte(id.String()), []byte("x"), []byte("y")}
+}
+
+func (s *streamEntity) ExtraCmds() [][][]byte {
+	cmds := make([][][]byte, 0, len(s.entries))
+	for i := 1; i < len(s.entries); i++ {
+		cmds = append(cmds, streamXAddCmd(s.key, s.entries[i]))
+	}
+	cmds = append(cmds, [][]byte{
+		[]byte(database.CmdTypeXSetID), []byte(s.key), []byte(s.lastID.String()),
+		[]byte("ENTRIESADDED"), []byte(strconv.FormatUint(s.entriesAdded, 10)),
+		[]byte("MAXDELETEDID"), []byte(s.maxDeletedID.String()),
+	})
+
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmds = append(cmds, s.groups[name].toCmds(s.key, s.Exist)...)
+	}
+	return cmds
+}
+
+func streamXAddCmd(key string, entry streamEntry) [][]byte {
+	cmd := make([][]byte, 0, 3+len(entry.fields))
+	cmd = append(cmd, []byte(database.CmdTypeXAdd), []byte(key), []byte(entry.id.String()))
+	return append(cmd, entry.fields...)
+}
@@ -0,0 +1,803 @@
+package datastore
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+	"github.com/xiaoxuxiansheng/goredis/lib"
+)
+
+// 消费组. 记录组内最后一个被投递的 ID，以及已投递但尚未确认的条目 (pending entries list)
+type streamGroup struct {
+	name   string
+	lastID streamID
+	// 按照 ID 递增排列，index 用于按照 ID 查找
+	pending   []*streamPendingEntry
+	index     map[streamID]*streamPendingEntry
+	consumers map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	name string
+	// 该消费者名下待确认的条目数
+	pending int64
+}
+
+type streamPendingEntry struct {
+	id       streamID
+	consumer *streamConsumer
+	// 最近一次投递的 unix 毫秒时间戳，以及累计的投递次数
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+func newStreamGroup(name string, lastID streamID) *streamGroup {
+	return &streamGroup{
+		name:      name,
+		lastID:    lastID,
+		index:     make(map[streamID]*streamPendingEntry),
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+// 获取消费者，不存在时创建. created 标识是否为新建
+func (g *streamGroup) consumer(name string) (consumer *streamConsumer, created bool) {
+	if consumer, ok := g.consumers[name]; ok {
+		return consumer, false
+	}
+	consumer = &streamConsumer{name: name}
+	g.consumers[name] = consumer
+	return consumer, true
+}
+
+// 删除消费者，返回其名下被一并删除的待确认条目数
+func (g *streamGroup) delConsumer(name string) (int64, bool) {
+	consumer, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+
+	pending := g.pending[:0]
+	for _, entry := range g.pending {
+		if entry.consumer == consumer {
+			delete(g.index, entry.id)
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	for i := len(pending); i < len(g.pending); i++ {
+		g.pending[i] = nil
+	}
+	g.pending = pending
+	delete(g.consumers, name)
+	return consumer.pending, true
+}
+
+// 第一个 ID 不小于 id 的待确认条目下标
+func (g *streamGroup) search(id streamID) int {
+	return sort.Search(len(g.pending), func(i int) bool {
+		return !g.pending[i].id.Less(id)
+	})
+}
+
+// 将条目记为投递给 consumer. 条目不在 pending 中时按序插入，否则转移归属
+func (g *streamGroup) deliver(id streamID, consumer *streamConsumer, deliveryTime, deliveryCount int64) {
+	entry, ok := g.index[id]
+	if !ok {
+		entry = &streamPendingEntry{id: id}
+		i := g.search(id)
+		g.pending = append(g.pending, nil)
+		copy(g.pending[i+1:], g.pending[i:])
+		g.pending[i] = entry
+		g.index[id] = entry
+	} else {
+		entry.consumer.pending--
+	}
+
+	entry.consumer = consumer
+	consumer.pending++
+	entry.deliveryTime, entry.deliveryCount = deliveryTime, deliveryCount
+}
+
+func (g *streamGroup) ack(id streamID) bool {
+	entry, ok := g.index[id]
+	if !ok {
+		return false
+	}
+
+	i := g.search(id)
+	copy(g.pending[i:], g.pending[i+1:])
+	g.pending[len(g.pending)-1] = nil
+	g.pending = g.pending[:len(g.pending)-1]
+	delete(g.index, id)
+	entry.consumer.pending--
+	return true
+}
+
+// 返回 [start, end] 范围内的待确认条目. consumer 不为空时仅返回该消费者名下的条目，count 大于 0 时至多返回 count 个
+func (g *streamGroup) pendingRange(start, end streamID, consumer string, count int64) []*streamPendingEntry {
+	entries := make([]*streamPendingEntry, 0)
+	for i := g.search(start); i < len(g.pending) && !end.Less(g.pending[i].id); i++ {
+		if count > 0 && int64(len(entries)) >= count {
+			break
+		}
+		if consumer == "" || g.pending[i].consumer.name == consumer {
+			entries = append(entries, g.pending[i])
+		}
+	}
+	return entries
+}
+
+func (g *streamGroup) clone() *streamGroup {
+	cloned := newStreamGroup(g.name, g.lastID)
+	for name := range g.consumers {
+		cloned.consumer(name)
+	}
+	for _, entry := range g.pending {
+		consumer, _ := cloned.consumer(entry.consumer.name)
+		cloned.deliver(entry.id, consumer, entry.deliveryTime, entry.deliveryCount)
+	}
+	return cloned
+}
+
+// 依次还原消费组、消费者以及待确认条目. 与 redis 一致，对应条目已被删除的待确认记录不再保留
+func (g *streamGroup) toCmds(key string, exist func(id streamID) bool) [][][]byte {
+	cmds := [][][]byte{{[]byte(database.CmdTypeXGroup), []byte("CREATE"), []byte(key), []byte(g.name), []byte(g.lastID.String())}}
+
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmds = append(cmds, xgroupCmd("CREATECONSUMER", key, g.name, name))
+	}
+
+	for _, entry := range g.pending {
+		if exist(entry.id) {
+			cmds = append(cmds, xclaimCmd(key, g.name, entry))
+		}
+	}
+	return cmds
+}
+
+func xgroupCmd(sub, key, group string, args ...string) [][]byte {
+	cmd := [][]byte{[]byte(database.CmdTypeXGroup), []byte(sub), []byte(key), []byte(group)}
+	for _, arg := range args {
+		cmd = append(cmd, []byte(arg))
+	}
+	return cmd
+}
+
+// 以确定性的方式还原待确认条目的归属、投递时间与投递次数
+func xclaimCmd(key, group string, entry *streamPendingEntry) [][]byte {
+	return [][]byte{
+		[]byte(database.CmdTypeXClaim), []byte(key), []byte(group), []byte(entry.consumer.name), []byte("0"), []byte(entry.id.String()),
+		[]byte("TIME"), []byte(strconv.FormatInt(entry.deliveryTime, 10)),
+		[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(entry.deliveryCount, 10)),
+		[]byte("FORCE"), []byte("JUSTID"),
+	}
+}
+
+func xackCmd(key, group string, id streamID) [][]byte {
+	return [][]byte{[]byte(database.CmdTypeXAck), []byte(key), []byte(group), []byte(id.String())}
+}
+
+func newNoGroupErrReply(key, group string) handler.Reply {
+	return handler.NewErrReply(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+}
+
+// 获取 key 对应 stream 中的消费组，stream 或者消费组不存在时返回 NOGROUP 错误
+func (k *KVStore) getStreamGroup(key, group string) (Stream, *streamGroup, handler.Reply) {
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return nil, nil, handler.NewErrReply(err.Error())
+	}
+	if stream == nil || stream.Group(group) == nil {
+		return nil, nil, newNoGroupErrReply(key, group)
+	}
+	return stream, stream.Group(group), nil
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM]
+// XGROUP SETID key group id|$
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func (k *KVStore) XGroup(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	sub := strings.ToLower(string(args[0]))
+	var valid bool
+	switch sub {
+	case "create":
+		valid = len(args) == 4 || len(args) == 5
+	case "setid", "createconsumer", "delconsumer":
+		valid = len(args) == 4
+	case "destroy":
+		valid = len(args) == 3
+	default:
+		return handler.NewErrReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", args[0]))
+	}
+	if !valid {
+		return handler.NewErrReply(fmt.Sprintf("ERR wrong number of arguments for 'xgroup|%s' command", sub))
+	}
+
+	key, name := string(args[1]), string(args[2])
+	mkStream := len(args) == 5
+	if mkStream && strings.ToLower(string(args[4])) != "mkstream" {
+		return handler.NewSyntaxErrReply()
+	}
+
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil && !mkStream {
+		return handler.NewErrReply("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+
+	var id streamID
+	if sub == "create" || sub == "setid" {
+		switch {
+		case string(args[3]) == "$":
+			if stream != nil {
+				id = stream.LastID()
+			}
+		default:
+			var ok bool
+			if id, ok = parseStreamID(args[3], 0); !ok {
+				return newInvalidStreamIDErrReply()
+			}
+		}
+	}
+
+	if sub == "create" {
+		if stream == nil {
+			stream = newStreamEntity(key)
+			k.putAsStream(key, stream)
+		}
+		if !stream.CreateGroup(name, id) {
+			return handler.NewErrReply("BUSYGROUP Consumer Group name already exists")
+		}
+		// $ 持久化为具体的 ID
+		rewritten := xgroupCmd("CREATE", key, name, id.String())
+		if mkStream {
+			rewritten = append(rewritten, args[4])
+		}
+		cmd.Rewrite(rewritten)
+		return handler.NewOKReply()
+	}
+
+	if sub == "destroy" {
+		if !stream.DestroyGroup(name) {
+			cmd.Unchanged()
+			return handler.NewIntReply(0)
+		}
+		return handler.NewIntReply(1)
+	}
+
+	group := stream.Group(name)
+	if group == nil {
+		return handler.NewErrReply(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", name, key))
+	}
+
+	switch sub {
+	case "setid":
+		group.lastID = id
+		cmd.Rewrite(xgroupCmd("SETID", key, name, id.String()))
+		return handler.NewOKReply()
+	case "createconsumer":
+		if _, created := group.consumer(string(args[3])); !created {
+			cmd.Unchanged()
+			return handler.NewIntReply(0)
+		}
+		return handler.NewIntReply(1)
+	default:
+		deleted, ok := group.delConsumer(string(args[3]))
+		if !ok {
+			cmd.Unchanged()
+		}
+		return handler.NewIntReply(deleted)
+	}
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// id 为 > 时读取组内尚未投递的新条目，否则读取该消费者名下 ID 大于给定 ID 的待确认条目.
+// 投递以及消费者的创建均持久化为确定性的 xclaim、xgroup 指令
+func (k *KVStore) XReadGroup(cmd *database.Command) handler.Reply {
+	readArgs, errReply := parseStreamReadArgs(cmd, true)
+	if errReply != nil {
+		return errReply
+	}
+	keys := readArgs.keys
+
+	// streams 之后的 key 位置不固定，没有在指令表中声明，需要自行处理过期
+	for _, key := range keys {
+		k.ExpirePreprocess(string(key))
+	}
+
+	// 校验全部 key 之后再读取，避免部分投递
+	streams := make([]Stream, len(keys))
+	groups := make([]*streamGroup, len(keys))
+	history := make([]*streamID, len(keys))
+	var available bool
+	for i, key := range keys {
+		stream, err := k.getAsStream(string(key))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if stream == nil || stream.Group(readArgs.group) == nil {
+			return handler.NewErrReply(fmt.Sprintf(
+				"NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, readArgs.group))
+		}
+		streams[i], groups[i] = stream, stream.Group(readArgs.group)
+
+		switch idArg := string(readArgs.ids[i]); idArg {
+		case ">":
+			if start, ok := groups[i].lastID.incr(); ok && len(stream.Range(start, maxStreamID, 1, false)) > 0 {
+				available = true
+			}
+		case "$":
+			return handler.NewErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. " +
+				"The $ ID would just return an empty result set.")
+		default:
+			id, ok := parseStreamID(readArgs.ids[i], 0)
+			if !ok {
+				return newInvalidStreamIDErrReply()
+			}
+			// 读取历史条目不会阻塞
+			history[i], available = &id, true
+		}
+	}
+
+	if !available && readArgs.block {
+		cmd.Block(keys, readArgs.timeout, handler.NewNillMultiBulkReply())
+		return nil
+	}
+
+	now := lib.TimeNow().UnixMilli()
+	var (
+		rewrites [][][]byte
+		replies  = make([]handler.Reply, 0, len(keys))
+	)
+	for i, key := range keys {
+		stream, group := streams[i], groups[i]
+		consumer, created := group.consumer(readArgs.consumer)
+		if created {
+			rewrites = append(rewrites, xgroupCmd("CREATECONSUMER", string(key), group.name, consumer.name))
+		}
+
+		if history[i] != nil {
+			var start streamID
+			var ok bool
+			if start, ok = history[i].incr(); !ok {
+				replies = append(replies, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply(key), handler.NewEmptyMultiBulkReply()}))
+				continue
+			}
+
+			// 历史条目已被删除时，以 nil 代替其内容
+			entryReplies := make([]handler.Reply, 0)
+			for _, entry := range group.pendingRange(start, maxStreamID, consumer.name, readArgs.count) {
+				entries := stream.Range(entry.id, entry.id, 1, false)
+				var fields handler.Reply = handler.NewNillMultiBulkReply()
+				if len(entries) > 0 {
+					fields = handler.NewMultiBulkReply(entries[0].fields)
+					group.deliver(entry.id, consumer, now, entry.deliveryCount+1)
+					rewrites = append(rewrites, xclaimCmd(string(key), group.name, entry))
+				}
+				entryReplies = append(entryReplies, handler.NewArrayReply([]handler.Reply{
+					handler.NewBulkReply([]byte(entry.id.String())), fields,
+				}))
+			}
+			replies = append(replies, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply(key), handler.NewArrayReply(entryReplies)}))
+			continue
+		}
+
+		start, ok := group.lastID.incr()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, maxStreamID, readArgs.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+
+		group.lastID = entries[len(entries)-1].id
+		if !readArgs.noAck {
+			for _, entry := range entries {
+				group.deliver(entry.id, consumer, now, 1)
+				rewrites = append(rewrites, xclaimCmd(string(key), group.name, group.index[entry.id]))
+			}
+		}
+		rewrites = append(rewrites, xgroupCmd("SETID", string(key), group.name, group.lastID.String()))
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply(key), newStreamEntriesReply(entries)}))
+	}
+
+	cmd.Rewrite(rewrites...)
+	if len(replies) == 0 {
+		return handler.NewNillMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// 确认条目，从消费组的待确认列表中移除. stream 或者消费组不存在时返回 0
+func (k *KVStore) XAck(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return newInvalidStreamIDErrReply()
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var acked int64
+	if stream != nil {
+		if group := stream.Group(string(args[1])); group != nil {
+			for _, id := range ids {
+				if group.ack(id) {
+					acked++
+				}
+			}
+		}
+	}
+	if acked == 0 {
+		cmd.Unchanged()
+	}
+	return handler.NewIntReply(acked)
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// 不携带范围参数时，返回待确认条目的数量、ID 范围以及各个消费者名下的条目数
+func (k *KVStore) XPending(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key, name := string(args[0]), string(args[1])
+	var (
+		minIdle    int64
+		start, end streamID
+		count      int64
+		consumer   string
+		errReply   handler.Reply
+	)
+	extended := len(args) > 2
+	if extended {
+		rangeArgs := args[2:]
+		if strings.ToLower(string(rangeArgs[0])) == "idle" && len(rangeArgs) > 1 {
+			if minIdle, errReply = parseInt(rangeArgs[1]); errReply != nil {
+				return errReply
+			}
+			rangeArgs = rangeArgs[2:]
+		}
+		if len(rangeArgs) != 3 && len(rangeArgs) != 4 {
+			return handler.NewSyntaxErrReply()
+		}
+		if start, errReply = parseStreamRangeBound(rangeArgs[0], true); errReply != nil {
+			return errReply
+		}
+		if end, errReply = parseStreamRangeBound(rangeArgs[1], false); errReply != nil {
+			return errReply
+		}
+		if count, errReply = parseInt(rangeArgs[2]); errReply != nil {
+			return errReply
+		}
+		if len(rangeArgs) == 4 {
+			consumer = string(rangeArgs[3])
+		}
+	}
+
+	_, group, errReply := k.getStreamGroup(key, name)
+	if errReply != nil {
+		return errReply
+	}
+
+	if !extended {
+		if len(group.pending) == 0 {
+			return handler.NewArrayReply([]handler.Reply{
+				handler.NewIntReply(0), handler.NewNillReply(), handler.NewNillReply(), handler.NewNillMultiBulkReply(),
+			})
+		}
+
+		names := make([]string, 0, len(group.consumers))
+		for name, consumer := range group.consumers {
+			if consumer.pending > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		consumers := make([]handler.Reply, 0, len(names))
+		for _, name := range names {
+			consumers = append(consumers, handler.NewMultiBulkReply([][]byte{
+				[]byte(name), []byte(strconv.FormatInt(group.consumers[name].pending, 10)),
+			}))
+		}
+		return handler.NewArrayReply([]handler.Reply{
+			handler.NewIntReply(int64(len(group.pending))),
+			handler.NewBulkReply([]byte(group.pending[0].id.String())),
+			handler.NewBulkReply([]byte(group.pending[len(group.pending)-1].id.String())),
+			handler.NewArrayReply(consumers),
+		})
+	}
+
+	if count <= 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	now := lib.TimeNow().UnixMilli()
+	replies := make([]handler.Reply, 0)
+	for _, entry := range group.pendingRange(start, end, consumer, 0) {
+		if int64(len(replies)) >= count {
+			break
+		}
+		idle := streamIdle(entry, now)
+		if idle < minIdle {
+			continue
+		}
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(entry.id.String())),
+			handler.NewBulkReply([]byte(entry.consumer.name)),
+			handler.NewIntReply(idle),
+			handler.NewIntReply(entry.deliveryCount),
+		}))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// 条目自最近一次投递以来经过的毫秒数
+func streamIdle(entry *streamPendingEntry, now int64) int64 {
+	if idle := now - entry.deliveryTime; idle > 0 {
+		return idle
+	}
+	return 0
+}
+
+// 转移待确认条目的归属. 条目已从 stream 中删除时，同时将其从待确认列表中移除
+type streamClaimer struct {
+	key      string
+	stream   Stream
+	group    *streamGroup
+	consumer *streamConsumer
+	rewrites [][][]byte
+	// 已被删除的条目
+	deleted []streamID
+}
+
+func (k *KVStore) newStreamClaimer(key, group, consumer string) (*streamClaimer, handler.Reply) {
+	stream, streamGroup, errReply := k.getStreamGroup(key, group)
+	if errReply != nil {
+		return nil, errReply
+	}
+
+	claimer := streamClaimer{key: key, stream: stream, group: streamGroup}
+	var created bool
+	if claimer.consumer, created = streamGroup.consumer(consumer); created {
+		claimer.rewrites = append(claimer.rewrites, xgroupCmd("CREATECONSUMER", key, group, consumer))
+	}
+	return &claimer, nil
+}
+
+// 转移条目的归属. 条目已被删除时将其从待确认列表中移除，并返回 false
+func (c *streamClaimer) claim(id streamID, deliveryTime, deliveryCount int64) bool {
+	if !c.stream.Exist(id) {
+		if c.group.ack(id) {
+			c.deleted = append(c.deleted, id)
+			c.rewrites = append(c.rewrites, xackCmd(c.key, c.group.name, id))
+		}
+		return false
+	}
+
+	c.group.deliver(id, c.consumer, deliveryTime, deliveryCount)
+	c.rewrites = append(c.rewrites, xclaimCmd(c.key, c.group.name, c.group.index[id]))
+	return true
+}
+
+func (c *streamClaimer) reply(ids []streamID, justID bool) handler.Reply {
+	if justID {
+		args := make([][]byte, 0, len(ids))
+		for _, id := range ids {
+			args = append(args, []byte(id.String()))
+		}
+		return handler.NewMultiBulkReply(args)
+	}
+
+	entries := make([]streamEntry, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, c.stream.Range(id, id, 1, false)...)
+	}
+	return newStreamEntriesReply(entries)
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (k *KVStore) XClaim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	minIdle, errReply := parseInt(args[3])
+	if errReply != nil {
+		return errReply
+	}
+
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return newInvalidStreamIDErrReply()
+	}
+
+	now := lib.TimeNow().UnixMilli()
+	var (
+		deliveryTime  = now
+		retryCount    = int64(-1)
+		force, justID bool
+		lastID        *streamID
+	)
+	for ; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "idle" && moreArgs > 0:
+			idle, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			deliveryTime = now - idle
+			i++
+		case opt == "time" && moreArgs > 0:
+			if deliveryTime, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			i++
+		case opt == "retrycount" && moreArgs > 0:
+			if retryCount, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			i++
+		case opt == "lastid" && moreArgs > 0:
+			id, ok := parseStreamID(args[i+1], 0)
+			if !ok {
+				return newInvalidStreamIDErrReply()
+			}
+			lastID = &id
+			i++
+		default:
+			return handler.NewErrReply(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+	}
+	// 与 redis 一致，投递时间不晚于当前时间
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	key, group := string(args[0]), string(args[1])
+	claimer, errReply := k.newStreamClaimer(key, group, string(args[2]))
+	if errReply != nil {
+		return errReply
+	}
+
+	if lastID != nil && claimer.group.lastID.Less(*lastID) {
+		claimer.group.lastID = *lastID
+		claimer.rewrites = append(claimer.rewrites, xgroupCmd("SETID", key, group, lastID.String()))
+	}
+
+	claimed := make([]streamID, 0, len(ids))
+	for _, id := range ids {
+		entry, ok := claimer.group.index[id]
+		// force 时，为尚未投递过的条目创建待确认记录
+		if !ok && !force {
+			continue
+		}
+		// 已被删除的条目不受空闲时间的限制，由 claim 移除
+		if ok && claimer.stream.Exist(id) && streamIdle(entry, now) < minIdle {
+			continue
+		}
+
+		var deliveryCount int64
+		switch {
+		case retryCount >= 0:
+			deliveryCount = retryCount
+		case ok:
+			deliveryCount = entry.deliveryCount
+		}
+		if retryCount < 0 && !justID {
+			deliveryCount++
+		}
+		if claimer.claim(id, deliveryTime, deliveryCount) {
+			claimed = append(claimed, id)
+		}
+	}
+
+	cmd.Rewrite(claimer.rewrites...)
+	return claimer.reply(claimed, justID)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 从 start 开始扫描待确认列表，转移空闲时间不小于 min-idle-time 的条目. 返回下一次扫描的起点、被转移的条目以及已被删除的条目 ID
+func (k *KVStore) XAutoClaim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	minIdle, errReply := parseInt(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	start, errReply := parseStreamRangeBound(args[4], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	count := int64(100)
+	var justID bool
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "justid":
+			justID = true
+		case opt == "count" && i+1 < len(args):
+			if count, errReply = parseInt(args[i+1]); errReply != nil {
+				return errReply
+			}
+			if count < 1 || count > math.MaxInt64/10 {
+				return handler.NewErrReply("ERR COUNT must be > 0")
+			}
+			i++
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	claimer, errReply := k.newStreamClaimer(string(args[0]), string(args[1]), string(args[2]))
+	if errReply != nil {
+		return errReply
+	}
+
+	// 与 redis 一致，单次至多检查 count*10 个条目
+	now := lib.TimeNow().UnixMilli()
+	attempts := count * 10
+	candidates := claimer.group.pendingRange(start, maxStreamID, "", 0)
+	claimed := make([]streamID, 0)
+	var next streamID
+	for _, entry := range candidates {
+		// 扫描提前结束时，下一次从尚未检查的条目开始，否则返回 0-0
+		if attempts == 0 || int64(len(claimed)) >= count {
+			next = entry.id
+			break
+		}
+		attempts--
+
+		if claimer.stream.Exist(entry.id) && streamIdle(entry, now) < minIdle {
+			continue
+		}
+		deliveryCount := entry.deliveryCount
+		if !justID {
+			deliveryCount++
+		}
+		if claimer.claim(entry.id, now, deliveryCount) {
+			claimed = append(claimed, entry.id)
+		}
+	}
+
+	cmd.Rewrite(claimer.rewrites...)
+	deleted := make([][]byte, 0, len(claimer.deleted))
+	for _, id := range claimer.deleted {
+		deleted = append(deleted, []byte(id.String()))
+	}
+	return handler.NewArrayReply([]handler.Reply{
+		handler.NewBulkReply([]byte(next.String())), claimer.reply(claimed, justID), handler.NewMultiBulkReply(deleted),
+	})
+}
@@ -0,0 +1,175 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func Test_stream_group_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	xgroup := func(args ...string) handler.Reply {
+		return kvStore.XGroup(newTestCmd(database.CmdTypeXGroup, args...))
+	}
+	xreadgroup := func(consumer string, args ...string) handler.Reply {
+		return kvStore.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, append([]string{"group", "g", consumer}, args...)...))
+	}
+	streamReply := func(key string, entries ...handler.Reply) handler.Reply {
+		return handler.NewArrayReply([]handler.Reply{handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(key)), handler.NewArrayReply(entries),
+		})})
+	}
+	ids := func(ids ...string) handler.Reply {
+		args := make([][]byte, 0, len(ids))
+		for _, id := range ids {
+			args = append(args, []byte(id))
+		}
+		return handler.NewMultiBulkReply(args)
+	}
+
+	t.Run("xgroup", func(t *testing.T) {
+		assert.True(t, handler.IsErrReply(xgroup("create", "s", "g", "$")))
+		assert.Equal(t, handler.NewOKReply(), xgroup("create", "s", "g", "$", "mkstream"))
+		assert.True(t, handler.IsErrReply(xgroup("create", "s", "g", "0")))
+		assert.True(t, handler.IsErrReply(xgroup("setid", "s", "nope", "0")))
+		assert.True(t, handler.IsErrReply(xgroup("foo", "s", "g")))
+
+		assert.Equal(t, handler.NewOKReply(), xgroup("create", "s", "tmp", "0"))
+		assert.Equal(t, handler.NewIntReply(1), xgroup("destroy", "s", "tmp"))
+		assert.Equal(t, handler.NewIntReply(0), xgroup("destroy", "s", "tmp"))
+		assert.Equal(t, handler.NewIntReply(1), xgroup("createconsumer", "s", "g", "idle"))
+		assert.Equal(t, handler.NewIntReply(0), xgroup("createconsumer", "s", "g", "idle"))
+	})
+
+	t.Run("xreadgroup", func(t *testing.T) {
+		for _, id := range []string{"1-0", "2-0", "3-0"} {
+			kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "s", id, "f", id))
+		}
+
+		assert.Equal(t, streamReply("s", streamEntryReply("1-0", "f", "1-0"), streamEntryReply("2-0", "f", "2-0")),
+			xreadgroup("alice", "count", "2", "streams", "s", ">"))
+		assert.Equal(t, streamReply("s", streamEntryReply("3-0", "f", "3-0")), xreadgroup("bob", "streams", "s", ">"))
+		assert.Equal(t, handler.NewNillMultiBulkReply(), xreadgroup("bob", "streams", "s", ">"))
+
+		// 读取历史条目，已被删除的条目内容为 nil
+		kvStore.XDel(newTestCmd(database.CmdTypeXDel, "s", "1-0"))
+		assert.Equal(t, streamReply("s",
+			handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte("1-0")), handler.NewNillMultiBulkReply()}),
+			streamEntryReply("2-0", "f", "2-0"),
+		), xreadgroup("alice", "streams", "s", "0"))
+		assert.Equal(t, int64(2), kvStore.data["s"].(Stream).Group("g").index[streamID{ms: 2}].deliveryCount)
+
+		assert.True(t, handler.IsErrReply(xreadgroup("alice", "streams", "s", "$")))
+		assert.True(t, handler.IsErrReply(kvStore.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "group", "nope", "c", "streams", "s", ">"))))
+		assert.True(t, handler.IsErrReply(kvStore.XRead(newTestCmd(database.CmdTypeXRead, "group", "g", "c", "streams", "s", ">"))))
+
+		// 没有新条目时阻塞
+		cmd := newTestCmd(database.CmdTypeXReadGroup, "group", "g", "carol", "block", "0", "streams", "s", ">")
+		assert.Nil(t, kvStore.XReadGroup(cmd))
+		_, ok := kvStore.data["s"].(Stream).Group("g").consumers["carol"]
+		assert.False(t, ok)
+	})
+
+	t.Run("xpending", func(t *testing.T) {
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{
+			handler.NewIntReply(3), handler.NewBulkReply([]byte("1-0")), handler.NewBulkReply([]byte("3-0")),
+			handler.NewArrayReply([]handler.Reply{ids("alice", "2"), ids("bob", "1")}),
+		}), kvStore.XPending(newTestCmd(database.CmdTypeXPending, "s", "g")))
+
+		reply := kvStore.XPending(newTestCmd(database.CmdTypeXPending, "s", "g", "-", "+", "10", "bob")).(*handler.ArrayReply)
+		assert.Contains(t, string(reply.ToBytes()), "3-0")
+		assert.NotContains(t, string(reply.ToBytes()), "2-0")
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{}),
+			kvStore.XPending(newTestCmd(database.CmdTypeXPending, "s", "g", "idle", "100000", "-", "+", "10")))
+
+		assert.True(t, handler.IsErrReply(kvStore.XPending(newTestCmd(database.CmdTypeXPending, "s", "nope"))))
+		assert.True(t, handler.IsErrReply(kvStore.XPending(newTestCmd(database.CmdTypeXPending, "s", "g", "-", "+"))))
+	})
+
+	t.Run("xclaim", func(t *testing.T) {
+		// 空闲时间不足，不会转移
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{}),
+			kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "bob", "100000", "2-0")))
+		assert.Equal(t, ids("2-0"),
+			kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "bob", "0", "2-0", "retrycount", "7", "justid")))
+
+		// 已被删除的条目从待确认列表中移除
+		assert.Equal(t, ids(), kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "bob", "0", "1-0", "justid")))
+		group := kvStore.data["s"].(Stream).Group("g")
+		assert.Equal(t, int64(7), group.index[streamID{ms: 2}].deliveryCount)
+		assert.Equal(t, 2, len(group.pending))
+		assert.Equal(t, int64(0), group.consumers["alice"].pending)
+
+		// force 为尚未投递的条目创建待确认记录，lastid 推进消费组的最后一个 ID
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "4-0", "f", "4-0"))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{streamEntryReply("4-0", "f", "4-0")}),
+			kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "alice", "0", "4-0", "force", "lastid", "4-0")))
+		assert.Equal(t, streamID{ms: 4}, group.lastID)
+		assert.Equal(t, int64(1), group.index[streamID{ms: 4}].deliveryCount)
+
+		assert.True(t, handler.IsErrReply(kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "bob", "0", "2-0", "foo"))))
+	})
+
+	t.Run("xautoclaim", func(t *testing.T) {
+		kvStore.XDel(newTestCmd(database.CmdTypeXDel, "s", "3-0"))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte("3-0")), ids("2-0"), ids(),
+		}), kvStore.XAutoClaim(newTestCmd(database.CmdTypeXAutoClaim, "s", "g", "carol", "0", "-", "count", "1", "justid")))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte("0-0")), handler.NewArrayReply([]handler.Reply{streamEntryReply("4-0", "f", "4-0")}), ids("3-0"),
+		}), kvStore.XAutoClaim(newTestCmd(database.CmdTypeXAutoClaim, "s", "g", "carol", "0", "(2-0")))
+		assert.True(t, handler.IsErrReply(kvStore.XAutoClaim(newTestCmd(database.CmdTypeXAutoClaim, "s", "g", "carol", "0", "-", "count", "0"))))
+	})
+
+	t.Run("xack", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.XAck(newTestCmd(database.CmdTypeXAck, "s", "g", "2-0", "9-0")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.XAck(newTestCmd(database.CmdTypeXAck, "s", "nope", "4-0")))
+		assert.True(t, handler.IsErrReply(kvStore.XAck(newTestCmd(database.CmdTypeXAck, "s", "g", "x"))))
+
+		// 删除消费者时，一并删除其名下的待确认条目
+		assert.Equal(t, handler.NewIntReply(1), xgroup("delconsumer", "s", "g", "carol"))
+		assert.Equal(t, 0, len(kvStore.data["s"].(Stream).Group("g").pending))
+	})
+
+	t.Run("to cmd", func(t *testing.T) {
+		xreadgroup("alice", "streams", "s", "0")
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "5-0", "f", "5-0"))
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "6-0", "f", "6-0"))
+		xreadgroup("alice", "count", "1", "streams", "s", ">")
+		xgroup("create", "s", "other", "0")
+		kvStore.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "other", "dave", "0", "6-0", "force", "idle", "500", "retrycount", "3"))
+
+		stream := kvStore.data["s"].(*streamEntity)
+		replayed := NewKVStore(testThinker{}).(*KVStore)
+		replay := func(cmd [][]byte) {
+			args := make([]string, 0, len(cmd)-1)
+			for _, arg := range cmd[1:] {
+				args = append(args, string(arg))
+			}
+			var reply handler.Reply
+			switch database.CmdType(cmd[0]) {
+			case database.CmdTypeXAdd:
+				reply = replayed.XAdd(newTestCmd(database.CmdTypeXAdd, args...))
+			case database.CmdTypeXSetID:
+				reply = replayed.XSetID(newTestCmd(database.CmdTypeXSetID, args...))
+			case database.CmdTypeXGroup:
+				reply = replayed.XGroup(newTestCmd(database.CmdTypeXGroup, args...))
+			case database.CmdTypeXClaim:
+				reply = replayed.XClaim(newTestCmd(database.CmdTypeXClaim, args...))
+			}
+			assert.False(t, handler.IsErrReply(reply))
+		}
+		replay(stream.ToCmd())
+		for _, cmd := range stream.ExtraCmds() {
+			replay(cmd)
+		}
+		assert.Equal(t, stream, replayed.data["s"])
+
+		// clone 深拷贝消费组
+		cloned := stream.Clone("s2").(*streamEntity)
+		cloned.Group("g").ack(streamID{ms: 5})
+		assert.NotNil(t, stream.Group("g").index[streamID{ms: 5}])
+	})
+}
@@ -0,0 +1,193 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func streamEntryReply(id string, fields ...string) handler.Reply {
+	args := make([][]byte, 0, len(fields))
+	for _, field := range fields {
+		args = append(args, []byte(field))
+	}
+	return handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte(id)), handler.NewMultiBulkReply(args)})
+}
+
+func Test_stream_id(t *testing.T) {
+	id, ok := parseStreamID([]byte("5"), 0)
+	assert.True(t, ok)
+	assert.Equal(t, streamID{ms: 5}, id)
+
+	id, ok = parseStreamID([]byte("5-18446744073709551615"), 0)
+	assert.True(t, ok)
+	next, ok := id.incr()
+	assert.True(t, ok)
+	assert.Equal(t, "6-0", next.String())
+	prev, ok := next.decr()
+	assert.True(t, ok)
+	assert.Equal(t, id, prev)
+
+	_, ok = maxStreamID.incr()
+	assert.False(t, ok)
+	_, ok = streamID{}.decr()
+	assert.False(t, ok)
+
+	for _, invalid := range []string{"", "-", "1-", "a-1", "1-2-3", "+1", "-1"} {
+		_, ok = parseStreamID([]byte(invalid), 0)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func Test_stream_entity(t *testing.T) {
+	stream := newStreamEntity("s")
+	for i := uint64(1); i <= 10; i++ {
+		stream.Add(streamID{ms: i}, [][]byte{[]byte("f"), []byte("v")})
+	}
+
+	ids := func(entries []streamEntry) []uint64 {
+		res := make([]uint64, 0, len(entries))
+		for _, entry := range entries {
+			res = append(res, entry.id.ms)
+		}
+		return res
+	}
+	assert.Equal(t, []uint64{3, 4, 5}, ids(stream.Range(streamID{ms: 3}, streamID{ms: 5}, 0, false)))
+	assert.Equal(t, []uint64{5, 4}, ids(stream.Range(streamID{ms: 3}, streamID{ms: 5}, 2, true)))
+	assert.Empty(t, stream.Range(streamID{ms: 5}, streamID{ms: 3}, 0, false))
+
+	assert.True(t, stream.Del(streamID{ms: 4}))
+	assert.False(t, stream.Del(streamID{ms: 4}))
+	assert.Equal(t, int64(9), stream.Len())
+
+	minID := streamID{ms: 5}
+	assert.Equal(t, int64(3), stream.Trim(streamTrimSpec{maxLen: -1, minID: &minID}))
+	assert.Equal(t, int64(2), stream.Trim(streamTrimSpec{maxLen: 0, limit: 2}))
+	assert.Equal(t, []uint64{7, 8, 9, 10}, ids(stream.Range(streamID{}, maxStreamID, 0, false)))
+	assert.Equal(t, int64(0), stream.Trim(streamTrimSpec{maxLen: 10}))
+	assert.Equal(t, streamID{ms: 10}, stream.LastID())
+}
+
+func Test_stream_cmd(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	xadd := func(args ...string) handler.Reply {
+		return kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, append([]string{"s"}, args...)...))
+	}
+
+	t.Run("xadd", func(t *testing.T) {
+		assert.Equal(t, handler.NewBulkReply([]byte("1-1")), xadd("1-1", "a", "1"))
+		assert.Equal(t, handler.NewBulkReply([]byte("1-2")), xadd("1-*", "b", "2"))
+		assert.Equal(t, handler.NewBulkReply([]byte("2-0")), xadd("2", "c", "3"))
+		assert.Equal(t, handler.NewBulkReply([]byte("3-0")), xadd("3-*", "d", "4"))
+
+		assert.True(t, handler.IsErrReply(xadd("3-0", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("2-*", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("0-0", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("a-1", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("*", "e")))
+		assert.True(t, handler.IsErrReply(xadd("maxlen", "-1", "*", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("maxlen", "1", "minid", "1", "*", "e", "5")))
+		assert.True(t, handler.IsErrReply(xadd("maxlen", "1", "limit", "10", "*", "e", "5")))
+
+		// nomkstream 不创建 stream
+		assert.Equal(t, handler.NewNillReply(), kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "nope", "nomkstream", "*", "a", "1")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.Exists(newTestCmd(database.CmdTypeExists, "nope")))
+
+		// 自动生成的 ID 大于已有的 ID
+		id := kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "maxlen", "=", "5", "*", "e", "5")).(*handler.BulkReply)
+		last, ok := parseStreamID(id.Arg, 0)
+		assert.True(t, ok)
+		assert.True(t, streamID{ms: 3}.Less(last))
+		assert.Equal(t, handler.NewIntReply(5), kvStore.XLen(newTestCmd(database.CmdTypeXLen, "s")))
+	})
+
+	t.Run("xrange", func(t *testing.T) {
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "1-1", "a", "1"))
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "1-2", "b", "2"))
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "2-0", "c", "3"))
+
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{streamEntryReply("1-1", "a", "1"), streamEntryReply("1-2", "b", "2")}),
+			kvStore.XRange(newTestCmd(database.CmdTypeXRange, "r", "-", "1")))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{streamEntryReply("2-0", "c", "3"), streamEntryReply("1-2", "b", "2")}),
+			kvStore.XRevRange(newTestCmd(database.CmdTypeXRevRange, "r", "+", "(1-1")))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{streamEntryReply("1-2", "b", "2")}),
+			kvStore.XRange(newTestCmd(database.CmdTypeXRange, "r", "(1-1", "+", "count", "1")))
+		assert.Equal(t, handler.NewNillMultiBulkReply(), kvStore.XRange(newTestCmd(database.CmdTypeXRange, "r", "-", "+", "count", "0")))
+		assert.Equal(t, handler.NewEmptyMultiBulkReply(), kvStore.XRange(newTestCmd(database.CmdTypeXRange, "nope", "-", "+")))
+		assert.True(t, handler.IsErrReply(kvStore.XRange(newTestCmd(database.CmdTypeXRange, "r", "(-", "+"))))
+		assert.True(t, handler.IsErrReply(kvStore.XRange(newTestCmd(database.CmdTypeXRange, "r", "-", "(0-0"))))
+	})
+
+	t.Run("xdel xtrim", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.XDel(newTestCmd(database.CmdTypeXDel, "r", "1-2", "9-9")))
+		assert.Equal(t, handler.NewIntReply(1), kvStore.XTrim(newTestCmd(database.CmdTypeXTrim, "r", "minid", "2")))
+		assert.Equal(t, handler.NewIntReply(0), kvStore.XTrim(newTestCmd(database.CmdTypeXTrim, "r", "maxlen", "1")))
+		assert.True(t, handler.IsErrReply(kvStore.XTrim(newTestCmd(database.CmdTypeXTrim, "r", "limit", "1"))))
+
+		// 条目被全部删除后，stream 以及最后一个 ID 仍然保留
+		assert.Equal(t, handler.NewIntReply(1), kvStore.XTrim(newTestCmd(database.CmdTypeXTrim, "r", "maxlen", "0")))
+		assert.Equal(t, handler.NewSimpleStringReply("stream"), kvStore.Type(newTestCmd(database.CmdTypeType, "r")))
+		assert.True(t, handler.IsErrReply(kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "2-0", "d", "4"))))
+	})
+
+	t.Run("xread", func(t *testing.T) {
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "3-0", "d", "4"))
+		kvStore.XAdd(newTestCmd(database.CmdTypeXAdd, "r", "3-1", "e", "5"))
+		assert.Equal(t, handler.NewArrayReply([]handler.Reply{handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte("r")), handler.NewArrayReply([]handler.Reply{streamEntryReply("3-1", "e", "5")}),
+		})}), kvStore.XRead(newTestCmd(database.CmdTypeXRead, "count", "5", "streams", "nope", "r", "0", "3")))
+
+		assert.Equal(t, handler.NewNillMultiBulkReply(), kvStore.XRead(newTestCmd(database.CmdTypeXRead, "streams", "r", "$")))
+		assert.True(t, handler.IsErrReply(kvStore.XRead(newTestCmd(database.CmdTypeXRead, "streams", "r", "nope", "0"))))
+		assert.True(t, handler.IsErrReply(kvStore.XRead(newTestCmd(database.CmdTypeXRead, "block", "-1", "streams", "r", "0"))))
+
+		// 阻塞前将 $ 替换为具体的 ID
+		cmd := newTestCmd(database.CmdTypeXRead, "block", "0", "streams", "r", "nope", "$", "$")
+		assert.Nil(t, kvStore.XRead(cmd))
+		assert.Equal(t, "3-1", string(cmd.Args()[5]))
+		assert.Equal(t, "0-0", string(cmd.Args()[6]))
+	})
+
+	t.Run("to cmd", func(t *testing.T) {
+		kvStore.XDel(newTestCmd(database.CmdTypeXDel, "r", "3-1"))
+		replayed := NewKVStore(testThinker{}).(*KVStore)
+		replay := func(cmd [][]byte) {
+			args := make([]string, 0, len(cmd)-1)
+			for _, arg := range cmd[1:] {
+				args = append(args, string(arg))
+			}
+			var reply handler.Reply
+			switch database.CmdType(cmd[0]) {
+			case database.CmdTypeXAdd:
+				reply = replayed.XAdd(newTestCmd(database.CmdTypeXAdd, args...))
+			case database.CmdTypeXSetID:
+				reply = replayed.XSetID(newTestCmd(database.CmdTypeXSetID, args...))
+			}
+			assert.False(t, handler.IsErrReply(reply))
+		}
+
+		for _, key := range []string{"s", "r"} {
+			stream := kvStore.data[key].(*streamEntity)
+			replay(stream.ToCmd())
+			for _, cmd := range stream.ExtraCmds() {
+				replay(cmd)
+			}
+			assert.Equal(t, stream, replayed.data[key])
+		}
+
+		// 空的 stream 同样可以还原
+		kvStore.XTrim(newTestCmd(database.CmdTypeXTrim, "r", "maxlen", "0"))
+		stream := kvStore.data["r"].(*streamEntity)
+		replayed = NewKVStore(testThinker{}).(*KVStore)
+		replay(stream.ToCmd())
+		for _, cmd := range stream.ExtraCmds() {
+			replay(cmd)
+		}
+		assert.Equal(t, handler.NewIntReply(0), replayed.XLen(newTestCmd(database.CmdTypeXLen, "r")))
+		assert.Equal(t, stream.lastID, replayed.data["r"].(*streamEntity).lastID)
+		assert.Equal(t, stream.entriesAdded, replayed.data["r"].(*streamEntity).entriesAdded)
+		assert.Equal(t, stream.maxDeletedID, replayed.data["r"].(*streamEntity).maxDeletedID)
+	})
+}
@@ -1,6 +1,11 @@
 package datastore
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/handler"
 )
@@ -19,32 +24,318 @@ func (k *KVStore) getAsString(key string) (String, error) {
 	return str, nil
 }
 
-func (k *KVStore) put(key, value string, insertStrategy bool) int64 {
-	if _, ok := k.data[key]; ok && insertStrategy {
-		return 0
+// 获取可原地修改的字符串. 整数编码的字符串会被转为原始编码，不存在时返回 nil
+func (k *KVStore) getAsRawString(key string) (*stringEntity, error) {
+	str, err := k.getAsString(key)
+	if err != nil || str == nil {
+		return nil, err
+	}
+
+	if raw, ok := str.(*stringEntity); ok {
+		return raw, nil
 	}
 
+	raw := newStringEntity(key, str.Bytes())
+	k.data[key] = raw
+	return raw, nil
+}
+
+// 覆盖写入 key. 除非 keepTTL，否则原有的过期时间会被清除
+func (k *KVStore) put(key string, value []byte, keepTTL bool) {
 	k.data[key] = NewString(key, value)
-	return 1
+	if !keepTTL {
+		k.cancelExpire(key)
+	}
+}
+
+// set 指令的选项
+type setOptions struct {
+	nx, xx, get, keepTTL bool
+	expire               bool
+	expiredAt            time.Time
+}
+
+func parseSetOptions(cmd *database.Command, args [][]byte) (*setOptions, handler.Reply) {
+	var opts setOptions
+	for i := 0; i < len(args); i++ {
+		flag := strings.ToLower(string(args[i]))
+		switch flag {
+		case "nx":
+			if opts.xx {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			opts.nx = true
+		case "xx":
+			if opts.nx {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			opts.xx = true
+		case "get":
+			opts.get = true
+		case "keepttl":
+			if opts.expire {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			opts.keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			// 过期时间相关的选项只能出现一次
+			if opts.expire || opts.keepTTL || i == len(args)-1 {
+				return nil, handler.NewSyntaxErrReply()
+			}
+
+			expiredAt, errReply := parseExpireOption(cmd, flag, args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+
+			opts.expire = true
+			opts.expiredAt = expiredAt
+			i++
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	return &opts, nil
+}
+
+// getex 指令的选项
+type getExOptions struct {
+	persist   bool
+	expire    bool
+	expiredAt time.Time
+}
+
+func parseGetExOptions(cmd *database.Command, args [][]byte) (*getExOptions, handler.Reply) {
+	var opts getExOptions
+	for i := 0; i < len(args); i++ {
+		flag := strings.ToLower(string(args[i]))
+		switch flag {
+		case "persist":
+			if opts.expire {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			opts.persist = true
+		case "ex", "px", "exat", "pxat":
+			if opts.expire || opts.persist || i == len(args)-1 {
+				return nil, handler.NewSyntaxErrReply()
+			}
+
+			expiredAt, errReply := parseExpireOption(cmd, flag, args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+
+			opts.expire = true
+			opts.expiredAt = expiredAt
+			i++
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	return &opts, nil
+}
+
+// 解析 EX PX EXAT PXAT 选项，时间必须为正数
+func parseExpireOption(cmd *database.Command, flag string, arg []byte) (time.Time, handler.Reply) {
+	ttl, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl <= 0 {
+		return time.Time{}, handler.NewErrReply(fmt.Sprintf("ERR invalid expire time in '%s' command", cmd.Name()))
+	}
+
+	unit := time.Second
+	if flag[0] == 'p' {
+		unit = time.Millisecond
+	}
+	return parseExpireTime(cmd, arg, unit, strings.HasSuffix(flag, "at"))
 }
 
+// Bytes 与 GetRange 可能返回内部的字节数组，会被后续的原地修改覆盖，写入回复前需要通过 copyBytes 拷贝
 type String interface {
 	Bytes() []byte
-	database.CmdAdapter
+	Len() int64
+	GetRange(start, end int64) []byte
+	Entity
 }
 
+// 字符串的最大长度，与 redis 的 proto-max-bulk-len 默认值保持一致
+const maxStringLen = 512 << 20
+
+// 不超过该长度的字符串采用 embstr 编码
+const embstrSizeLimit = 44
+
+// 原始编码的字符串. 持有独立的字节数组，支持原地修改
 type stringEntity struct {
-	key, str string
+	key  string
+	data []byte
+}
+
+// 能够无损转为 int64 的字符串，采用整数编码存储
+func NewString(key string, value []byte) String {
+	if v, ok := canonicalInt(value); ok {
+		return newIntStringEntity(key, v)
+	}
+	return newStringEntity(key, value)
+}
+
+// value 通常引用自指令参数，需要拷贝一份，避免原地修改时影响到持久化中的指令
+func newStringEntity(key string, value []byte) *stringEntity {
+	return &stringEntity{key: key, data: append([]byte{}, value...)}
 }
 
-func NewString(key, str string) String {
-	return &stringEntity{key: key, str: str}
+// 回复由连接协程异步写出，期间 stringEntity 可能被 setrange、setbit 等指令原地修改，因此需要拷贝
+func copyBytes(data []byte) []byte {
+	return append([]byte{}, data...)
 }
 
 func (s *stringEntity) Bytes() []byte {
-	return []byte(s.str)
+	return s.data
+}
+
+func (s *stringEntity) Len() int64 {
+	return int64(len(s.data))
+}
+
+func (s *stringEntity) GetRange(start, end int64) []byte {
+	return getRange(s.data, start, end)
+}
+
+func (s *stringEntity) Append(value []byte) int64 {
+	s.data = append(s.data, value...)
+	return s.Len()
+}
+
+// 从 offset 处开始覆盖写入 value，长度不足时以 0 补齐
+func (s *stringEntity) SetRange(offset int64, value []byte) int64 {
+	if end := offset + int64(len(value)); end > s.Len() {
+		s.grow(end)
+	}
+	copy(s.data[offset:], value)
+	return s.Len()
+}
+
+// 扩容到 size 长度，新增部分以 0 补齐
+func (s *stringEntity) grow(size int64) {
+	if size <= s.Len() {
+		return
+	}
+	s.data = append(s.data, make([]byte, size-s.Len())...)
+}
+
+func (s *stringEntity) Rename(key string) {
+	s.key = key
+}
+
+func (s *stringEntity) Clone(key string) Entity {
+	return newStringEntity(key, s.data)
+}
+
+// 短字符串在 redis 中采用 embstr 编码
+func (s *stringEntity) Encoding() string {
+	if len(s.data) <= embstrSizeLimit {
+		return "embstr"
+	}
+	return "raw"
 }
 
 func (s *stringEntity) ToCmd() [][]byte {
-	return [][]byte{[]byte(database.CmdTypeSet), []byte(s.key), []byte(s.str)}
+	return [][]byte{[]byte(database.CmdTypeSet), []byte(s.key), s.data}
+}
+
+// 整数编码的字符串
+type intStringEntity struct {
+	key string
+	val int64
+}
+
+func newIntStringEntity(key string, val int64) *intStringEntity {
+	return &intStringEntity{key: key, val: val}
+}
+
+func (i *intStringEntity) Bytes() []byte {
+	return strconv.AppendInt(nil, i.val, 10)
+}
+
+func (i *intStringEntity) Len() int64 {
+	return int64(len(i.Bytes()))
+}
+
+func (i *intStringEntity) GetRange(start, end int64) []byte {
+	return getRange(i.Bytes(), start, end)
+}
+
+func (i *intStringEntity) Rename(key string) {
+	i.key = key
+}
+
+func (i *intStringEntity) Clone(key string) Entity {
+	return newIntStringEntity(key, i.val)
+}
+
+func (i *intStringEntity) Encoding() string {
+	return "int"
+}
+
+func (i *intStringEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(database.CmdTypeSet), []byte(i.key), i.Bytes()}
+}
+
+// 字符串与 int64 能够相互转换且不丢失信息，如 "012"、"+1" 均不满足
+func canonicalInt(value []byte) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 {
+		return 0, false
+	}
+	str := string(value)
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != str {
+		return 0, false
+	}
+	return v, true
+}
+
+// [start,end] 闭区间，负数表示从尾部倒数
+func getRange(data []byte, start, end int64) []byte {
+	start, end, ok := normalizeRange(start, end, int64(len(data)))
+	if !ok {
+		return []byte{}
+	}
+	return data[start : end+1]
+}
+
+// 将可能为负数的 [start,end] 区间转换为 [0,size) 范围内的下标. 区间为空时返回 false
+func normalizeRange(start, end, size int64) (int64, int64, bool) {
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// 将字符串解析为整数，用于 incr 等指令. 与 redis 一致，不接受前导 0、正号等非规范格式
+func stringToInt(str String) (int64, bool) {
+	if intStr, ok := str.(*intStringEntity); ok {
+		return intStr.val, true
+	}
+	return canonicalInt(str.Bytes())
 }
@@ -0,0 +1,96 @@
+package datastore
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiaoxuxiansheng/goredis/database"
+	"github.com/xiaoxuxiansheng/goredis/handler"
+)
+
+func Test_string_int_encoding(t *testing.T) {
+	for _, str := range []string{"0", "-1", "123", strconv.FormatInt(math.MaxInt64, 10), strconv.FormatInt(math.MinInt64, 10)} {
+		_, ok := NewString("", []byte(str)).(*intStringEntity)
+		assert.True(t, ok, str)
+		assert.Equal(t, str, string(NewString("", []byte(str)).Bytes()))
+	}
+
+	for _, str := range []string{"", "012", "+1", " 1", "1.0", "-0", "9223372036854775808", "abc"} {
+		_, ok := NewString("", []byte(str)).(*stringEntity)
+		assert.True(t, ok, str)
+		assert.Equal(t, str, string(NewString("", []byte(str)).Bytes()))
+	}
+}
+
+func Test_string_incr(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+
+	t.Run("incr", func(t *testing.T) {
+		assert.Equal(t, handler.NewIntReply(1), kvStore.Incr(newTestCmd(database.CmdTypeIncr, "k")))
+		assert.Equal(t, handler.NewIntReply(11), kvStore.IncrBy(newTestCmd(database.CmdTypeIncrBy, "k", "10")))
+		assert.Equal(t, handler.NewIntReply(10), kvStore.Decr(newTestCmd(database.CmdTypeDecr, "k")))
+		assert.Equal(t, handler.NewIntReply(-10), kvStore.DecrBy(newTestCmd(database.CmdTypeDecrBy, "k", "20")))
+		_, ok := kvStore.data["k"].(*intStringEntity)
+		assert.True(t, ok)
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", strconv.FormatInt(math.MaxInt64, 10)))
+		assert.True(t, handler.IsErrReply(kvStore.Incr(newTestCmd(database.CmdTypeIncr, "k"))))
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", strconv.FormatInt(math.MinInt64, 10)))
+		assert.True(t, handler.IsErrReply(kvStore.Decr(newTestCmd(database.CmdTypeDecr, "k"))))
+	})
+
+	t.Run("not_integer", func(t *testing.T) {
+		kvStore.Set(newTestCmd(database.CmdTypeSet, "k", "1.5"))
+		assert.True(t, handler.IsErrReply(kvStore.Incr(newTestCmd(database.CmdTypeIncr, "k"))))
+		assert.True(t, handler.IsErrReply(kvStore.IncrBy(newTestCmd(database.CmdTypeIncrBy, "k", "a"))))
+	})
+
+	t.Run("incr_by_float", func(t *testing.T) {
+		cmd := newTestCmd(database.CmdTypeIncrByFloat, "k", "0.1")
+		assert.Equal(t, handler.NewBulkReply([]byte("1.6")), kvStore.IncrByFloat(cmd))
+		assert.Equal(t, handler.NewBulkReply([]byte("1.6")), kvStore.Get(newTestCmd(database.CmdTypeGet, "k")))
+		assert.True(t, handler.IsErrReply(kvStore.IncrByFloat(newTestCmd(database.CmdTypeIncrByFloat, "k", "x"))))
+	})
+}
+
+func Test_string_range(t *testing.T) {
+	data := []byte("hello world")
+	assert.Equal(t, []byte("hello"), getRange(data, 0, 4))
+	assert.Equal(t, []byte("world"), getRange(data, -5, -1))
+	assert.Equal(t, []byte("hello world"), getRange(data, -100, 100))
+	assert.Equal(t, []byte{}, getRange(data, 5, 2))
+	assert.Equal(t, []byte{}, getRange(data, -1, -5))
+	assert.Equal(t, []byte{}, getRange(nil, 0, -1))
+
+	str := newStringEntity("", []byte("ab"))
+	assert.Equal(t, int64(5), str.SetRange(3, []byte("cd")))
+	assert.Equal(t, []byte("ab\x00cd"), str.Bytes())
+	assert.Equal(t, int64(5), str.SetRange(1, []byte("x")))
+	assert.Equal(t, []byte("ax\x00cd"), str.Bytes())
+	assert.Equal(t, int64(7), str.Append([]byte("ef")))
+	assert.Equal(t, []byte("ax\x00cdef"), str.Bytes())
+}
+
+func Test_string_own_bytes(t *testing.T) {
+	kvStore := NewKVStore(testThinker{}).(*KVStore)
+	value := []byte("abc")
+	kvStore.Set(database.NewCommand(database.CmdTypeSet, [][]byte{[]byte("k"), value}))
+	kvStore.SetRange(newTestCmd(database.CmdTypeSetRange, "k", "0", "x"))
+
+	// 原地修改不能影响到指令参数
+	assert.Equal(t, []byte("abc"), value)
+	assert.Equal(t, handler.NewBulkReply([]byte("xbc")), kvStore.Get(newTestCmd(database.CmdTypeGet, "k")))
+
+	// 回复由连接协程异步写出，原地修改不能影响到尚未写出的回复
+	get := kvStore.Get(newTestCmd(database.CmdTypeGet, "k"))
+	getRange := kvStore.GetRange(newTestCmd(database.CmdTypeGetRange, "k", "0", "-1"))
+	mget := kvStore.MGet(newTestCmd(database.CmdTypeMGet, "k"))
+	kvStore.SetRange(newTestCmd(database.CmdTypeSetRange, "k", "0", "yyy"))
+	assert.Equal(t, handler.NewBulkReply([]byte("xbc")), get)
+	assert.Equal(t, handler.NewBulkReply([]byte("xbc")), getRange)
+	assert.Equal(t, handler.NewMultiBulkReply([][]byte{[]byte("xbc")}), mget)
+}
@@ -2,11 +2,13 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
 
+	"github.com/xiaoxuxiansheng/goredis/lib/pool"
 	"github.com/xiaoxuxiansheng/goredis/log"
 	"github.com/xiaoxuxiansheng/goredis/server"
 )
@@ -62,8 +64,12 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 }
 
 func (h *Handler) handle(ctx context.Context, conn io.ReadWriter) {
+	// 连接断开时取消 connCtx，使得阻塞中的指令能够及时退出
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// 持续处理
-	stream := h.parser.ParseStream(conn)
+	stream := h.relay(ctx, cancel, h.parser.ParseStream(conn))
 	for {
 		select {
 		case <-ctx.Done():
@@ -71,14 +77,60 @@ func (h *Handler) handle(ctx context.Context, conn io.ReadWriter) {
 			return
 
 		case droplet := <-stream:
-			if err := h.handleDroplet(ctx, conn, droplet); err != nil {
-				h.logger.Errorf("[handler]conn terminated, err: %s", droplet.Err.Error())
+			if err := h.handleDroplet(connCtx, conn, droplet); err != nil {
+				h.logger.Errorf("[handler]conn terminated, err: %s", err.Error())
 				return
 			}
 		}
 	}
 }
 
+// 转发队列中最多暂存的请求数. 队列满时暂停读取，依靠 tcp 流控约束客户端
+const relayPendingLimit = 16
+
+// 按序转发解析出的请求. 请求会暂存在队列中，使得指令阻塞期间仍然能够感知到连接断开
+func (h *Handler) relay(ctx context.Context, cancel context.CancelFunc, stream <-chan *Droplet) <-chan *Droplet {
+	out := make(chan *Droplet)
+	pool.Submit(func() {
+		pending := make([]*Droplet, 0, relayPendingLimit)
+		for {
+			var next *Droplet
+			var outCh chan<- *Droplet
+			if len(pending) > 0 {
+				next, outCh = pending[0], out
+			}
+			in := stream
+			if len(pending) >= relayPendingLimit {
+				in = nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case droplet := <-in:
+				// 之后不会再有新的请求
+				if droplet.Terminated() {
+					stream = nil
+					// EOF 可能只是客户端关闭了写端，仍在等待回复，因此由 handle 执行完已接收的指令后退出.
+					// 其他错误说明连接已断开，立即取消执行中的指令
+					if !errors.Is(droplet.Err, io.EOF) && !errors.Is(droplet.Err, io.ErrUnexpectedEOF) {
+						cancel()
+					}
+				}
+				pending = append(pending, droplet)
+
+			case outCh <- next:
+				pending = pending[1:]
+				if next.Terminated() {
+					return
+				}
+			}
+		}
+	})
+	return out
+}
+
 func (h *Handler) handleDroplet(ctx context.Context, conn io.ReadWriter, droplet *Droplet) error {
 	if droplet.Terminated() {
 		return droplet.Err
@@ -102,13 +154,14 @@ func (h *Handler) handleDroplet(ctx context.Context, conn io.ReadWriter, droplet
 		return nil
 	}
 
+	// 回复写入失败说明连接已断开
 	if reply := h.db.Do(ctx, multiReply.Args()); reply != nil {
-		_, _ = conn.Write(reply.ToBytes())
-		return nil
+		_, err := conn.Write(reply.ToBytes())
+		return err
 	}
 
-	_, _ = conn.Write(UnknownErrReplyBytes)
-	return nil
+	_, err := conn.Write(UnknownErrReplyBytes)
+	return err
 }
 
 func (h *Handler) Close() {
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_relay_limit(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream := make(chan *Droplet)
+	out := (&Handler{}).relay(ctx, cancel, stream)
+
+	send := func() bool {
+		select {
+		case stream <- &Droplet{}:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+
+	// 队列满后不再读取新的请求
+	for i := 0; i < relayPendingLimit; i++ {
+		assert.True(t, send())
+	}
+	assert.False(t, send())
+
+	// 消费一个请求后恢复读取
+	<-out
+	assert.True(t, send())
+	assert.False(t, send())
+	assert.NoError(t, connCtx.Err())
+}
+
+func Test_relay_eof(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	t.Run("eof", func(t *testing.T) {
+		connCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream := make(chan *Droplet)
+		out := (&Handler{}).relay(ctx, cancel, stream)
+		first, second := &Droplet{}, &Droplet{}
+		stream <- first
+		stream <- second
+		stream <- &Droplet{Err: io.EOF}
+
+		// 客户端可能只是关闭了写端，已接收的指令执行完之前不取消
+		assert.Equal(t, first, <-out)
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, connCtx.Err())
+		assert.Equal(t, second, <-out)
+		assert.True(t, (<-out).Terminated())
+		assert.NoError(t, connCtx.Err())
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		connCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream := make(chan *Droplet)
+		out := (&Handler{}).relay(ctx, cancel, stream)
+		stream <- &Droplet{}
+		stream <- &Droplet{Err: errors.New("use of closed network connection")}
+
+		// 连接已断开，立即取消
+		select {
+		case <-connCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("conn ctx not canceled")
+		}
+		assert.NoError(t, (<-out).Err)
+		assert.True(t, (<-out).Terminated())
+	})
+}
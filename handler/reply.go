@@ -104,6 +104,21 @@ func (e *ErrReply) ToBytes() []byte {
 	return []byte("-" + e.ErrStr + CRLF)
 }
 
+func (e *ErrReply) Error() string {
+	return e.ErrStr
+}
+
+// 各类错误 reply 的统一抽象
+type ErrorReply interface {
+	Reply
+	Error() string
+}
+
+func IsErrReply(reply Reply) bool {
+	_, ok := reply.(ErrorReply)
+	return ok
+}
+
 var (
 	nillReply     = &NillReply{}
 	nillBulkBytes = []byte("$-1\r\n")
@@ -121,6 +136,23 @@ func (n *NillReply) ToBytes() []byte {
 	return nillBulkBytes
 }
 
+var (
+	nillMultiBulkReply = &NillMultiBulkReply{}
+	nillMultiBulkBytes = []byte("*-1\r\n")
+)
+
+// nill 数组类型，采用全局单例，格式固定为 【*】【-1】【CRLF】
+type NillMultiBulkReply struct {
+}
+
+func NewNillMultiBulkReply() *NillMultiBulkReply {
+	return nillMultiBulkReply
+}
+
+func (n *NillMultiBulkReply) ToBytes() []byte {
+	return nillMultiBulkBytes
+}
+
 // 定长字符串类型，协议固定为 【$】【length】【CRLF】【content】【CRLF】
 type BulkReply struct {
 	Arg []byte
@@ -179,3 +211,23 @@ func NewEmptyMultiBulkReply() *EmptyMultiBulkReply {
 func (r *EmptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkBytes
 }
+
+// 嵌套数组类型. 协议为 【*】【arr.length】【CRLF】+ 各元素自身的协议内容，元素可以为任意类型的 reply
+type ArrayReply struct {
+	replies []Reply
+}
+
+func NewArrayReply(replies []Reply) *ArrayReply {
+	return &ArrayReply{
+		replies: replies,
+	}
+}
+
+func (a *ArrayReply) ToBytes() []byte {
+	var strBuf strings.Builder
+	strBuf.WriteString("*" + strconv.Itoa(len(a.replies)) + CRLF)
+	for _, reply := range a.replies {
+		strBuf.Write(reply.ToBytes())
+	}
+	return []byte(strBuf.String())
+}
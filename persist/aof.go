@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/xiaoxuxiansheng/goredis/datastore"
 	"github.com/xiaoxuxiansheng/goredis/handler"
 	"github.com/xiaoxuxiansheng/goredis/lib/pool"
 )
@@ -35,6 +36,7 @@ type aofPersister struct {
 	appendFsync            appendSyncStrategy
 	autoAofRewriteAfterCmd int64
 	aofCounter             atomic.Int64
+	dataStoreThinker       datastore.Thinker
 
 	mu   sync.Mutex
 	once sync.Once
@@ -53,6 +55,8 @@ func newAofPersister(thinker Thinker) (handler.Persister, error) {
 		buffer:      make(chan [][]byte, 1<<10),
 		aofFile:     aofFile,
 		aofFileName: aofFileName,
+
+		dataStoreThinker: thinker,
 	}
 
 	if autoAofRewriteAfterCmd := thinker.AutoAofRewriteAfterCmd(); autoAofRewriteAfterCmd > 1 {
@@ -3,12 +3,12 @@ package persist
 import (
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/xiaoxuxiansheng/goredis/database"
 	"github.com/xiaoxuxiansheng/goredis/datastore"
 	"github.com/xiaoxuxiansheng/goredis/handler"
-	"github.com/xiaoxuxiansheng/goredis/lib"
 	"github.com/xiaoxuxiansheng/goredis/log"
 	"github.com/xiaoxuxiansheng/goredis/protocol"
 )
@@ -59,12 +59,17 @@ func (a *aofPersister) doRewrite(tmpFile *os.File, fileSize int64) error {
 	// 将 db 数据转为 aof cmd
 	forkedDB.ForEach(func(key string, adapter database.CmdAdapter, expireAt *time.Time) {
 		_, _ = tmpFile.Write(handler.NewMultiBulkReply(adapter.ToCmd()).ToBytes())
+		if extra, ok := adapter.(database.ExtraCmdAdapter); ok {
+			for _, cmd := range extra.ExtraCmds() {
+				_, _ = tmpFile.Write(handler.NewMultiBulkReply(cmd).ToBytes())
+			}
+		}
 
 		if expireAt == nil {
 			return
 		}
 
-		expireCmd := [][]byte{[]byte(database.CmdTypeExpireAt), []byte(key), []byte(lib.TimeSecondFormat(*expireAt))}
+		expireCmd := [][]byte{[]byte(database.CmdTypePExpireAt), []byte(key), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))}
 		_, _ = tmpFile.Write(handler.NewMultiBulkReply(expireCmd).ToBytes())
 	})
 
@@ -80,8 +85,8 @@ func (a *aofPersister) forkDB(fileSize int64) (database.DataStore, error) {
 	logger := log.GetDefaultLogger()
 	reloader := readCloserAdapter(io.LimitReader(file, fileSize), file.Close)
 	fakePerisister := newFakePersister(reloader)
-	tmpKVStore := datastore.NewKVStore(fakePerisister)
-	executor := database.NewDBExecutor(tmpKVStore)
+	tmpKVStore := datastore.NewKVStore(a.dataStoreThinker)
+	executor := database.NewDBExecutor(tmpKVStore, fakePerisister)
 	trigger := database.NewDBTrigger(executor)
 	h, err := handler.NewHandler(trigger, fakePerisister, protocol.NewParser(logger), logger)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 
+	"github.com/xiaoxuxiansheng/goredis/datastore"
 	"github.com/xiaoxuxiansheng/goredis/handler"
 )
 
@@ -12,6 +13,8 @@ type Thinker interface {
 	AppendFileName() string
 	AppendFsync() string
 	AutoAofRewriteAfterCmd() int
+	// aof 重写时构造临时的存储介质
+	datastore.Thinker
 }
 
 func NewPersister(thinker Thinker) (handler.Persister, error) {